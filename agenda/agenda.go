@@ -0,0 +1,153 @@
+package agenda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/logging"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// Entry is a single occurrence in the agenda
+type Entry struct {
+	UID       string    `json:"uid"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	AllDay    bool      `json:"all_day"`
+	Summary   string    `json:"summary"`
+	Location  string    `json:"location,omitempty"`
+	Organizer string    `json:"organizer,omitempty"`
+	// Partstat is the calendar owner's participation status, if known
+	Partstat  string `json:"partstat,omitempty"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// Build expands the stored events overlapping the span from start to end
+// into a sorted agenda. The self addresses select whose PARTSTAT is shown.
+// Events that can't be parsed or expanded are logged and left out.
+func Build(ctx context.Context, store storage.Storage, start, end time.Time, selfAddresses []string, logger *slog.Logger) ([]Entry, error) {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+
+	events, err := store.QueryEvents(ctx, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("querying events: %w", err)
+	}
+
+	self := make(map[string]bool)
+	for _, address := range selfAddresses {
+		self[ical.CalendarAddress(address)] = true
+	}
+
+	var entries []Entry
+	for _, event := range events {
+		cal, err := ical.DecodeCalendar(event.RawData)
+		if err != nil {
+			logger.Warn("Skipping event that can't be parsed", "uid", event.UID, "error", err)
+			continue
+		}
+		occurrences, err := ical.Occurrences(cal, start, end)
+		if err != nil {
+			logger.Warn("Skipping event that can't be expanded", "uid", event.UID, "error", err)
+			continue
+		}
+
+		for _, occurrence := range occurrences {
+			entries = append(entries, newEntry(event.UID, occurrence, self))
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Start.Equal(entries[j].Start) {
+			return entries[i].Start.Before(entries[j].Start)
+		}
+		return entries[i].Summary < entries[j].Summary
+	})
+	return entries, nil
+}
+
+// newEntry describes an occurrence for the agenda
+func newEntry(uid string, occurrence ical.Occurrence, self map[string]bool) Entry {
+	props := occurrence.Component.Props
+	entry := Entry{
+		UID:    uid,
+		Start:  occurrence.Start,
+		End:    occurrence.End,
+		AllDay: occurrence.AllDay,
+	}
+
+	if prop := props.Get("SUMMARY"); prop != nil {
+		entry.Summary = prop.Value
+	}
+	if prop := props.Get("LOCATION"); prop != nil {
+		entry.Location = prop.Value
+	}
+	if prop := props.Get("ORGANIZER"); prop != nil {
+		entry.Organizer = ical.CalendarAddress(prop.Value)
+	}
+	if prop := props.Get("STATUS"); prop != nil && prop.Value == "CANCELLED" {
+		entry.Cancelled = true
+	}
+	for _, attendee := range props.Values("ATTENDEE") {
+		if self[ical.CalendarAddress(attendee.Value)] {
+			entry.Partstat = attendee.Params.Get("PARTSTAT")
+			break
+		}
+	}
+	return entry
+}
+
+// WriteText prints the agenda with one line per occurrence and times in the
+// given location
+func WriteText(w io.Writer, entries []Entry, loc *time.Location) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "No events")
+		return err
+	}
+
+	for _, entry := range entries {
+		start := entry.Start.In(loc)
+		when := start.Format("Mon 2006-01-02 15:04") + "-" + entry.End.In(loc).Format("15:04")
+		if entry.AllDay {
+			// All-day values are dates, not instants
+			when = entry.Start.Format("Mon 2006-01-02") + " all day  "
+		}
+
+		line := []string{when, entry.Summary}
+		if entry.Location != "" {
+			line = append(line, "@ "+entry.Location)
+		}
+		if entry.Organizer != "" {
+			line = append(line, "("+entry.Organizer+")")
+		}
+		if entry.Partstat != "" {
+			line = append(line, "["+entry.Partstat+"]")
+		}
+		if entry.Cancelled {
+			line = append(line, "[CANCELLED]")
+		}
+
+		if _, err := fmt.Fprintln(w, strings.Join(line, "  ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON prints the agenda as a JSON array
+func WriteJSON(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
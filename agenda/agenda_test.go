@@ -0,0 +1,210 @@
+package agenda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func storeCalendar(t *testing.T, store *storage.MemoryStorage, data string) {
+	t.Helper()
+
+	event, err := ical.ParseICalData([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+	if err := store.StoreEvent(event); err != nil {
+		t.Fatalf("Failed to store event: %v", err)
+	}
+}
+
+func newAgendaStore(t *testing.T) *storage.MemoryStorage {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	storeCalendar(t, store, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:agenda-weekly
+SUMMARY:Weekly sync
+DTSTART:20250303T090000Z
+DTEND:20250303T100000Z
+RRULE:FREQ=WEEKLY;COUNT=3
+LOCATION:Room 1
+ORGANIZER:mailto:Organizer@Example.com
+ATTENDEE;PARTSTAT=ACCEPTED:mailto:me@example.com
+DTSTAMP:20250301T000000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:agenda-weekly
+SUMMARY:Weekly sync
+RECURRENCE-ID:20250310T090000Z
+DTSTART:20250310T090000Z
+DTEND:20250310T100000Z
+STATUS:CANCELLED
+DTSTAMP:20250302T000000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	storeCalendar(t, store, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:agenda-single
+SUMMARY:Lunch
+DTSTART:20250305T110000Z
+DTEND:20250305T120000Z
+DTSTAMP:20250301T000000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	storeCalendar(t, store, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:agenda-outside
+SUMMARY:Next month
+DTSTART:20250405T110000Z
+DTEND:20250405T120000Z
+DTSTAMP:20250301T000000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	return store
+}
+
+func TestBuild(t *testing.T) {
+	store := newAgendaStore(t)
+
+	entries, err := Build(context.Background(), store,
+		time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		[]string{"me@example.com"}, nil)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	want := []struct {
+		summary   string
+		start     string
+		cancelled bool
+	}{
+		{"Weekly sync", "2025-03-03T09:00:00Z", false},
+		{"Lunch", "2025-03-05T11:00:00Z", false},
+		{"Weekly sync", "2025-03-10T09:00:00Z", true},
+		{"Weekly sync", "2025-03-17T09:00:00Z", false},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Build() returned %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		entry := entries[i]
+		if entry.Summary != w.summary || entry.Start.UTC().Format(time.RFC3339) != w.start || entry.Cancelled != w.cancelled {
+			t.Errorf("entry %d = %s %s cancelled=%v, want %s %s cancelled=%v", i,
+				entry.Summary, entry.Start.UTC().Format(time.RFC3339), entry.Cancelled, w.summary, w.start, w.cancelled)
+		}
+	}
+
+	first := entries[0]
+	if first.Location != "Room 1" || first.Organizer != "organizer@example.com" || first.Partstat != "ACCEPTED" {
+		t.Errorf("first entry = %+v, missing location, organizer or PARTSTAT", first)
+	}
+}
+
+// serverStorage returns all events from QueryEvents like a server that
+// filters with its own, more lenient parser
+type serverStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s serverStorage) QueryEvents(context.Context, time.Time, time.Time) ([]*ical.Event, error) {
+	return s.ListEvents()
+}
+
+func TestBuild_SkipsBrokenEvents(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	storeCalendar(t, store, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:agenda-single
+SUMMARY:Lunch
+DTSTART:20250305T110000Z
+DTEND:20250305T120000Z
+DTSTAMP:20250301T000000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	for uid, data := range map[string]string{
+		"agenda-bad-tzid":  "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nBEGIN:VEVENT\r\nUID:agenda-bad-tzid\r\nDTSTAMP:20250301T000000Z\r\nDTSTART;TZID=Nowhere Standard Time:20250306T100000\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"agenda-bad-rrule": "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nBEGIN:VEVENT\r\nUID:agenda-bad-rrule\r\nDTSTAMP:20250301T000000Z\r\nDTSTART:20250306T100000Z\r\nRRULE:FREQ=SOMETIMES\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"agenda-garbage":   "not a calendar",
+	} {
+		if err := store.StoreEvent(&ical.Event{UID: uid, RawData: []byte(data)}); err != nil {
+			t.Fatalf("Failed to store event: %v", err)
+		}
+	}
+
+	var logs bytes.Buffer
+	entries, err := Build(context.Background(), serverStorage{store},
+		time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		nil, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].UID != "agenda-single" {
+		t.Errorf("Build() = %+v, want only agenda-single", entries)
+	}
+	for _, uid := range []string{"agenda-bad-tzid", "agenda-bad-rrule", "agenda-garbage"} {
+		if !strings.Contains(logs.String(), uid) {
+			t.Errorf("Expected %s to be logged as skipped, got %s", uid, logs.String())
+		}
+	}
+}
+
+func TestWriteTextAndJSON(t *testing.T) {
+	store := newAgendaStore(t)
+	entries, err := Build(context.Background(), store,
+		time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC),
+		[]string{"me@example.com"}, nil)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	var text bytes.Buffer
+	if err := WriteText(&text, entries, berlin); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	wantLine := "Mon 2025-03-03 10:00-11:00  Weekly sync  @ Room 1  (organizer@example.com)  [ACCEPTED]"
+	if strings.TrimSpace(text.String()) != wantLine {
+		t.Errorf("WriteText() = %q, want %q", text.String(), wantLine)
+	}
+
+	var out bytes.Buffer
+	if err := WriteJSON(&out, entries); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON() produced invalid JSON: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0]["uid"] != "agenda-weekly" || decoded[0]["partstat"] != "ACCEPTED" {
+		t.Errorf("WriteJSON() = %s", out.String())
+	}
+
+	out.Reset()
+	if err := WriteJSON(&out, nil); err != nil || strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("WriteJSON(nil) = %q, %v, want []", out.String(), err)
+	}
+}
@@ -1,14 +1,20 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"regexp"
+	"strings"
 
 	"github.com/adrg/xdg"
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/logging"
+	"github.com/mkbrechtel/calmailproc/outbound"
 	"github.com/mkbrechtel/calmailproc/processor"
 	"github.com/mkbrechtel/calmailproc/processor/maildir"
-	"github.com/mkbrechtel/calmailproc/processor/stdin"
 	"github.com/mkbrechtel/calmailproc/storage"
 	"gopkg.in/yaml.v3"
 )
@@ -23,105 +29,418 @@ type Config struct {
 	Processor processor.ProcessorConfig `yaml:"processor"`
 	Maildir   maildir.MaildirConfig    `yaml:"maildir"`
 	Stdin     StdinConfig              `yaml:"stdin"`
+	Outbound  outbound.Config          `yaml:"outbound"`
+	Log       logging.Config           `yaml:"log"`
 
-	ProcessReplies bool
-	URL            string
-	User           string
-	Pass           string
-	Calendar       string
-	MaildirPath    string
-	Verbose        bool
+	// Profiles are named accounts, selected with -profile
+	Profiles map[string]*Profile `yaml:"profiles"`
+
+	Profile        string `yaml:"-"`
+	ProcessReplies bool   `yaml:"-"`
+	URL            string `yaml:"-"`
+	User           string `yaml:"-"`
+	Pass           string `yaml:"-"`
+	Calendar       string `yaml:"-"`
+	TaskCalendar   string `yaml:"-"`
+	MaildirPath    string `yaml:"-"`
+	Verbose        bool   `yaml:"-"`
+	StatsFile      string `yaml:"-"`
+	StatsFormat    string `yaml:"-"`
+	LogLevel       string `yaml:"-"`
+	LogFormat      string `yaml:"-"`
+	LogOutput      string `yaml:"-"`
+
+	// Logger is created from Log by openLogger
+	Logger *slog.Logger `yaml:"-"`
+
+	// Args are the positional arguments: a command and its arguments
+	Args []string `yaml:"-"`
+
+	// setFlags are the names of the flags given on the command line
+	setFlags map[string]bool
+}
+
+// configFilePath returns the path of the XDG config file
+func configFilePath() (string, error) {
+	return xdg.ConfigFile("calmailproc/config.yaml")
 }
 
 func loadConfigFile() (*Config, error) {
-	configPath, err := xdg.ConfigFile("calmailproc/config.yaml")
+	configPath, err := configFilePath()
 	if err != nil {
 		return nil, err
 	}
 
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return &Config{}, nil
+		return defaultConfig(), nil
 	}
 
+	return readConfigFile(configPath)
+}
+
+// defaultConfig returns the settings used where neither the config file,
+// the environment nor a flag sets a value
+func defaultConfig() *Config {
+	return &Config{}
+}
+
+// errInsecureConfig is returned for config files others can read that
+// contain a password
+var errInsecureConfig = errors.New("insecure config file")
+
+// envReference matches ${NAME} references to environment variables
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// passwordKeys are the config keys holding passwords and other secrets
+var passwordKeys = map[string]bool{"pass": true, "smtp_pass": true, "client_secret": true, "refresh_token": true}
+
+// readConfigFile parses a YAML config file, replacing ${NAME} in values
+// with the environment variable. Files readable by all users must not
+// contain plaintext passwords.
+func readConfigFile(configPath string) (*Config, error) {
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	return &config, nil
+	if key := plaintextPassword(&root); key != "" && info.Mode().Perm()&0004 != 0 {
+		return nil, fmt.Errorf("%w: %s contains a plaintext %s and is readable by all users, "+
+			"restrict it with chmod 600 or use pass_file, pass_command or ${ENV} references",
+			errInsecureConfig, configPath, key)
+	}
+	if err := expandEnv(&root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	config := defaultConfig()
+	if len(root.Content) > 0 {
+		if err := root.Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		if err := decodeProfiles(&root, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	return config, nil
 }
 
+// plaintextPassword returns the key of a password that is given in the
+// YAML itself rather than referencing the environment
+func plaintextPassword(node *yaml.Node) string {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if passwordKeys[key.Value] && value.Kind == yaml.ScalarNode &&
+				value.Value != "" && !envReference.MatchString(value.Value) {
+				return key.Value
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if key := plaintextPassword(child); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// expandEnv replaces ${NAME} references in all string values, a reference
+// to an unset variable is an error
+func expandEnv(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		var missing []string
+		node.Value = envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			name := envReference.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("line %d: environment variable %s is not set", node.Line, strings.Join(missing, ", "))
+		}
+	}
+	for _, child := range node.Content {
+		if err := expandEnv(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseFlags builds the configuration from its layers, each overriding
+// the ones before: defaults, the config file, CALMAILPROC_* environment
+// variables and the command line flags
 func ParseFlags() *Config {
 	config, err := loadConfigFile()
+	if errors.Is(err, errInsecureConfig) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitConfig)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to load config file: %v\n", err)
-		config = &Config{}
+		config = defaultConfig()
 	}
 
-	flag.BoolVar(&config.ProcessReplies, "process-replies", config.Processor.ProcessReplies, "Process attendance replies to update events")
+	flag.Usage = usage
+	config, err = parseCommandLine(config, flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	return config
+}
+
+// envPrefix is the prefix of environment variables setting flags, e.g.
+// CALMAILPROC_PROCESS_REPLIES=false for -process-replies=false
+const envPrefix = "CALMAILPROC_"
+
+// envName returns the environment variable of a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseCommandLine applies the environment and the flags in args to a
+// configuration loaded from the config file. Only flags and variables that
+// are actually given override it, so that false and empty values work as
+// well.
+func parseCommandLine(config *Config, flags *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	flags.BoolVar(&config.ProcessReplies, "process-replies", config.Processor.ProcessReplies, "Process attendance replies to update events")
+
+	flags.StringVar(&config.URL, "url", config.WebDAV.URL, "CalDAV server URL (e.g., http://localhost:5232)")
+	flags.StringVar(&config.User, "user", config.WebDAV.User, "CalDAV username")
+	flags.StringVar(&config.Pass, "pass", "", "CalDAV password (visible to other users in the process list, prefer pass_file or pass_command)")
+	flags.StringVar(&config.Calendar, "calendar", config.WebDAV.Calendar, "CalDAV calendar path (e.g., /calendar/)")
+	flags.StringVar(&config.TaskCalendar, "task-calendar", config.WebDAV.TaskCalendar, "CalDAV collection for tasks (VTODO), defaults to -calendar")
+
+	flags.StringVar(&config.MaildirPath, "maildir", config.Maildir.Path, "Path to maildir to process (will process all emails recursively)")
+	flags.BoolVar(&config.Verbose, "verbose", config.Maildir.Verbose, "Enable verbose logging output")
+	flags.StringVar(&config.StatsFile, "stats-file", config.Maildir.StatsFile, "Write a summary of every maildir run to this file, - for stdout")
+	flags.StringVar(&config.StatsFormat, "stats-format", config.Maildir.StatsFormat, "Format of the stats file: text or json")
+	flags.StringVar(&config.LogLevel, "log-level", config.Log.Level, "Log level: debug, info, warn or error")
+	flags.StringVar(&config.LogFormat, "log-format", config.Log.Format, "Log format: text or json")
+	flags.StringVar(&config.LogOutput, "log-output", config.Log.Output, "Log output: stderr, syslog or journald")
+
+	flags.StringVar(&config.Profile, "profile", "", "Use the named profile of the config file")
+
+	// Environment variables are set like flags first, so that flags given
+	// on the command line override them
+	var envErr error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok || envErr != nil {
+			return
+		}
+		if err := flags.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("invalid %s: %w", envName(f.Name), err)
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	config.Args = flags.Args()
 
-	flag.StringVar(&config.URL, "url", config.WebDAV.URL, "CalDAV server URL (e.g., http://localhost:5232)")
-	flag.StringVar(&config.User, "user", config.WebDAV.User, "CalDAV username")
-	flag.StringVar(&config.Pass, "pass", config.WebDAV.Pass, "CalDAV password")
-	flag.StringVar(&config.Calendar, "calendar", config.WebDAV.Calendar, "CalDAV calendar path (e.g., /calendar/)")
+	config.setFlags = make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { config.setFlags[f.Name] = true })
 
-	flag.StringVar(&config.MaildirPath, "maildir", config.Maildir.Path, "Path to maildir to process (will process all emails recursively)")
-	flag.BoolVar(&config.Verbose, "verbose", config.Maildir.Verbose, "Enable verbose logging output")
+	if config.Profile != "" {
+		profileConfig, err := config.UseProfile(config.Profile)
+		if err != nil {
+			return nil, err
+		}
+		config = profileConfig
+	}
+	config.applyFlags()
 
-	flag.Parse()
+	return config, nil
+}
 
-	if config.URL != "" {
+// applyFlags lets the flags given on the command line override the
+// configuration
+func (config *Config) applyFlags() {
+	if config.setFlags["url"] {
 		config.WebDAV.URL = config.URL
 	}
-	if config.User != "" {
+	if config.setFlags["user"] {
 		config.WebDAV.User = config.User
 	}
-	if config.Pass != "" {
+	if config.setFlags["pass"] {
 		config.WebDAV.Pass = config.Pass
 	}
-	if config.Calendar != "" {
+	if config.setFlags["calendar"] {
 		config.WebDAV.Calendar = config.Calendar
 	}
-	if config.ProcessReplies {
+	if config.setFlags["task-calendar"] {
+		config.WebDAV.TaskCalendar = config.TaskCalendar
+	}
+	if config.setFlags["process-replies"] {
 		config.Processor.ProcessReplies = config.ProcessReplies
 	}
-	if config.MaildirPath != "" {
+	if config.setFlags["maildir"] {
 		config.Maildir.Path = config.MaildirPath
 	}
-	if config.Verbose {
+	if config.setFlags["verbose"] {
 		config.Maildir.Verbose = config.Verbose
 	}
-
-	return config
+	if config.setFlags["stats-file"] {
+		config.Maildir.StatsFile = config.StatsFile
+	}
+	if config.setFlags["stats-format"] {
+		config.Maildir.StatsFormat = config.StatsFormat
+	}
+	if config.setFlags["log-level"] {
+		config.Log.Level = config.LogLevel
+	}
+	if config.setFlags["log-format"] {
+		config.Log.Format = config.LogFormat
+	}
+	if config.setFlags["log-output"] {
+		config.Log.Output = config.LogOutput
+	}
 }
 
+// Run runs the command selected by the positional arguments. Without a
+// command, emails are processed from stdin, or from the maildir if one is
+// configured, as calmailproc always did.
 func Run(config *Config) error {
-	if config.WebDAV.URL == "" || config.WebDAV.User == "" || config.WebDAV.Pass == "" || config.WebDAV.Calendar == "" {
-		return fmt.Errorf("all CalDAV flags are required: -url, -user, -pass, -calendar")
+	args := config.Args
+	if len(args) == 0 {
+		if config.Maildir.Path != "" {
+			args = []string{"maildir"}
+		} else {
+			args = []string{"process"}
+		}
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		flag.Usage()
+		return &exitError{err: fmt.Errorf("unknown command: %s", args[0]), code: ExitUsage}
+	}
+
+	// The usage is shown for invalid flags of the command
+	usageShown := false
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		usageShown = true
+		commandUsage(flags, cmd)
+	}
+	err := cmd.run(config, flags, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil && usageShown {
+		return &exitError{err: err, code: ExitUsage}
+	}
+	return err
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: %s [flags] [command] [arguments]
+
+Without a command, a calendar email is read from stdin, or the -maildir is
+processed. Run "%s help <command>" for the flags of a command. Flags can
+also be set as environment variables, like CALMAILPROC_PROCESS_REPLIES=false.
+
+Commands:
+`, os.Args[0], os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// openLogger returns the logger of the configuration, creating it on first
+// use. -verbose selects the debug level if no level is configured.
+func openLogger(config *Config) (*slog.Logger, error) {
+	if config.Logger != nil {
+		return config.Logger, nil
 	}
 
+	logConfig := config.Log
+	if logConfig.Level == "" && config.Maildir.Verbose {
+		logConfig.Level = "debug"
+	}
+	logger, err := logging.New(logConfig, os.Stderr)
+	if err != nil {
+		return nil, configError(fmt.Errorf("error initializing logging: %w", err))
+	}
+	config.Logger = logger
+	return logger, nil
+}
+
+// openStorage creates the CalDAV storage from the configuration
+func openStorage(config *Config) (storage.Storage, error) {
+	if config.WebDAV.URL == "" || config.WebDAV.Calendar == "" {
+		return nil, configError(fmt.Errorf("all CalDAV flags are required: -url, -user, -calendar"))
+	}
+	basicAuth := config.WebDAV.Auth == "" || config.WebDAV.Auth == storage.AuthBasic
+	if basicAuth && config.WebDAV.User == "" {
+		return nil, configError(fmt.Errorf("all CalDAV flags are required: -url, -user, -calendar"))
+	}
+
+	logger, err := openLogger(config)
+	if err != nil {
+		return nil, err
+	}
+	// The password, token and certificates are read here, failing to do
+	// so is a problem of the configuration
 	store, err := storage.NewCalDAVStorageFromConfig(config.WebDAV)
 	if err != nil {
-		return fmt.Errorf("error initializing CalDAV storage: %w", err)
+		return nil, configError(fmt.Errorf("error initializing CalDAV storage: %w", err))
 	}
+	store.SetLogger(logger)
+	return store, nil
+}
 
-	proc := processor.NewProcessorFromConfig(store, config.Processor)
+// openJournaledStorage creates the storage for commands that change it
+// directly, recording their changes if a journal is configured
+func openJournaledStorage(config *Config) (storage.Storage, error) {
+	store, err := openStorage(config)
+	if err != nil || config.Processor.JournalFile == "" {
+		return store, err
+	}
 
-	if config.Maildir.Path != "" {
-		if err := maildir.ProcessWithConfig(config.Maildir, proc); err != nil {
-			return fmt.Errorf("error processing maildir: %w", err)
-		}
-		return nil
+	j, err := journal.Open(config.Processor.JournalFile)
+	if err != nil {
+		return nil, err
 	}
+	return journal.NewStorage(store, j), nil
+}
 
-	if err := stdin.Process(proc); err != nil {
-		return fmt.Errorf("error processing stdin: %w", err)
+// newProcessor creates the storage and a processor with outbound mail
+func newProcessor(config *Config) (*processor.Processor, error) {
+	store, err := openStorage(config)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	proc, err := processor.NewProcessorFromConfig(store, config.Processor)
+	if err != nil {
+		return nil, configError(fmt.Errorf("error initializing processor: %w", err))
+	}
+	proc.Logger = config.Logger
+
+	proc.Replier, err = outbound.NewReplierFromConfig(config.Outbound)
+	if err != nil {
+		return nil, configError(fmt.Errorf("error initializing outbound mail: %w", err))
+	}
+	return proc, nil
+}
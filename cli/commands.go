@@ -0,0 +1,441 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/agenda"
+	"github.com/mkbrechtel/calmailproc/inspect"
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/outbound"
+	"github.com/mkbrechtel/calmailproc/processor/maildir"
+	"github.com/mkbrechtel/calmailproc/processor/stdin"
+	"github.com/mkbrechtel/calmailproc/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// command is a subcommand of calmailproc. Its run function defines the
+// command's flags on the given FlagSet and parses the arguments with it.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(config *Config, flags *flag.FlagSet, args []string) error
+}
+
+// commands are listed in this order in the usage
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"process", "[FILE...]", "Process calendar emails from files, or one from stdin", runProcess},
+		{"maildir", "[-verbose] [PATH]", "Process all emails in a maildir recursively", runMaildir},
+		{"run-all", "", "Process the maildir of every profile", runAll},
+		{"agenda", "[-from DATE] [-to DATE] [-format text|json]", "Show the stored events of a time span", runAgenda},
+		{"show", "<UID>", "Show a stored object with its exceptions and history", runShow},
+		{"history", "<UID>", "Show the recorded changes of an object", runHistory},
+		{"list", "[-format text|json]", "List the stored events, tasks and journal entries", runList},
+		{"delete", "[-dry-run] <UID>...", "Delete stored objects", runDelete},
+		{"export", "[-dir DIR] [UID...]", "Write stored objects as iCalendar data", runExport},
+		{"import", "[-dry-run] [-replace] FILE...", "Store the objects of iCalendar files", runImport},
+		{"verify", "", "Check the stored objects for problems", runVerify},
+		{"rsvp", "<UID> accept|decline|tentative", "Answer an invitation", runRSVP},
+		{"undo", "-since TIME|-message MESSAGE-ID [-dry-run] [-force]", "Revert changes recorded in the journal", runUndo},
+		{"config", "", "Print the effective configuration", runConfig},
+		{"doctor", "[-skip-write]", "Check the configuration, maildirs and CalDAV server", runDoctor},
+		{"help", "[COMMAND]", "Show the help of a command", runHelp},
+	}
+}
+
+// findCommand returns the command with the name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// commandUsage prints the help of a command
+func commandUsage(flags *flag.FlagSet, cmd *command) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] %s %s\n\n%s\n", os.Args[0], cmd.name, cmd.args, cmd.summary)
+
+	hasFlags := false
+	flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(out, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+}
+
+// usageError reports wrong arguments together with the command's usage
+func usageError(flags *flag.FlagSet) error {
+	cmd := findCommand(flags.Name())
+	return &exitError{err: fmt.Errorf("usage: %s %s", cmd.name, cmd.args), code: ExitUsage}
+}
+
+// runHelp shows the usage of calmailproc or of one command
+func runHelp(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.Usage()
+		return nil
+	}
+
+	cmd := findCommand(flags.Arg(0))
+	if cmd == nil {
+		return fmt.Errorf("unknown command: %s", flags.Arg(0))
+	}
+	cmdFlags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmdFlags.SetOutput(os.Stdout)
+	cmdFlags.Usage = func() { commandUsage(cmdFlags, cmd) }
+	return cmd.run(config, cmdFlags, []string{"-h"})
+}
+
+// runProcess processes emails from files, or a single email from stdin
+func runProcess(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	proc, err := newProcessor(config)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		if err := stdin.Process(proc, config.Logger); err != nil {
+			return fmt.Errorf("error processing stdin: %w", err)
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, path := range flags.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			config.Logger.Error("Opening email failed", "path", path, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		// Processing errors are logged by the processor
+		msg, err := proc.ProcessEmailFrom(f, path)
+		f.Close()
+		fmt.Printf("%s > %s\n", path, msg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return &joinedError{msg: fmt.Sprintf("failed to process %d of %d files", len(errs), flags.NArg()), errs: errs}
+	}
+	return nil
+}
+
+// runMaildir processes all emails of a maildir
+func runMaildir(config *Config, flags *flag.FlagSet, args []string) error {
+	verbose := flags.Bool("verbose", config.Maildir.Verbose, "Enable verbose logging output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return usageError(flags)
+	}
+
+	// -verbose is set before the logger is created, it selects the level
+	config.Maildir.Verbose = *verbose
+	maildirConfig := config.Maildir
+	if flags.NArg() == 1 {
+		maildirConfig.Path = flags.Arg(0)
+	}
+	if maildirConfig.Path == "" {
+		return configError(fmt.Errorf("no maildir given, pass a path or set -maildir"))
+	}
+
+	proc, err := newProcessor(config)
+	if err != nil {
+		return err
+	}
+	summary, err := maildir.Run(maildirConfig, proc, config.Logger)
+	if err != nil {
+		return fmt.Errorf("error processing maildir: %w", err)
+	}
+	return summary.Err()
+}
+
+// runRSVP answers a stored invitation
+func runRSVP(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError(flags)
+	}
+
+	partstat, err := outbound.ParsePartstat(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	proc, err := newProcessor(config)
+	if err != nil {
+		return err
+	}
+
+	msg, err := proc.RSVP(flags.Arg(0), partstat)
+	fmt.Println(msg)
+	if err != nil {
+		return fmt.Errorf("error answering invitation: %w", err)
+	}
+	return nil
+}
+
+// runAgenda prints the stored events of a time span
+func runAgenda(config *Config, flags *flag.FlagSet, args []string) error {
+	from := flags.String("from", "", "Start of the agenda as YYYY-MM-DD or RFC 3339 time (default today)")
+	to := flags.String("to", "", "End of the agenda as YYYY-MM-DD or RFC 3339 time (default 7 days after -from)")
+	format := flags.String("format", "text", "Output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if *from != "" {
+		var err error
+		if start, err = parseAgendaTime(*from); err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	end := start.AddDate(0, 0, 7)
+	if *to != "" {
+		var err error
+		if end, err = parseAgendaTime(*to); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	if !end.After(start) {
+		return fmt.Errorf("-to must be after -from")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown agenda format: %s", *format)
+	}
+
+	store, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+
+	entries, err := agenda.Build(context.Background(), store, start, end, config.Processor.SelfAddresses, config.Logger)
+	if err != nil {
+		return fmt.Errorf("error building agenda: %w", err)
+	}
+
+	if *format == "json" {
+		return agenda.WriteJSON(os.Stdout, entries)
+	}
+	return agenda.WriteText(os.Stdout, entries, time.Local)
+}
+
+// parseAgendaTime parses a date in the local zone or an RFC 3339 time
+func parseAgendaTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// runShow pretty-prints a stored calendar object, followed by its history
+// if a journal is kept
+func runShow(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError(flags)
+	}
+	uid := flags.Arg(0)
+
+	store, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+
+	event, err := store.GetEvent(uid)
+	if err != nil {
+		return fmt.Errorf("error getting event %s: %w", uid, err)
+	}
+	if err := inspect.WriteObject(os.Stdout, event); err != nil {
+		return err
+	}
+
+	if config.Processor.JournalFile == "" {
+		return nil
+	}
+	entries, err := readHistory(config, uid)
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nHistory")
+	inspect.WriteHistory(os.Stdout, entries)
+	return nil
+}
+
+// runHistory prints the journal entries of a calendar object, it only
+// needs the journal and not the storage
+func runHistory(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError(flags)
+	}
+	if config.Processor.JournalFile == "" {
+		return configError(fmt.Errorf("no journal configured, set processor.journal_file"))
+	}
+
+	entries, err := readHistory(config, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	inspect.WriteHistory(os.Stdout, entries)
+	return nil
+}
+
+// readHistory reads the journal entries of one UID
+func readHistory(config *Config, uid string) ([]journal.Entry, error) {
+	j, err := journal.Open(config.Processor.JournalFile)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := j.History(uid)
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+	return entries, nil
+}
+
+// runUndo reverts the changes recorded in the journal since a time or for
+// one email
+func runUndo(config *Config, flags *flag.FlagSet, args []string) error {
+	since := flags.String("since", "", "Undo the changes since this time: YYYY-MM-DD, RFC 3339 time or a duration like 2h")
+	messageID := flags.String("message", "", "Undo the changes caused by the email with this Message-ID")
+	dryRun := flags.Bool("dry-run", false, "Only show what would be undone")
+	force := flags.Bool("force", false, "Also undo objects that were changed later")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*since == "") == (*messageID == "") || flags.NArg() > 0 {
+		return usageError(flags)
+	}
+	if config.Processor.JournalFile == "" {
+		return configError(fmt.Errorf("no journal configured, set processor.journal_file"))
+	}
+
+	j, err := journal.Open(config.Processor.JournalFile)
+	if err != nil {
+		return err
+	}
+
+	var entries []journal.Entry
+	if *since != "" {
+		t, err := parseUndoTime(*since)
+		if err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+		entries, err = j.Since(t)
+	} else {
+		entries, err = j.ByMessage(*messageID)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading journal: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No recorded changes to undo")
+		return nil
+	}
+
+	store, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+
+	// The undo is recorded as well, so it can be undone in turn
+	results, err := journal.Undo(journal.NewStorage(store, j), entries, journal.UndoOptions{
+		Force:  *force,
+		DryRun: *dryRun,
+	})
+	for _, msg := range results {
+		fmt.Println(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("error undoing changes: %w", err)
+	}
+	return nil
+}
+
+// parseUndoTime parses a time like parseAgendaTime or a duration before now
+func parseUndoTime(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return parseAgendaTime(value)
+}
+
+// runConfig prints the configuration after applying the flags, with
+// passwords masked
+func runConfig(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError(flags)
+	}
+
+	return writeConfig(os.Stdout, config)
+}
+
+// writeConfig writes the configuration as YAML with all secrets masked,
+// including those of the profiles
+func writeConfig(w io.Writer, config *Config) error {
+	effective := *config
+	maskSecrets(&effective.WebDAV, &effective.Outbound)
+	if config.Profiles != nil {
+		effective.Profiles = make(map[string]*Profile, len(config.Profiles))
+		for name, profile := range config.Profiles {
+			masked := *profile
+			maskSecrets(&masked.WebDAV, &masked.Outbound)
+			effective.Profiles[name] = &masked
+		}
+	}
+
+	data, err := yaml.Marshal(&effective)
+	if err != nil {
+		return fmt.Errorf("encoding configuration: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// maskSecrets replaces the passwords and tokens that are set
+func maskSecrets(webdav *storage.WebdavConfig, out *outbound.Config) {
+	for _, secret := range []*string{
+		&webdav.Pass,
+		&webdav.OAuth2.ClientSecret,
+		&webdav.OAuth2.RefreshToken,
+		&out.SMTPPass,
+	} {
+		if *secret != "" {
+			*secret = "********"
+		}
+	}
+}
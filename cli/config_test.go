@@ -0,0 +1,284 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfig loads a config file with the YAML content (none if empty) and
+// applies the environment and arguments
+func testConfig(t *testing.T, yamlContent string, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+
+	config := defaultConfig()
+	if yamlContent != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(yamlContent), 0600); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if config, err = readConfigFile(path); err != nil {
+			t.Fatalf("readConfigFile() error = %v", err)
+		}
+	}
+
+	flags := flag.NewFlagSet("calmailproc", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	return parseCommandLine(config, flags, args, lookupEnv)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	// Each layer sets a value that differs from the layer below it, so the
+	// highest layer present must win
+	settings := []struct {
+		name    string
+		yaml    string
+		env     string
+		flag    string
+		get     func(*Config) string
+		layered [4]string // default, file, env, flag
+	}{
+		{
+			name:    "process-replies",
+			yaml:    "processor:\n  process_replies: true\n",
+			env:     "false",
+			flag:    "-process-replies=true",
+			get:     func(c *Config) string { return fmt.Sprint(c.Processor.ProcessReplies) },
+			layered: [4]string{"false", "true", "false", "true"},
+		},
+		{
+			name:    "verbose",
+			yaml:    "maildir:\n  verbose: true\n",
+			env:     "false",
+			flag:    "-verbose=true",
+			get:     func(c *Config) string { return fmt.Sprint(c.Maildir.Verbose) },
+			layered: [4]string{"false", "true", "false", "true"},
+		},
+		{
+			name:    "url",
+			yaml:    "webdav:\n  url: http://file.example.com\n",
+			env:     "http://env.example.com",
+			flag:    "-url=http://flag.example.com",
+			get:     func(c *Config) string { return c.WebDAV.URL },
+			layered: [4]string{"", "http://file.example.com", "http://env.example.com", "http://flag.example.com"},
+		},
+		{
+			name:    "maildir",
+			yaml:    "maildir:\n  path: /mail/file\n",
+			env:     "",
+			flag:    "-maildir=/mail/flag",
+			get:     func(c *Config) string { return c.Maildir.Path },
+			layered: [4]string{"", "/mail/file", "", "/mail/flag"},
+		},
+		{
+			name:    "log-level",
+			yaml:    "log:\n  level: warn\n",
+			env:     "error",
+			flag:    "-log-level=debug",
+			get:     func(c *Config) string { return c.Log.Level },
+			layered: [4]string{"", "warn", "error", "debug"},
+		},
+	}
+
+	for _, setting := range settings {
+		for combination := 0; combination < 8; combination++ {
+			withFile := combination&1 != 0
+			withEnv := combination&2 != 0
+			withFlag := combination&4 != 0
+
+			name := fmt.Sprintf("%s/file=%t/env=%t/flag=%t", setting.name, withFile, withEnv, withFlag)
+			t.Run(name, func(t *testing.T) {
+				yamlContent := ""
+				want := setting.layered[0]
+				if withFile {
+					yamlContent = setting.yaml
+					want = setting.layered[1]
+				}
+				env := map[string]string{}
+				if withEnv {
+					env[envName(setting.name)] = setting.env
+					want = setting.layered[2]
+				}
+				var args []string
+				if withFlag {
+					args = append(args, setting.flag)
+					want = setting.layered[3]
+				}
+
+				config, err := testConfig(t, yamlContent, env, args...)
+				if err != nil {
+					t.Fatalf("parseCommandLine() error = %v", err)
+				}
+				if got := setting.get(config); got != want {
+					t.Errorf("Expected %q, got %q", want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestConfigUnsetLayersKeepValues(t *testing.T) {
+	config, err := testConfig(t, `
+webdav:
+  url: http://file.example.com
+  user: file-user
+  calendar: /file/
+processor:
+  process_replies: true
+`, map[string]string{"CALMAILPROC_USER": "env-user"}, "-calendar", "/flag/", "agenda", "-from", "2025-03-01")
+	if err != nil {
+		t.Fatalf("parseCommandLine() error = %v", err)
+	}
+
+	if config.WebDAV.URL != "http://file.example.com" || config.WebDAV.User != "env-user" || config.WebDAV.Calendar != "/flag/" {
+		t.Errorf("Unexpected webdav settings: %+v", config.WebDAV)
+	}
+	if !config.Processor.ProcessReplies {
+		t.Error("Expected process_replies from the file to be kept")
+	}
+	if strings.Join(config.Args, " ") != "agenda -from 2025-03-01" {
+		t.Errorf("Expected the command arguments, got %v", config.Args)
+	}
+}
+
+func TestConfigProfilePrecedence(t *testing.T) {
+	content := `
+webdav:
+  url: http://file.example.com
+  calendar: /top/
+profiles:
+  team:
+    webdav:
+      calendar: /team/
+    processor:
+      process_replies: true
+`
+	for _, tc := range []struct {
+		name         string
+		env          map[string]string
+		args         []string
+		wantCalendar string
+		wantReplies  bool
+	}{
+		{"no profile", nil, nil, "/top/", false},
+		{"profile flag", nil, []string{"-profile", "team"}, "/team/", true},
+		{"profile from environment", map[string]string{"CALMAILPROC_PROFILE": "team"}, nil, "/team/", true},
+		{"flag over profile", nil, []string{"-profile", "team", "-calendar", "/flag/", "-process-replies=false"}, "/flag/", false},
+		{"environment over profile", map[string]string{"CALMAILPROC_CALENDAR": "/env/"}, []string{"-profile=team"}, "/env/", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := testConfig(t, content, tc.env, tc.args...)
+			if err != nil {
+				t.Fatalf("parseCommandLine() error = %v", err)
+			}
+			if config.WebDAV.Calendar != tc.wantCalendar {
+				t.Errorf("Expected calendar %s, got %s", tc.wantCalendar, config.WebDAV.Calendar)
+			}
+			if config.WebDAV.URL != "http://file.example.com" {
+				t.Errorf("Expected the profile to inherit the URL, got %s", config.WebDAV.URL)
+			}
+			if config.Processor.ProcessReplies != tc.wantReplies {
+				t.Errorf("Expected process_replies %t, got %t", tc.wantReplies, config.Processor.ProcessReplies)
+			}
+		})
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	if _, err := testConfig(t, "", map[string]string{"CALMAILPROC_VERBOSE": "sometimes"}); err == nil {
+		t.Error("Expected an error for an invalid boolean environment variable")
+	}
+	if _, err := testConfig(t, "", nil, "-profile", "missing"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+	if _, err := testConfig(t, "", nil, "-no-such-flag"); err == nil {
+		t.Error("Expected an error for an unknown flag")
+	}
+}
+
+func TestReadConfigFileSecrets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if _, err := readConfigFile(write("open.yaml", "webdav:\n  pass: secret\n", 0644)); !errors.Is(err, errInsecureConfig) {
+		t.Errorf("Expected errInsecureConfig for a plaintext pass readable by all, got %v", err)
+	}
+	if _, err := readConfigFile(write("private.yaml", "webdav:\n  pass: secret\n", 0600)); err != nil {
+		t.Errorf("Expected a private config file to be accepted, got %v", err)
+	}
+
+	t.Setenv("TEST_CALDAV_PASS", "from-env")
+	config, err := readConfigFile(write("env.yaml", "webdav:\n  pass: ${TEST_CALDAV_PASS}\n  url: https://${TEST_CALDAV_PASS}.example.com\n", 0644))
+	if err != nil {
+		t.Fatalf("Expected environment references to be accepted, got %v", err)
+	}
+	if config.WebDAV.Pass != "from-env" || config.WebDAV.URL != "https://from-env.example.com" {
+		t.Errorf("Expected references to be expanded, got %+v", config.WebDAV)
+	}
+
+	if _, err := readConfigFile(write("missing.yaml", "webdav:\n  user: ${TEST_UNSET_VARIABLE}\n", 0600)); err == nil {
+		t.Error("Expected an error for an unset environment variable")
+	}
+}
+
+func TestWriteConfigMasksSecrets(t *testing.T) {
+	config, err := testConfig(t, `
+webdav:
+  url: https://dav.example.com
+  pass: topsecret
+outbound:
+  smtp_pass: smtpsecret
+profiles:
+  team:
+    webdav:
+      pass: teamsecret
+      oauth2:
+        client_secret: clientsecret
+        refresh_token: refreshsecret
+    outbound:
+      smtp_pass: teamsmtpsecret
+  personal:
+    webdav:
+      calendar: /personal/
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := writeConfig(&out, config); err != nil {
+		t.Fatalf("writeConfig() error = %v", err)
+	}
+	for _, secret := range []string{"topsecret", "smtpsecret", "teamsecret", "clientsecret", "refreshsecret", "teamsmtpsecret"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Secret %q in the output:\n%s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "/personal/") {
+		t.Errorf("Expected the profiles in the output:\n%s", out.String())
+	}
+
+	// The configuration itself is unchanged
+	if config.Profiles["team"].WebDAV.Pass != "teamsecret" || config.WebDAV.Pass != "topsecret" {
+		t.Error("writeConfig() changed the configuration")
+	}
+}
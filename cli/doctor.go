@@ -0,0 +1,306 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/logging"
+	"github.com/mkbrechtel/calmailproc/outbound"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/processor"
+	"github.com/mkbrechtel/calmailproc/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// doctorReport prints the results of the doctor checks
+type doctorReport struct {
+	w        io.Writer
+	failures int
+	warnings int
+}
+
+func (r *doctorReport) pass(format string, args ...interface{}) {
+	fmt.Fprintf(r.w, "[ OK ] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(hint, format string, args ...interface{}) {
+	r.warnings++
+	fmt.Fprintf(r.w, "[WARN] %s\n", fmt.Sprintf(format, args...))
+	if hint != "" {
+		fmt.Fprintf(r.w, "       hint: %s\n", hint)
+	}
+}
+
+func (r *doctorReport) fail(hint, format string, args ...interface{}) {
+	r.failures++
+	fmt.Fprintf(r.w, "[FAIL] %s\n", fmt.Sprintf(format, args...))
+	if hint != "" {
+		fmt.Fprintf(r.w, "       hint: %s\n", hint)
+	}
+}
+
+// runDoctor checks the configuration, the maildirs and the CalDAV server
+// and prints a report
+func runDoctor(config *Config, flags *flag.FlagSet, args []string) error {
+	skipWrite := flags.Bool("skip-write", false, "Don't store, read and delete a test event on the CalDAV server")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError(flags)
+	}
+
+	report := &doctorReport{w: os.Stdout}
+	checkConfigFile(report)
+
+	// Without -profile every profile is checked, the top level may only
+	// hold the settings they share
+	configs := map[string]*Config{"": config}
+	names := []string{""}
+	if config.Profile == "" && len(config.Profiles) > 0 {
+		configs = make(map[string]*Config)
+		names = config.profileNames()
+		for _, name := range names {
+			profileConfig, err := config.UseProfile(name)
+			if err != nil {
+				return err
+			}
+			profileConfig.applyFlags()
+			configs[name] = profileConfig
+		}
+	}
+
+	for _, name := range names {
+		if name != "" {
+			fmt.Fprintf(report.w, "\nProfile %s\n", name)
+		}
+		checkSettings(report, configs[name])
+		checkMaildir(report, configs[name])
+		checkCalDAV(report, configs[name], !*skipWrite)
+	}
+
+	fmt.Fprintf(report.w, "\n%d problems, %d warnings\n", report.failures, report.warnings)
+	if report.failures > 0 {
+		return fmt.Errorf("doctor found %d problems", report.failures)
+	}
+	return nil
+}
+
+// checkConfigFile reports whether the config file exists and contains
+// only known keys
+func checkConfigFile(report *doctorReport) {
+	configPath, err := configFilePath()
+	if err != nil {
+		report.fail("", "Cannot determine the config file location: %v", err)
+		return
+	}
+	checkConfigFileAt(report, configPath)
+}
+
+// checkConfigFileAt checks the config file at configPath
+func checkConfigFileAt(report *doctorReport, configPath string) {
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		report.warn("create it to avoid passing all settings as flags", "No config file at %s", configPath)
+		return
+	}
+	if err != nil {
+		report.fail("check the permissions of the file", "Cannot read config file %s: %v", configPath, err)
+		return
+	}
+	if _, err := readConfigFile(configPath); err != nil {
+		report.fail("", "Config file %s: %v", configPath, err)
+		return
+	}
+	report.pass("Config file %s", configPath)
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var known Config
+	err = dec.Decode(&known)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		report.pass("No unknown config keys")
+	case errors.As(err, &typeErr):
+		for _, msg := range typeErr.Errors {
+			report.fail("check the spelling against the configuration in the README, unknown keys are ignored",
+				"Config file: %s", msg)
+		}
+	default:
+		report.fail("", "Config file: %v", err)
+	}
+}
+
+// checkSettings validates the processor and outbound settings
+func checkSettings(report *doctorReport, config *Config) {
+	// The processor is created on a memory storage, only the settings
+	// are of interest
+	if _, err := processor.NewProcessorFromConfig(storage.NewMemoryStorage(), config.Processor); err != nil {
+		report.fail("see the processor section in the README for valid values", "Processor settings: %v", err)
+	} else {
+		report.pass("Processor settings")
+	}
+
+	if _, err := logging.New(config.Log, io.Discard); err != nil {
+		report.fail("see the log section in the README for valid values", "Log settings: %v", err)
+	} else {
+		report.pass("Log settings")
+	}
+
+	if _, err := outbound.NewReplierFromConfig(config.Outbound); err != nil {
+		report.fail("", "Outbound settings: %v", err)
+		return
+	}
+	if config.Outbound.SMTPAddr == "" {
+		sendmail := config.Outbound.Sendmail
+		if sendmail == "" {
+			sendmail = outbound.DefaultSendmailPath
+		}
+		if _, err := os.Stat(sendmail); err != nil {
+			report.warn("install a sendmail or configure outbound.smtp_addr to answer invitations",
+				"Sendmail %s not found", sendmail)
+			return
+		}
+	}
+	report.pass("Outbound settings")
+}
+
+// checkMaildir reports whether the maildir exists and looks like one
+func checkMaildir(report *doctorReport, config *Config) {
+	path := config.Maildir.Path
+	if path == "" {
+		report.pass("No maildir configured, emails are read from stdin")
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		report.fail("check maildir.path or -maildir", "Maildir %s: %v", path, err)
+		return
+	}
+	if !info.IsDir() {
+		report.fail("maildir.path must be a directory", "Maildir %s is not a directory", path)
+		return
+	}
+
+	folders, err := countMaildirFolders(path)
+	if err != nil {
+		report.fail("check the permissions of the directory", "Maildir %s: %v", path, err)
+		return
+	}
+	if folders == 0 {
+		report.fail("a maildir contains cur and new directories, point maildir.path at it",
+			"%s contains no maildir folders", path)
+		return
+	}
+	report.pass("Maildir %s with %d folders", path, folders)
+}
+
+// countMaildirFolders counts the directories below path, including path,
+// that contain cur and new subdirectories
+func countMaildirFolders(path string) (int, error) {
+	folders := 0
+	err := filepath.WalkDir(path, func(dir string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if name := entry.Name(); dir != path && (name == "cur" || name == "new" || name == "tmp") {
+			return filepath.SkipDir
+		}
+		if isDir(filepath.Join(dir, "cur")) && isDir(filepath.Join(dir, "new")) {
+			folders++
+		}
+		return nil
+	})
+	return folders, err
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// checkCalDAV connects to the CalDAV server, checks the collections and
+// optionally stores, reads and deletes a test event
+func checkCalDAV(report *doctorReport, config *Config, write bool) {
+	store, err := openStorage(config)
+	if err != nil {
+		report.fail("set the webdav section of the config file or pass -url, -user and -calendar", "CalDAV: %v", err)
+		return
+	}
+	caldavStore, ok := store.(*storage.CalDAVStorage)
+	if !ok {
+		report.pass("Storage configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collections := []string{ical.ComponentEvent}
+	if config.WebDAV.TaskCalendar != "" {
+		collections = append(collections, ical.ComponentTodo)
+	}
+	for _, component := range collections {
+		if err := caldavStore.CheckCollection(ctx, component); err != nil {
+			report.fail("check the URL, credentials and webdav.calendar/task_calendar path", "CalDAV %s collection: %v", component, err)
+			return
+		}
+		report.pass("CalDAV collection for %s", component)
+	}
+
+	if !write {
+		return
+	}
+	if err := calDAVRoundTrip(store); err != nil {
+		report.fail("the account needs write access to the calendar", "CalDAV round-trip: %v", err)
+		return
+	}
+	report.pass("CalDAV store, read and delete of a test event")
+}
+
+// calDAVRoundTrip stores a test event with a temporary UID, reads it back
+// and deletes it
+func calDAVRoundTrip(store storage.Storage) error {
+	now := time.Now().UTC()
+	uid := fmt.Sprintf("calmailproc-doctor-%d", now.UnixNano())
+	stamp := now.Format("20060102T150405Z")
+	data := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//calmailproc//Calendar//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTAMP:" + stamp + "\r\n" +
+		"DTSTART:" + stamp + "\r\nDURATION:PT1M\r\nSUMMARY:calmailproc doctor test\r\n" +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	event, err := ical.ParseICalData([]byte(data))
+	if err != nil {
+		return err
+	}
+	if err := store.StoreEvent(event); err != nil {
+		return fmt.Errorf("storing: %w", err)
+	}
+
+	stored, err := store.GetEvent(uid)
+	if err != nil {
+		store.DeleteEvent(uid)
+		return fmt.Errorf("reading back: %w", err)
+	}
+	if parsed, err := ical.ParseICalData(stored.RawData); err != nil || parsed.UID != uid {
+		store.DeleteEvent(uid)
+		return fmt.Errorf("the stored event was returned changed")
+	}
+
+	if err := store.DeleteEvent(uid); err != nil {
+		return fmt.Errorf("deleting test event %s: %w", uid, err)
+	}
+	return nil
+}
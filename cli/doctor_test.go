@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func TestDoctorConfigFile(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		content  string
+		failures int
+		want     string
+	}{
+		{"known keys", "webdav:\n  url: https://dav.example.com\n", 0, "No unknown config keys"},
+		{"unknown keys", "webdav:\n  url: https://dav.example.com\n  calender: /cal/\nmaildirs: []\n", 2, "calender"},
+		{"invalid yaml", "webdav: [\n", 1, "[FAIL] Config file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			var out strings.Builder
+			report := &doctorReport{w: &out}
+			checkConfigFileAt(report, path)
+			if report.failures != tc.failures || !strings.Contains(out.String(), tc.want) {
+				t.Errorf("Expected %d failures and %q, got %d:\n%s", tc.failures, tc.want, report.failures, out.String())
+			}
+		})
+	}
+
+	var out strings.Builder
+	report := &doctorReport{w: &out}
+	checkConfigFileAt(report, filepath.Join(t.TempDir(), "missing.yaml"))
+	if report.failures != 0 || report.warnings != 1 {
+		t.Errorf("Expected a warning for a missing config file, got:\n%s", out.String())
+	}
+}
+
+func TestDoctorMaildir(t *testing.T) {
+	dir := t.TempDir()
+	maildir := filepath.Join(dir, "Mail")
+	for _, sub := range []string{"cur", "new", "tmp", "Archive/cur", "Archive/new"} {
+		if err := os.MkdirAll(filepath.Join(maildir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		failed bool
+		want   string
+	}{
+		{"maildir", maildir, false, "with 2 folders"},
+		{"none configured", "", false, "read from stdin"},
+		{"missing", filepath.Join(dir, "missing"), true, "no such file"},
+		{"file", file, true, "is not a directory"},
+		{"no maildir", empty, true, "contains no maildir folders"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.Maildir.Path = tc.path
+
+			var out strings.Builder
+			report := &doctorReport{w: &out}
+			checkMaildir(report, config)
+			if (report.failures > 0) != tc.failed || !strings.Contains(out.String(), tc.want) {
+				t.Errorf("Expected failed=%t and %q, got:\n%s", tc.failed, tc.want, out.String())
+			}
+		})
+	}
+}
+
+// collectionResponse answers a PROPFIND for /calendar/ with a calendar
+// collection supporting the components
+func collectionResponse(components ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		var comps string
+		for _, component := range components {
+			comps += fmt.Sprintf(`<C:comp name="%s"/>`, component)
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<multistatus xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <response>
+    <href>/calendar/</href>
+    <propstat>
+      <prop>
+        <resourcetype><collection/><C:calendar/></resourcetype>
+        <C:supported-calendar-component-set>%s</C:supported-calendar-component-set>
+      </prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`, comps)
+	}
+}
+
+func TestDoctorCalDAVCollection(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		failed  bool
+		want    string
+	}{
+		{"calendar", collectionResponse("VEVENT", "VTODO"), false, "[ OK ] CalDAV collection for VEVENT"},
+		{"task list only", collectionResponse("VTODO"), true, "only supports VTODO"},
+		{"unauthorized", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}, true, "401"},
+		{"missing", http.NotFound, true, "404"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+
+			config := defaultConfig()
+			config.WebDAV = storage.WebdavConfig{URL: server.URL, User: "me", Pass: "secret", Calendar: "/calendar/"}
+
+			var out strings.Builder
+			report := &doctorReport{w: &out}
+			checkCalDAV(report, config, false)
+			if (report.failures > 0) != tc.failed || !strings.Contains(out.String(), tc.want) {
+				t.Errorf("Expected failed=%t and %q, got:\n%s", tc.failed, tc.want, out.String())
+			}
+		})
+	}
+
+	// Without CalDAV settings the check fails with a hint
+	var out strings.Builder
+	report := &doctorReport{w: &out}
+	checkCalDAV(report, defaultConfig(), false)
+	if report.failures != 1 || !strings.Contains(out.String(), "hint:") {
+		t.Errorf("Expected a failure with a hint, got:\n%s", out.String())
+	}
+}
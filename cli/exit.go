@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/mkbrechtel/calmailproc/processor"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// Exit codes from sysexits.h, so that mail delivery agents like Postfix
+// pipe, procmail and maildrop bounce, retry or keep an email as intended
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	// ExitUsage is EX_USAGE: wrong flags, arguments or command
+	ExitUsage = 64
+	// ExitDataErr is EX_DATAERR: the calendar data of the email can't be
+	// processed, delivering it again won't help
+	ExitDataErr = 65
+	// ExitTempFail is EX_TEMPFAIL: the storage or outbound mail failed,
+	// the MTA retries the delivery later
+	ExitTempFail = 75
+	// ExitConfig is EX_CONFIG: the configuration is incomplete or invalid
+	ExitConfig = 78
+)
+
+// exitError gives an error the exit code it ends calmailproc with
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// configError marks err as a configuration error
+func configError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{err: err, code: ExitConfig}
+}
+
+// joinedError summarizes errors that were logged already, like those of
+// several emails or profiles. Only the summary is shown, errors.Is and
+// ExitCode look at all of them.
+type joinedError struct {
+	msg  string
+	errs []error
+}
+
+func (e *joinedError) Error() string   { return e.msg }
+func (e *joinedError) Unwrap() []error { return e.errs }
+
+// ExitCode returns the exit code for an error returned by Run. Errors of
+// several emails, like those of a maildir run, exit with the most urgent
+// code: configuration errors first, then temporary failures so that the
+// run is retried, then invalid data.
+func ExitCode(err error) int {
+	var exitErr *exitError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errInsecureConfig):
+		return ExitConfig
+	case errors.As(err, &exitErr) && exitErr.code == ExitConfig:
+		return ExitConfig
+	case errors.Is(err, processor.ErrTemporary), errors.Is(err, storage.ErrUnavailable):
+		return ExitTempFail
+	case errors.Is(err, processor.ErrInvalidData):
+		return ExitDataErr
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	default:
+		return ExitFailure
+	}
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/processor"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func TestExitCode(t *testing.T) {
+	temporary := fmt.Errorf("processing stdin: %w", processor.ErrTemporary)
+	invalid := fmt.Errorf("processing stdin: %w", processor.ErrInvalidData)
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"other", errors.New("something failed"), ExitFailure},
+		{"invalid data", invalid, ExitDataErr},
+		{"temporary", temporary, ExitTempFail},
+		{"storage", fmt.Errorf("listing: %w", storage.ErrUnavailable), ExitTempFail},
+		{"config", configError(errors.New("all CalDAV flags are required")), ExitConfig},
+		{"insecure config", fmt.Errorf("%w: world-readable", errInsecureConfig), ExitConfig},
+		{"usage", &exitError{err: errors.New("usage: show UID"), code: ExitUsage}, ExitUsage},
+		{"emails", &joinedError{msg: "failed to process 2 of 3 files", errs: []error{invalid, temporary}}, ExitTempFail},
+		{"profiles", &joinedError{msg: "failed profiles: a, b", errs: []error{invalid, configError(errors.New("no maildir"))}}, ExitConfig},
+	}
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.want {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	config := defaultConfig()
+
+	config.Args = []string{"unknown"}
+	if got := ExitCode(Run(config)); got != ExitUsage {
+		t.Errorf("Unknown command: expected exit code %d, got %d", ExitUsage, got)
+	}
+
+	config.Args = []string{"list", "-format"}
+	if got := ExitCode(Run(config)); got != ExitUsage {
+		t.Errorf("Missing flag value: expected exit code %d, got %d", ExitUsage, got)
+	}
+
+	config.Args = []string{"list"}
+	if got := ExitCode(Run(config)); got != ExitConfig {
+		t.Errorf("Missing CalDAV settings: expected exit code %d, got %d", ExitConfig, got)
+	}
+
+	for _, args := range [][]string{{"history", "some-uid"}, {"undo", "-since", "2h"}} {
+		config.Args = args
+		if got := ExitCode(Run(config)); got != ExitConfig {
+			t.Errorf("%s without journal: expected exit code %d, got %d", args[0], ExitConfig, got)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// listEntry is one stored object in the output of list
+type listEntry struct {
+	UID       string    `json:"uid"`
+	Component string    `json:"component"`
+	Summary   string    `json:"summary,omitempty"`
+	Start     time.Time `json:"start,omitempty"`
+	Recurring bool      `json:"recurring"`
+}
+
+// runList lists all stored calendar objects
+func runList(config *Config, flags *flag.FlagSet, args []string) error {
+	format := flags.String("format", "text", "Output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError(flags)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown list format: %s", *format)
+	}
+
+	store, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+	events, err := store.ListEvents()
+	if err != nil {
+		return fmt.Errorf("error listing events: %w", err)
+	}
+
+	entries := make([]listEntry, 0, len(events))
+	for _, event := range events {
+		entry := listEntry{
+			UID:       event.UID,
+			Component: event.Component,
+			Summary:   event.Summary,
+			Start:     event.Start,
+		}
+		if cal, err := ical.DecodeCalendar(event.RawData); err == nil {
+			for _, component := range cal.Children {
+				if ical.IsObjectComponent(component.Name) && component.Props.Get("RRULE") != nil {
+					entry.Recurring = true
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Start.Equal(entries[j].Start) {
+			return entries[i].Start.Before(entries[j].Start)
+		}
+		return entries[i].UID < entries[j].UID
+	})
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		start := "-"
+		if !entry.Start.IsZero() {
+			start = entry.Start.Local().Format("2006-01-02 15:04")
+		}
+		summary := entry.Summary
+		if entry.Recurring {
+			summary += " (recurring)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", start, entry.Component, entry.UID, summary)
+	}
+	return w.Flush()
+}
+
+// runDelete deletes stored calendar objects by UID
+func runDelete(config *Config, flags *flag.FlagSet, args []string) error {
+	dryRun := flags.Bool("dry-run", false, "Only show what would be deleted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags)
+	}
+
+	store, err := openJournaledStorage(config)
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range flags.Args() {
+		if _, err := store.GetEvent(uid); err != nil {
+			return fmt.Errorf("error getting event %s: %w", uid, err)
+		}
+		if *dryRun {
+			fmt.Printf("Would delete UID %s\n", uid)
+			continue
+		}
+		if err := store.DeleteEvent(uid); err != nil {
+			return fmt.Errorf("error deleting event %s: %w", uid, err)
+		}
+		fmt.Printf("Deleted UID %s\n", uid)
+	}
+	return nil
+}
+
+// runExport writes stored calendar objects to stdout, or one file per
+// object to a directory
+func runExport(config *Config, flags *flag.FlagSet, args []string) error {
+	dir := flags.String("dir", "", "Write one <UID>.ics file per object to this directory instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+
+	var events []*ical.Event
+	if flags.NArg() == 0 {
+		if events, err = store.ListEvents(); err != nil {
+			return fmt.Errorf("error listing events: %w", err)
+		}
+	}
+	for _, uid := range flags.Args() {
+		event, err := store.GetEvent(uid)
+		if err != nil {
+			return fmt.Errorf("error getting event %s: %w", uid, err)
+		}
+		events = append(events, event)
+	}
+
+	if *dir == "" {
+		for _, event := range events {
+			if _, err := os.Stdout.Write(event.RawData); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return fmt.Errorf("creating export directory: %w", err)
+	}
+	for _, event := range events {
+		// UIDs may contain characters that aren't valid in file names
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(event.UID) + ".ics"
+		if err := os.WriteFile(filepath.Join(*dir, name), event.RawData, 0600); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d objects to %s\n", len(events), *dir)
+	return nil
+}
+
+// runImport stores the calendar objects of .ics files. Objects that are
+// already stored are skipped unless -replace is given.
+func runImport(config *Config, flags *flag.FlagSet, args []string) error {
+	dryRun := flags.Bool("dry-run", false, "Only show what would be imported")
+	replace := flags.Bool("replace", false, "Replace objects that are already stored")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags)
+	}
+
+	store, err := openJournaledStorage(config)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		cals, err := ical.DecodeCalendars(data)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		for _, cal := range cals {
+			for _, object := range ical.SplitObjects(cal) {
+				ical.EnsureTimezones(object)
+				raw, err := ical.EncodeCalendar(object)
+				if err != nil {
+					return fmt.Errorf("encoding object from %s: %w", path, err)
+				}
+				event, err := ical.ParseICalData(raw)
+				if err != nil {
+					return fmt.Errorf("parsing object from %s: %w", path, err)
+				}
+				if err := ical.ValidateUID(event.UID); err != nil {
+					fmt.Printf("%s > Skipped object with invalid UID: %v\n", path, err)
+					continue
+				}
+
+				existing, err := store.GetEvent(event.UID)
+				exists := err == nil && existing != nil
+				switch {
+				case exists && !*replace:
+					fmt.Printf("%s > Skipped UID %s, it is already stored\n", path, event.UID)
+					continue
+				case *dryRun:
+					fmt.Printf("%s > Would import UID %s\n", path, event.UID)
+					continue
+				}
+
+				if err := store.StoreEvent(event); err != nil {
+					return fmt.Errorf("storing %s: %w", event.UID, err)
+				}
+				fmt.Printf("%s > Imported UID %s\n", path, event.UID)
+			}
+		}
+	}
+	return nil
+}
+
+// runVerify checks every stored object and reports the problems found
+func runVerify(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError(flags)
+	}
+
+	store, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+	events, err := store.ListEvents()
+	if err != nil {
+		return fmt.Errorf("error listing events: %w", err)
+	}
+
+	broken := 0
+	for _, event := range events {
+		problems := verifyObject(event)
+		if len(problems) == 0 {
+			continue
+		}
+		broken++
+		fmt.Printf("%s:\n", event.UID)
+		for _, problem := range problems {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+
+	fmt.Printf("Verified %d objects, %d with problems\n", len(events), broken)
+	if broken > 0 {
+		return fmt.Errorf("%d objects with problems", broken)
+	}
+	return nil
+}
+
+// verifyObject returns the problems of a stored calendar object
+func verifyObject(event *ical.Event) []string {
+	if err := ical.ValidateEvent(event.RawData); err != nil {
+		return []string{err.Error()}
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	if err := ical.ValidateUID(event.UID); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	var master *ical.Component
+	masters := 0
+	for _, component := range cal.Children {
+		if !ical.IsObjectComponent(component.Name) {
+			continue
+		}
+		uid := ""
+		if prop := component.Props.Get("UID"); prop != nil {
+			uid = prop.Value
+		}
+		if uid != event.UID {
+			problems = append(problems, fmt.Sprintf("%s with UID %q stored under %q", component.Name, uid, event.UID))
+		}
+		if component.Props.Get("RECURRENCE-ID") == nil {
+			master = component
+			masters++
+		}
+	}
+	if masters > 1 {
+		problems = append(problems, fmt.Sprintf("%d components without RECURRENCE-ID", masters))
+	}
+
+	for _, component := range cal.Children {
+		recurrenceID := component.Props.Get("RECURRENCE-ID")
+		if !ical.IsObjectComponent(component.Name) || recurrenceID == nil {
+			continue
+		}
+		if master == nil || master.Props.Get("RRULE") == nil && master.Props.Get("RDATE") == nil {
+			continue
+		}
+		if ok, err := ical.IsOccurrence(master, cal, recurrenceID, cal); err == nil && !ok {
+			problems = append(problems, fmt.Sprintf("exception for %s is not an occurrence of the series", recurrenceID.Value))
+		}
+	}
+
+	for _, tzid := range missingTimezones(cal) {
+		problems = append(problems, fmt.Sprintf("no VTIMEZONE for TZID %s", tzid))
+	}
+	return problems
+}
+
+// missingTimezones returns the TZIDs used without a VTIMEZONE definition
+func missingTimezones(cal *ical.Calendar) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	var visit func(component *ical.Component)
+	visit = func(component *ical.Component) {
+		for _, props := range component.Props {
+			for _, prop := range props {
+				tzid := prop.Params.Get("TZID")
+				if tzid == "" || seen[tzid] {
+					continue
+				}
+				seen[tzid] = true
+				if ical.FindTimezone(cal, tzid) == nil {
+					missing = append(missing, tzid)
+				}
+			}
+		}
+		for _, child := range component.Children {
+			visit(child)
+		}
+	}
+	for _, component := range cal.Children {
+		if ical.IsObjectComponent(component.Name) {
+			visit(component)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
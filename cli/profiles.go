@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mkbrechtel/calmailproc/outbound"
+	"github.com/mkbrechtel/calmailproc/processor"
+	"github.com/mkbrechtel/calmailproc/processor/maildir"
+	"github.com/mkbrechtel/calmailproc/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a named account with its own storage, processor options and
+// sources. Settings a profile doesn't contain are taken from the top level
+// of the config file.
+type Profile struct {
+	WebDAV    storage.WebdavConfig      `yaml:"webdav"`
+	Processor processor.ProcessorConfig `yaml:"processor"`
+	Maildir   maildir.MaildirConfig     `yaml:"maildir"`
+	Stdin     StdinConfig               `yaml:"stdin"`
+	Outbound  outbound.Config           `yaml:"outbound"`
+}
+
+// decodeProfiles decodes the profiles of a config file on top of the top
+// level settings, so that profiles inherit everything they don't set
+func decodeProfiles(root *yaml.Node, config *Config) error {
+	doc := root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "profiles" {
+			continue
+		}
+		profiles := doc.Content[i+1]
+		if profiles.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: profiles must be a mapping of names to settings", profiles.Line)
+		}
+
+		config.Profiles = make(map[string]*Profile)
+		for j := 0; j+1 < len(profiles.Content); j += 2 {
+			name := profiles.Content[j].Value
+			profile := &Profile{
+				WebDAV:    config.WebDAV,
+				Processor: config.Processor,
+				Maildir:   config.Maildir,
+				Stdin:     config.Stdin,
+				Outbound:  config.Outbound,
+			}
+			if err := profiles.Content[j+1].Decode(profile); err != nil {
+				return fmt.Errorf("profile %s: %w", name, err)
+			}
+			config.Profiles[name] = profile
+		}
+	}
+	return nil
+}
+
+// UseProfile returns a copy of the configuration with the settings of the
+// named profile
+func (config *Config) UseProfile(name string) (*Config, error) {
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+
+	profileConfig := *config
+	profileConfig.Profile = name
+	profileConfig.WebDAV = profile.WebDAV
+	profileConfig.Processor = profile.Processor
+	profileConfig.Maildir = profile.Maildir
+	profileConfig.Stdin = profile.Stdin
+	profileConfig.Outbound = profile.Outbound
+	return &profileConfig, nil
+}
+
+// profileNames returns the names of the profiles in sorted order
+func (config *Config) profileNames() []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runAll processes the maildir of every profile. A failing profile doesn't
+// stop the others.
+func runAll(config *Config, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError(flags)
+	}
+	if len(config.Profiles) == 0 {
+		return configError(fmt.Errorf("no profiles configured"))
+	}
+
+	logger, err := openLogger(config)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	var errs []error
+	for _, name := range config.profileNames() {
+		profileConfig, err := config.UseProfile(name)
+		if err != nil {
+			return err
+		}
+		profileConfig.applyFlags()
+		profileConfig.Logger = logger.With("profile", name)
+
+		if profileConfig.Maildir.Path == "" {
+			profileConfig.Logger.Info("No maildir configured, skipping profile")
+			continue
+		}
+
+		profileConfig.Logger.Info("Processing profile", "maildir", profileConfig.Maildir.Path)
+		if err := runProfile(profileConfig); err != nil {
+			profileConfig.Logger.Error("Processing profile failed", "error", err)
+			failed = append(failed, name)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &joinedError{msg: fmt.Sprintf("failed profiles: %s", strings.Join(failed, ", ")), errs: errs}
+	}
+	return nil
+}
+
+// runProfile processes the maildir of one profile
+func runProfile(config *Config) error {
+	proc, err := newProcessor(config)
+	if err != nil {
+		return err
+	}
+	summary, err := maildir.Run(config.Maildir, proc, config.Logger)
+	if err != nil {
+		return fmt.Errorf("error processing maildir: %w", err)
+	}
+	return summary.Err()
+}
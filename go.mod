@@ -5,13 +5,11 @@ go 1.23.0
 toolchain go1.24.2
 
 require (
+	github.com/adrg/xdg v0.5.3
 	github.com/emersion/go-ical v0.0.0-20240127095438-fc1c9d8fb2b6
 	github.com/emersion/go-webdav v0.6.0
+	github.com/teambition/rrule-go v1.8.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/adrg/xdg v0.5.3 // indirect
-	github.com/teambition/rrule-go v1.8.2 // indirect
-	golang.org/x/sys v0.26.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-)
+require golang.org/x/sys v0.26.0 // indirect
@@ -0,0 +1,172 @@
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// shownProperties are printed for every component in this order
+var shownProperties = []struct {
+	name  string
+	label string
+}{
+	{"SUMMARY", "Summary"},
+	{"DTSTART", "Start"},
+	{"DTEND", "End"},
+	{"DURATION", "Duration"},
+	{"DUE", "Due"},
+	{"RRULE", "Rule"},
+	{"RDATE", "Added dates"},
+	{"EXDATE", "Excluded dates"},
+	{"LOCATION", "Location"},
+	{"STATUS", "Status"},
+	{"SEQUENCE", "Sequence"},
+	{"DTSTAMP", "DTSTAMP"},
+	{"ORGANIZER", "Organizer"},
+}
+
+// WriteObject pretty-prints a stored calendar object: the master and each
+// exception with their scheduling properties and attendee statuses
+func WriteObject(w io.Writer, event *ical.Event) error {
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		return fmt.Errorf("parsing event data: %w", err)
+	}
+
+	var master *goical.Component
+	var exceptions []*goical.Component
+	var timezones []string
+	for _, component := range cal.Children {
+		switch {
+		case component.Name == "VTIMEZONE":
+			if tzid := component.Props.Get("TZID"); tzid != nil {
+				timezones = append(timezones, tzid.Value)
+			}
+		case !ical.IsObjectComponent(component.Name):
+		case component.Props.Get("RECURRENCE-ID") != nil:
+			exceptions = append(exceptions, component)
+		case master == nil:
+			master = component
+		}
+	}
+
+	// Exceptions are listed in the order of the occurrences they replace
+	sort.SliceStable(exceptions, func(i, j int) bool {
+		ti, erri := ical.ParseDateTime(exceptions[i].Props.Get("RECURRENCE-ID"), cal)
+		tj, errj := ical.ParseDateTime(exceptions[j].Props.Get("RECURRENCE-ID"), cal)
+		return erri == nil && errj == nil && ti.Before(tj)
+	})
+
+	fmt.Fprintf(w, "UID: %s\n", event.UID)
+	if len(timezones) > 0 {
+		fmt.Fprintf(w, "Time zones: %s\n", strings.Join(timezones, ", "))
+	}
+
+	if master != nil {
+		fmt.Fprintf(w, "\n%s (master)\n", master.Name)
+		writeComponent(w, master)
+	}
+	for _, exception := range exceptions {
+		fmt.Fprintf(w, "\n%s (exception for %s)\n", exception.Name, propertyValue(exception.Props.Get("RECURRENCE-ID")))
+		writeComponent(w, exception)
+	}
+	return nil
+}
+
+// writeComponent prints the properties and attendees of one component
+func writeComponent(w io.Writer, component *goical.Component) {
+	for _, shown := range shownProperties {
+		props := component.Props.Values(shown.name)
+		if len(props) == 0 {
+			continue
+		}
+		values := make([]string, len(props))
+		for i := range props {
+			values[i] = propertyValue(&props[i])
+		}
+		fmt.Fprintf(w, "  %-15s %s\n", shown.label+":", strings.Join(values, ", "))
+	}
+
+	// Flags set by calmailproc itself, like orphaned exceptions
+	var flags []string
+	for name, props := range component.Props {
+		if strings.HasPrefix(name, "X-CALMAILPROC-") {
+			flags = append(flags, name+"="+props[0].Value)
+		}
+	}
+	sort.Strings(flags)
+	if len(flags) > 0 {
+		fmt.Fprintf(w, "  %-15s %s\n", "Flags:", strings.Join(flags, ", "))
+	}
+
+	attendees := component.Props.Values("ATTENDEE")
+	if len(attendees) > 0 {
+		fmt.Fprintf(w, "  Attendees:\n")
+	}
+	for _, attendee := range attendees {
+		partstat := attendee.Params.Get("PARTSTAT")
+		if partstat == "" {
+			partstat = "NEEDS-ACTION"
+		}
+		line := fmt.Sprintf("    %-40s %s", ical.CalendarAddress(attendee.Value), partstat)
+		if role := attendee.Params.Get("ROLE"); role != "" {
+			line += " (" + role + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// propertyValue formats a property value with its time zone, if any
+func propertyValue(prop *goical.Prop) string {
+	if prop == nil {
+		return ""
+	}
+	value := prop.Value
+	if prop.Name == "ORGANIZER" {
+		value = ical.CalendarAddress(value)
+	}
+	if tzid := prop.Params.Get("TZID"); tzid != "" {
+		value += " (" + tzid + ")"
+	}
+	if rng := prop.Params.Get("RANGE"); rng != "" {
+		value += " RANGE=" + rng
+	}
+	return value
+}
+
+// WriteHistory prints the journal entries of a calendar object, oldest
+// first, with the email that caused each change
+func WriteHistory(w io.Writer, entries []journal.Entry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No recorded changes")
+		return
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s  %-6s %s -> %s\n", entry.Time.UTC().Format(time.RFC3339), entry.Action,
+			shortHash(entry.BeforeHash), shortHash(entry.AfterHash))
+		if entry.MessageID != "" {
+			fmt.Fprintf(w, "  %-15s %s\n", "Message-ID:", entry.MessageID)
+		}
+		if entry.Path != "" {
+			fmt.Fprintf(w, "  %-15s %s\n", "File:", entry.Path)
+		}
+	}
+}
+
+// shortHash abbreviates a content hash, "-" stands for no content
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,126 @@
+package inspect
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+func TestWriteObject(t *testing.T) {
+	event, err := ical.ParseICalData([]byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:show-test
+SUMMARY:Weekly sync (moved)
+RECURRENCE-ID:20250317T090000Z
+DTSTART:20250318T090000Z
+DTEND:20250318T100000Z
+SEQUENCE:2
+DTSTAMP:20250305T000000Z
+X-CALMAILPROC-ORPHANED:TRUE
+END:VEVENT
+BEGIN:VEVENT
+UID:show-test
+SUMMARY:Weekly sync
+RECURRENCE-ID:20250310T090000Z
+DTSTART:20250310T090000Z
+DTEND:20250310T100000Z
+STATUS:CANCELLED
+SEQUENCE:1
+DTSTAMP:20250302T000000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:show-test
+SUMMARY:Weekly sync
+DTSTART;TZID=Europe/Berlin:20250303T100000
+DTEND;TZID=Europe/Berlin:20250303T110000
+RRULE:FREQ=WEEKLY;COUNT=4
+SEQUENCE:0
+DTSTAMP:20250301T000000Z
+ORGANIZER:mailto:Organizer@Example.com
+ATTENDEE;PARTSTAT=ACCEPTED;ROLE=REQ-PARTICIPANT:mailto:me@example.com
+ATTENDEE:mailto:other@example.com
+END:VEVENT
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteObject(&out, event); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+	text := out.String()
+
+	// The master comes first, exceptions follow in occurrence order
+	master := strings.Index(text, "VEVENT (master)")
+	first := strings.Index(text, "VEVENT (exception for 20250310T090000Z)")
+	second := strings.Index(text, "VEVENT (exception for 20250317T090000Z)")
+	if master < 0 || first < master || second < first {
+		t.Fatalf("unexpected component order:\n%s", text)
+	}
+
+	for _, want := range []string{
+		"UID: show-test",
+		"Start:          20250303T100000 (Europe/Berlin)",
+		"Rule:           FREQ=WEEKLY;COUNT=4",
+		"Organizer:      organizer@example.com",
+		"me@example.com",
+		"ACCEPTED (REQ-PARTICIPANT)",
+		"Status:         CANCELLED",
+		"Sequence:       2",
+		"DTSTAMP:        20250305T000000Z",
+		"Flags:          X-CALMAILPROC-ORPHANED=TRUE",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output is missing %q:\n%s", want, text)
+		}
+	}
+	if !strings.Contains(text, "other@example.com") || !strings.Contains(text, "NEEDS-ACTION") {
+		t.Errorf("attendee without PARTSTAT should be shown as NEEDS-ACTION:\n%s", text)
+	}
+}
+
+func TestWriteHistory(t *testing.T) {
+	var buf bytes.Buffer
+	WriteHistory(&buf, nil)
+	if !strings.Contains(buf.String(), "No recorded changes") {
+		t.Errorf("Expected a note for an empty history, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	WriteHistory(&buf, []journal.Entry{
+		{
+			Time:      time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC),
+			UID:       "show-test",
+			Action:    journal.ActionCreate,
+			AfterHash: "0123456789abcdef0123",
+			Source:    journal.Source{MessageID: "<invite@example.com>", Path: "/mail/cur/1"},
+		},
+		{
+			Time:       time.Date(2025, 3, 2, 8, 0, 0, 0, time.UTC),
+			UID:        "show-test",
+			Action:     journal.ActionDelete,
+			BeforeHash: "0123456789abcdef0123",
+		},
+	})
+	out := buf.String()
+	t.Logf("Output:\n%s", out)
+
+	for _, want := range []string{
+		"2025-03-01T08:00:00Z  create - -> 0123456789ab",
+		"Message-ID:     <invite@example.com>",
+		"File:           /mail/cur/1",
+		"2025-03-02T08:00:00Z  delete 0123456789ab -> -",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package journal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the journal
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Source describes what caused a change, usually an email
+type Source struct {
+	MessageID string    `json:"message_id,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Date      time.Time `json:"date,omitempty"`
+}
+
+// Entry is a single change of a stored calendar object
+type Entry struct {
+	Time   time.Time `json:"time"`
+	UID    string    `json:"uid"`
+	Action string    `json:"action"`
+	// BeforeHash and AfterHash are SHA-256 hashes of the stored data, empty
+	// if the object didn't exist before or doesn't exist after the change
+	BeforeHash string `json:"before_hash,omitempty"`
+	AfterHash  string `json:"after_hash,omitempty"`
+	// Before and After are snapshots of the stored data, used to undo the
+	// change
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Source
+}
+
+// Journal is an append-only JSONL file of changes
+type Journal struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open opens the journal at path, creating its directory if needed
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating journal directory: %w", err)
+	}
+	return &Journal{path: path}, nil
+}
+
+// Path returns the file the journal is stored in
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Record appends an entry to the journal
+func (j *Journal) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding journal entry: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	return f.Close()
+}
+
+// Entries reads all entries in the order they were recorded. A journal
+// that doesn't exist yet has no entries.
+func (j *Journal) Entries() ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("parsing journal line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	return entries, nil
+}
+
+// History returns the entries of one UID in the order they were recorded
+func (j *Journal) History(uid string) ([]Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Entry
+	for _, entry := range entries {
+		if entry.UID == uid {
+			history = append(history, entry)
+		}
+	}
+	return history, nil
+}
+
+// Since returns the entries recorded at or after t
+func (j *Journal) Since(t time.Time) ([]Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []Entry
+	for _, entry := range entries {
+		if !entry.Time.Before(t) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, nil
+}
+
+// ByMessage returns the entries caused by the email with the Message-ID
+func (j *Journal) ByMessage(messageID string) ([]Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	messageID = normalizeMessageID(messageID)
+	var selected []Entry
+	for _, entry := range entries {
+		if entry.MessageID != "" && normalizeMessageID(entry.MessageID) == messageID {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, nil
+}
+
+// normalizeMessageID allows Message-IDs to be given with or without the
+// angle brackets
+func normalizeMessageID(messageID string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(messageID), "<"), ">")
+}
+
+// Hash returns the content hash used in journal entries
+func Hash(data []byte) string {
+	if data == nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
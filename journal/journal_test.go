@@ -0,0 +1,97 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func TestJournalStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "journal.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	entries, err := j.Entries()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Expected an empty journal, got %v, %v", entries, err)
+	}
+
+	store := NewStorage(storage.NewMemoryStorage(), j)
+	store.Source = Source{MessageID: "<first@example.com>", Path: "/mail/cur/1"}
+	first := []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	if err := store.StoreEvent(&ical.Event{UID: "journal-event", RawData: first}); err != nil {
+		t.Fatalf("StoreEvent() error = %v", err)
+	}
+
+	store.Source = Source{MessageID: "<second@example.com>"}
+	second := []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n")
+	if err := store.StoreEvent(&ical.Event{UID: "journal-event", RawData: second}); err != nil {
+		t.Fatalf("StoreEvent() error = %v", err)
+	}
+	if err := store.StoreEvent(&ical.Event{UID: "other-event", RawData: first}); err != nil {
+		t.Fatalf("StoreEvent() error = %v", err)
+	}
+	if err := store.DeleteEvent("journal-event"); err != nil {
+		t.Fatalf("DeleteEvent() error = %v", err)
+	}
+
+	history, err := j.History("journal-event")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	want := []Entry{
+		{UID: "journal-event", Action: ActionCreate, AfterHash: Hash(first),
+			Source: Source{MessageID: "<first@example.com>", Path: "/mail/cur/1"}},
+		{UID: "journal-event", Action: ActionUpdate, BeforeHash: Hash(first), AfterHash: Hash(second),
+			Source: Source{MessageID: "<second@example.com>"}},
+		{UID: "journal-event", Action: ActionDelete, BeforeHash: Hash(second),
+			Source: Source{MessageID: "<second@example.com>"}},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(want), len(history), history)
+	}
+	for i, entry := range history {
+		if entry.Time.IsZero() {
+			t.Errorf("Entry %d has no time", i)
+		}
+		entry.Time = want[i].Time
+		if entry.UID != want[i].UID || entry.Action != want[i].Action ||
+			entry.BeforeHash != want[i].BeforeHash || entry.AfterHash != want[i].AfterHash ||
+			entry.MessageID != want[i].MessageID || entry.Path != want[i].Path {
+			t.Errorf("Entry %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected journal mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestJournalDeleteMissing(t *testing.T) {
+	j, err := Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	store := NewStorage(storage.NewMemoryStorage(), j)
+	if err := store.DeleteEvent("missing-event"); err != nil {
+		t.Fatalf("DeleteEvent() error = %v", err)
+	}
+
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no entry for a missing event, got %+v", entries)
+	}
+}
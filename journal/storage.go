@@ -0,0 +1,87 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// ErrRecord marks errors of writing the journal after the wrapped storage
+// was changed. The change is done, only its record is missing.
+var ErrRecord = errors.New("journal not written")
+
+// Storage records every change made through it in a journal before
+// passing it on to the wrapped storage
+type Storage struct {
+	storage.Storage
+	Journal *Journal
+
+	// Source is recorded with the following changes, the processor sets
+	// it for every email
+	Source Source
+}
+
+// NewStorage wraps a storage to record its changes in a journal
+func NewStorage(inner storage.Storage, journal *Journal) *Storage {
+	return &Storage{Storage: inner, Journal: journal}
+}
+
+// StoreEvent stores the event and records a create or update entry
+func (s *Storage) StoreEvent(event *ical.Event) error {
+	var before []byte
+	if existing, err := s.Storage.GetEvent(event.UID); err == nil && existing != nil {
+		before = existing.RawData
+	}
+
+	if err := s.Storage.StoreEvent(event); err != nil {
+		return err
+	}
+
+	action := ActionUpdate
+	if before == nil {
+		action = ActionCreate
+	}
+	return s.record(event.UID, action, before, event.RawData)
+}
+
+// DeleteEvent deletes the event and records a delete entry if it existed
+func (s *Storage) DeleteEvent(uid string) error {
+	var before []byte
+	if existing, err := s.Storage.GetEvent(uid); err == nil && existing != nil {
+		before = existing.RawData
+	}
+
+	if err := s.Storage.DeleteEvent(uid); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+	return s.record(uid, ActionDelete, before, nil)
+}
+
+// QueryEvents passes the query on to the wrapped storage
+func (s *Storage) QueryEvents(ctx context.Context, start, end time.Time) ([]*ical.Event, error) {
+	return s.Storage.QueryEvents(ctx, start, end)
+}
+
+// record appends a journal entry for a change
+func (s *Storage) record(uid, action string, before, after []byte) error {
+	err := s.Journal.Record(Entry{
+		UID:        uid,
+		Action:     action,
+		BeforeHash: Hash(before),
+		AfterHash:  Hash(after),
+		Before:     string(before),
+		After:      string(after),
+		Source:     s.Source,
+	})
+	if err != nil {
+		return fmt.Errorf("recording change of %s: %w: %w", uid, ErrRecord, err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package journal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// UndoOptions control how changes are undone
+type UndoOptions struct {
+	// Force restores objects even if they were changed after the undone
+	// changes, dropping those later changes as well
+	Force bool
+	// DryRun only reports what would be done
+	DryRun bool
+}
+
+// Undo reverts the changes recorded in entries: every UID touched is
+// restored to the version stored before its first change, or deleted if
+// that change created it. It returns one status message per UID.
+//
+// Objects that were changed after the last of the entries are skipped
+// unless opts.Force is set, so that unrelated updates are not lost.
+func Undo(store storage.Storage, entries []Entry, opts UndoOptions) ([]string, error) {
+	var uids []string
+	first := make(map[string]Entry)
+	last := make(map[string]Entry)
+	for _, entry := range entries {
+		if _, ok := first[entry.UID]; !ok {
+			first[entry.UID] = entry
+			uids = append(uids, entry.UID)
+		}
+		last[entry.UID] = entry
+	}
+
+	var results []string
+	for _, uid := range uids {
+		msg, err := undoObject(store, first[uid], last[uid], opts)
+		results = append(results, msg)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// undoObject restores one UID to its state before the first entry
+func undoObject(store storage.Storage, first, last Entry, opts UndoOptions) (string, error) {
+	uid := first.UID
+
+	var current []byte
+	if existing, err := store.GetEvent(uid); err == nil && existing != nil {
+		current = existing.RawData
+	}
+	if !matchesVersion(current, last.After, last.AfterHash) && !opts.Force {
+		return fmt.Sprintf("Skipped UID %s, it was changed later (use -force to restore anyway)", uid), nil
+	}
+
+	if first.BeforeHash == "" {
+		if current == nil {
+			return fmt.Sprintf("Nothing to undo for UID %s, it is not stored", uid), nil
+		}
+		if !opts.DryRun {
+			if err := store.DeleteEvent(uid); err != nil {
+				return fmt.Sprintf("Error deleting UID %s", uid), fmt.Errorf("deleting %s: %w", uid, err)
+			}
+		}
+		if opts.DryRun {
+			return fmt.Sprintf("Would delete UID %s", uid), nil
+		}
+		return fmt.Sprintf("Deleted UID %s", uid), nil
+	}
+
+	if first.Before == "" || Hash([]byte(first.Before)) != first.BeforeHash {
+		return fmt.Sprintf("Cannot restore UID %s, the journal has no snapshot of its previous version", uid), nil
+	}
+	if matchesVersion(current, first.Before, first.BeforeHash) {
+		return fmt.Sprintf("Nothing to undo for UID %s, the previous version is stored", uid), nil
+	}
+
+	if !opts.DryRun {
+		event, err := ical.ParseICalData([]byte(first.Before))
+		if err != nil || event.UID != uid {
+			event = &ical.Event{UID: uid, RawData: []byte(first.Before)}
+		}
+		if err := store.StoreEvent(event); err != nil {
+			return fmt.Sprintf("Error restoring UID %s", uid), fmt.Errorf("restoring %s: %w", uid, err)
+		}
+	}
+	verb := "Restored"
+	if opts.DryRun {
+		verb = "Would restore"
+	}
+	return fmt.Sprintf("%s UID %s to its version before %s", verb, uid,
+		first.Time.UTC().Format(time.RFC3339)), nil
+}
+
+// serverProperties are rewritten by some CalDAV servers when they store an
+// object, they never tell two versions apart
+var serverProperties = map[string]bool{
+	goical.PropProductID:    true,
+	goical.PropLastModified: true,
+	goical.PropCreated:      true,
+}
+
+// matchesVersion reports whether the stored data is the recorded version
+// with the snapshot data and hash. Servers that normalize what they store
+// reorder properties, fold lines differently and add a missing DTSTAMP, so
+// the data is compared decoded, ignoring the serverProperties and DTSTAMPs
+// the snapshot doesn't contain.
+func matchesVersion(current []byte, snapshot, hash string) bool {
+	if Hash(current) == hash {
+		return true
+	}
+	if current == nil || snapshot == "" || Hash([]byte(snapshot)) != hash {
+		return false
+	}
+
+	currentCal, err := ical.DecodeCalendar(current)
+	if err != nil {
+		return false
+	}
+	snapshotCal, err := ical.DecodeCalendar([]byte(snapshot))
+	if err != nil {
+		return false
+	}
+
+	// The component types that have a DTSTAMP in the snapshot
+	stamped := make(map[string]bool)
+	var collect func(comp *goical.Component)
+	collect = func(comp *goical.Component) {
+		if comp.Props.Get(goical.PropDateTimeStamp) != nil {
+			stamped[comp.Name] = true
+		}
+		for _, child := range comp.Children {
+			collect(child)
+		}
+	}
+	collect(snapshotCal.Component)
+
+	return canonicalComponent(currentCal.Component, stamped) == canonicalComponent(snapshotCal.Component, stamped)
+}
+
+// canonicalComponent writes a component with sorted properties, parameters
+// and children, leaving out the properties matchesVersion ignores
+func canonicalComponent(comp *goical.Component, stamped map[string]bool) string {
+	var lines []string
+	for name, props := range comp.Props {
+		if serverProperties[name] || (name == goical.PropDateTimeStamp && !stamped[comp.Name]) {
+			continue
+		}
+		for _, prop := range props {
+			var params []string
+			for param, values := range prop.Params {
+				params = append(params, param+"="+strings.Join(values, ","))
+			}
+			sort.Strings(params)
+			lines = append(lines, name+";"+strings.Join(params, ";")+":"+prop.Value)
+		}
+	}
+	sort.Strings(lines)
+
+	var children []string
+	for _, child := range comp.Children {
+		children = append(children, canonicalComponent(child, stamped))
+	}
+	sort.Strings(children)
+
+	return "BEGIN:" + comp.Name + "\n" + strings.Join(lines, "\n") + "\n" +
+		strings.Join(children, "") + "END:" + comp.Name + "\n"
+}
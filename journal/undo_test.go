@@ -0,0 +1,211 @@
+package journal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// undoCalendar returns a minimal calendar object for a UID and version
+func undoCalendar(uid string, sequence int) *ical.Event {
+	data := fmt.Sprintf("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\nBEGIN:VEVENT\r\n"+
+		"UID:%s\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250301T100000Z\r\nSEQUENCE:%d\r\n"+
+		"SUMMARY:Version %d\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", uid, sequence, sequence)
+	return &ical.Event{UID: uid, RawData: []byte(data)}
+}
+
+func storedSequence(t *testing.T, store storage.Storage, uid string) int {
+	t.Helper()
+
+	event, err := store.GetEvent(uid)
+	if err != nil || event == nil {
+		return -1
+	}
+	parsed, err := ical.ParseICalData(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse stored %s: %v", uid, err)
+	}
+	return parsed.Sequence
+}
+
+func TestUndo(t *testing.T) {
+	memory := storage.NewMemoryStorage()
+	j, err := Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	store := NewStorage(memory, j)
+
+	mustStore := func(event *ical.Event, source Source) {
+		t.Helper()
+		store.Source = source
+		if err := store.StoreEvent(event); err != nil {
+			t.Fatalf("StoreEvent() error = %v", err)
+		}
+	}
+
+	good := Source{MessageID: "<good@example.com>"}
+	bad := Source{MessageID: "<bad@example.com>"}
+	mustStore(undoCalendar("kept-event", 0), good)
+	mustStore(undoCalendar("updated-event", 0), good)
+	mustStore(undoCalendar("updated-event", 1), bad)
+	mustStore(undoCalendar("created-event", 0), bad)
+	mustStore(undoCalendar("changed-later", 0), bad)
+	mustStore(undoCalendar("changed-later", 1), good)
+
+	entries, err := j.ByMessage("bad@example.com")
+	if err != nil {
+		t.Fatalf("ByMessage() error = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries of the bad message, got %d", len(entries))
+	}
+
+	results, err := Undo(store, entries, UndoOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	t.Logf("Dry run: %v", results)
+	if storedSequence(t, memory, "updated-event") != 1 || storedSequence(t, memory, "created-event") != 0 {
+		t.Fatal("Expected a dry run to change nothing")
+	}
+
+	results, err = Undo(store, entries, UndoOptions{})
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	t.Logf("Undo: %v", results)
+	if len(results) != 3 {
+		t.Errorf("Expected one result per UID, got %v", results)
+	}
+
+	for uid, want := range map[string]int{
+		"kept-event":    0,
+		"updated-event": 0,
+		"created-event": -1,
+		"changed-later": 1,
+	} {
+		if got := storedSequence(t, memory, uid); got != want {
+			t.Errorf("%s: expected stored SEQUENCE %d, got %d", uid, want, got)
+		}
+	}
+
+	// Undoing again finds nothing left to do, forcing removes later changes
+	if _, err := Undo(store, entries, UndoOptions{Force: true}); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if got := storedSequence(t, memory, "changed-later"); got != -1 {
+		t.Errorf("Expected a forced undo to delete changed-later, got SEQUENCE %d", got)
+	}
+
+	// The undo itself is journaled
+	history, err := j.History("updated-event")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if last := history[len(history)-1]; last.Action != ActionUpdate || last.AfterHash != Hash(undoCalendar("updated-event", 0).RawData) {
+		t.Errorf("Expected the restore to be recorded, got %+v", last)
+	}
+}
+
+func TestSince(t *testing.T) {
+	j, err := Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		err := j.Record(Entry{Time: start.Add(time.Duration(i) * time.Hour), UID: fmt.Sprintf("event-%d", i), Action: ActionCreate})
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	entries, err := j.Since(start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Since() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].UID != "event-1" || entries[1].UID != "event-2" {
+		t.Errorf("Expected event-1 and event-2, got %+v", entries)
+	}
+}
+
+// normalizingStorage stores objects the way some CalDAV servers do: with
+// their own PRODID, an added LAST-MODIFIED and the properties reordered
+type normalizingStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s normalizingStorage) StoreEvent(event *ical.Event) error {
+	var summary string
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(event.RawData), "\r\n"), "\r\n") {
+		switch {
+		case strings.HasPrefix(line, "PRODID:"):
+			line = "PRODID:-//Server//EN"
+		case strings.HasPrefix(line, "SUMMARY:"):
+			summary = line
+			continue
+		case line == "BEGIN:VEVENT":
+			lines = append(lines, line, "LAST-MODIFIED:20250601T120000Z")
+			continue
+		case line == "END:VEVENT":
+			lines = append(lines, summary)
+		}
+		lines = append(lines, line)
+	}
+	normalized := *event
+	normalized.RawData = []byte(strings.Join(lines, "\r\n") + "\r\n")
+	return s.MemoryStorage.StoreEvent(&normalized)
+}
+
+func TestUndoNormalizingServer(t *testing.T) {
+	server := normalizingStorage{storage.NewMemoryStorage()}
+	j, err := Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	store := NewStorage(server, j)
+
+	good := Source{MessageID: "<good@example.com>"}
+	bad := Source{MessageID: "<bad@example.com>"}
+	for _, change := range []struct {
+		event  *ical.Event
+		source Source
+	}{
+		{undoCalendar("updated-event", 0), good},
+		{undoCalendar("updated-event", 1), bad},
+		{undoCalendar("changed-later", 0), bad},
+		{undoCalendar("changed-later", 1), good},
+	} {
+		store.Source = change.source
+		if err := store.StoreEvent(change.event); err != nil {
+			t.Fatalf("StoreEvent() error = %v", err)
+		}
+	}
+
+	entries, err := j.ByMessage("bad@example.com")
+	if err != nil {
+		t.Fatalf("ByMessage() error = %v", err)
+	}
+	results, err := Undo(store, entries, UndoOptions{})
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	t.Logf("Undo: %v", results)
+
+	// The normalized copy is still the version the bad email stored, a
+	// real change afterwards is kept
+	if got := storedSequence(t, server, "updated-event"); got != 0 {
+		t.Errorf("Expected updated-event to be restored, got SEQUENCE %d", got)
+	}
+	if got := storedSequence(t, server, "changed-later"); got != 1 {
+		t.Errorf("Expected changed-later to be skipped, got SEQUENCE %d", got)
+	}
+}
@@ -0,0 +1,167 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+)
+
+// journaldSocket is the socket of the native journal protocol
+var journaldSocket = "/run/systemd/journal/socket"
+
+// journaldHandler sends records to the systemd journal with the native
+// protocol. Attributes become fields like UID and PATH, so that
+// journalctl can filter on them.
+type journaldHandler struct {
+	conn    *journaldConn
+	options *slog.HandlerOptions
+	fields  []journaldField
+	prefix  string
+}
+
+// journaldConn is shared by a journaldHandler and the handlers derived
+// from it
+type journaldConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+type journaldField struct {
+	name  string
+	value string
+}
+
+func newJournaldHandler(socket string, options *slog.HandlerOptions) (*journaldHandler, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the journal: %w", err)
+	}
+	return &journaldHandler{conn: &journaldConn{conn: conn}, options: options}, nil
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minimum := slog.LevelInfo
+	if h.options.Level != nil {
+		minimum = h.options.Level.Level()
+	}
+	return level >= minimum
+}
+
+func (h *journaldHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := []journaldField{
+		{"MESSAGE", record.Message},
+		{"PRIORITY", fmt.Sprint(journaldPriority(record.Level))},
+		{"SYSLOG_IDENTIFIER", Identifier},
+	}
+	fields = append(fields, h.fields...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendJournaldFields(fields, h.prefix, attr)
+		return true
+	})
+
+	var msg bytes.Buffer
+	for _, field := range fields {
+		writeJournaldField(&msg, field)
+	}
+
+	// Datagrams are limited in size, records too large for one are lost
+	h.conn.mu.Lock()
+	defer h.conn.mu.Unlock()
+	if _, err := h.conn.conn.Write(msg.Bytes()); err != nil {
+		return fmt.Errorf("writing to the journal: %w", err)
+	}
+	return nil
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.fields = append([]journaldField(nil), h.fields...)
+	for _, attr := range attrs {
+		derived.fields = appendJournaldFields(derived.fields, h.prefix, attr)
+	}
+	return &derived
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	derived := *h
+	derived.prefix = h.prefix + name + "_"
+	return &derived
+}
+
+// journaldPriority maps a level to a syslog severity
+func journaldPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// appendJournaldFields appends an attribute as field, groups are
+// flattened into their members
+func appendJournaldFields(fields []journaldField, prefix string, attr slog.Attr) []journaldField {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "_"
+		}
+		for _, member := range attr.Value.Group() {
+			fields = appendJournaldFields(fields, prefix, member)
+		}
+		return fields
+	}
+	return append(fields, journaldField{journaldFieldName(prefix + attr.Key), attr.Value.String()})
+}
+
+// journaldFieldName turns a key into a valid field name: upper case
+// letters, digits and underscores, not starting with an underscore (those
+// are reserved for the journal)
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	if name == "" || name[0] < 'A' || name[0] > 'Z' {
+		name = "X" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// writeJournaldField encodes a field, values with newlines are written
+// with their length in front
+func writeJournaldField(buf *bytes.Buffer, field journaldField) {
+	if !strings.Contains(field.value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", field.name, field.value)
+		return
+	}
+	buf.WriteString(field.name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(field.value)))
+	buf.WriteString(field.value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Log formats of the stderr output
+const (
+	// FormatText writes key=value lines, this is the default
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line
+	FormatJSON = "json"
+)
+
+// Log outputs
+const (
+	// OutputStderr writes to stderr, this is the default. stdout is kept
+	// for the results of commands.
+	OutputStderr = "stderr"
+	// OutputSyslog sends to the local syslog daemon with facility mail
+	OutputSyslog = "syslog"
+	// OutputJournald sends to the systemd journal, attributes become
+	// journal fields
+	OutputJournald = "journald"
+)
+
+// Identifier is the program name syslog and the journal record
+const Identifier = "calmailproc"
+
+// Config configures the log output
+type Config struct {
+	// Level is debug, info (default), warn or error
+	Level string `yaml:"level"`
+	// Format is one of the Format* formats, empty means text
+	Format string `yaml:"format"`
+	// Output is one of the Output* outputs, empty means stderr
+	Output string `yaml:"output"`
+}
+
+// ParseLevel parses a level name, the empty name is info
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level: %s", name)
+	}
+	return level, nil
+}
+
+// New creates the logger selected by config. stderr is the writer of the
+// stderr output.
+func New(config Config, stderr io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	switch config.Output {
+	case "", OutputStderr:
+		handler, err := newFormatHandler(config.Format, stderr, options)
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(handler), nil
+	case OutputSyslog:
+		// syslog adds the time and the severity itself
+		options.ReplaceAttr = dropTimeAndLevel
+		return newSyslogLogger(config.Format, options)
+	case OutputJournald:
+		handler, err := newJournaldHandler(journaldSocket, options)
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(handler), nil
+	default:
+		return nil, fmt.Errorf("unknown log output: %s", config.Output)
+	}
+}
+
+// newFormatHandler creates a text or JSON handler writing to w
+func newFormatHandler(format string, w io.Writer, options *slog.HandlerOptions) (slog.Handler, error) {
+	switch format {
+	case "", FormatText:
+		return slog.NewTextHandler(w, options), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, options), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// dropTimeAndLevel removes the time and level of records for outputs that
+// record them on their own
+func dropTimeAndLevel(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey) {
+		return slog.Attr{}
+	}
+	return attr
+}
+
+// Discard returns a logger that drops everything, used where no logger
+// is given
+func Discard() *slog.Logger {
+	return discard
+}
+
+var discard = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// lineHandler formats records with a text or JSON handler and passes each
+// line with its level to a writer that needs the level, like syslog
+type lineHandler struct {
+	handler slog.Handler
+	out     *lineOutput
+}
+
+// lineOutput is shared by a lineHandler and the handlers derived from it
+type lineOutput struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	write func(level slog.Level, line string) error
+}
+
+func newLineHandler(format string, options *slog.HandlerOptions, write func(level slog.Level, line string) error) (*lineHandler, error) {
+	out := &lineOutput{write: write}
+	handler, err := newFormatHandler(format, &out.buf, options)
+	if err != nil {
+		return nil, err
+	}
+	return &lineHandler{handler: handler, out: out}, nil
+}
+
+func (h *lineHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *lineHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	h.out.buf.Reset()
+	if err := h.handler.Handle(ctx, record); err != nil {
+		return err
+	}
+	return h.out.write(record.Level, strings.TrimSuffix(h.out.buf.String(), "\n"))
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lineHandler{handler: h.handler.WithAttrs(attrs), out: h.out}
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	return &lineHandler{handler: h.handler.WithGroup(name), out: h.out}
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewStderr(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Config{Level: "warn", Format: FormatJSON}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Not shown")
+	logger.With("uid", "event-1").Warn("Failed to update attendee status", "method", "REPLY")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line, got %q", out.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected JSON: %v", err)
+	}
+	if record["level"] != "WARN" || record["uid"] != "event-1" || record["method"] != "REPLY" {
+		t.Errorf("Unexpected record: %v", record)
+	}
+
+	out.Reset()
+	logger, err = New(Config{}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Not shown")
+	logger.Info("Processed email", "path", "/mail/cur/1")
+	if !strings.Contains(out.String(), `level=INFO msg="Processed email" path=/mail/cur/1`) {
+		t.Errorf("Expected a text line, got %q", out.String())
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, config := range []Config{
+		{Level: "verbose"},
+		{Format: "xml"},
+		{Output: "file"},
+	} {
+		if _, err := New(config, &bytes.Buffer{}); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
+
+func TestLineHandler(t *testing.T) {
+	type line struct {
+		level slog.Level
+		text  string
+	}
+	var lines []line
+	handler, err := newLineHandler(FormatText, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: dropTimeAndLevel},
+		func(level slog.Level, text string) error {
+			lines = append(lines, line{level, text})
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(handler).With("path", "/mail/cur/1")
+	logger.Debug("Processing email")
+	logger.Error("Processing email failed", "error", "broken")
+
+	want := []line{
+		{slog.LevelDebug, `msg="Processing email" path=/mail/cur/1`},
+		{slog.LevelError, `msg="Processing email failed" path=/mail/cur/1 error=broken`},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %v", len(want), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Line %d: expected %v, got %v", i, want[i], lines[i])
+		}
+	}
+}
+
+// readJournaldFields decodes a datagram of the native journal protocol
+func readJournaldFields(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			t.Fatalf("Unterminated field in %q", data)
+		}
+		entry := string(data[:end])
+		data = data[end+1:]
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			fields[name] = value
+			continue
+		}
+		size := binary.LittleEndian.Uint64(data[:8])
+		fields[entry] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournald(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Unix datagram sockets not available: %v", err)
+	}
+	defer listener.Close()
+
+	original := journaldSocket
+	journaldSocket = socket
+	defer func() { journaldSocket = original }()
+
+	logger, err := New(Config{Output: OutputJournald}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Not sent")
+	logger.WithGroup("event").With("uid", "event-1").Warn("Failed to update attendee status",
+		"error", "line one\nline two", "message-id", "<a@example.com>")
+
+	buf := make([]byte, 65536)
+	n, err := listener.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := readJournaldFields(t, buf[:n])
+
+	want := map[string]string{
+		"MESSAGE":           "Failed to update attendee status",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "calmailproc",
+		"EVENT_UID":         "event-1",
+		"EVENT_ERROR":       "line one\nline two",
+		"EVENT_MESSAGE_ID":  "<a@example.com>",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, fields[name])
+		}
+	}
+}
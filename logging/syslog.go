@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"log/syslog"
+)
+
+// newSyslogLogger sends records to the local syslog daemon with the
+// severity of their level
+func newSyslogLogger(format string, options *slog.HandlerOptions) (*slog.Logger, error) {
+	writer, err := syslog.New(syslog.LOG_MAIL|syslog.LOG_INFO, Identifier)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to syslog: %w", err)
+	}
+
+	handler, err := newLineHandler(format, options, func(level slog.Level, line string) error {
+		switch {
+		case level >= slog.LevelError:
+			return writer.Err(line)
+		case level >= slog.LevelWarn:
+			return writer.Warning(line)
+		case level >= slog.LevelInfo:
+			return writer.Info(line)
+		default:
+			return writer.Debug(line)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(handler), nil
+}
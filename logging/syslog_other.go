@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// newSyslogLogger fails, there is no syslog on this platform
+func newSyslogLogger(format string, options *slog.HandlerOptions) (*slog.Logger, error) {
+	return nil, fmt.Errorf("syslog output is not supported on this platform")
+}
@@ -12,6 +12,6 @@ func main() {
 	
 	if err := cli.Run(config); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
\ No newline at end of file
@@ -0,0 +1,109 @@
+package outbound
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// subjectPrefixes are the usual subject prefixes of iTIP replies
+var subjectPrefixes = map[string]string{
+	PartstatAccepted:  "Accepted",
+	PartstatDeclined:  "Declined",
+	PartstatTentative: "Tentative",
+}
+
+// BuildMessage wraps a reply into a MIME message from the given address to
+// the organizer. The message carries a short text part and the iCalendar
+// data as text/calendar part with method=REPLY.
+func BuildMessage(from string, reply *Reply, date time.Time) ([]byte, error) {
+	if from == "" {
+		from = reply.Attendee
+	}
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("parsing sender address: %w", err)
+	}
+
+	prefix := subjectPrefixes[reply.Partstat]
+	if prefix == "" {
+		prefix = reply.Partstat
+	}
+	subject := prefix
+	if reply.Summary != "" {
+		subject = prefix + ": " + reply.Summary
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	text, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating text part: %w", err)
+	}
+	fmt.Fprintf(text, "%s has replied %s to %q.\r\n", reply.Attendee, strings.ToLower(reply.Partstat), reply.Summary)
+
+	calendar, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/calendar; charset=UTF-8; method=REPLY"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating calendar part: %w", err)
+	}
+	if _, err := calendar.Write(wrapBase64(reply.Data)); err != nil {
+		return nil, fmt.Errorf("writing calendar part: %w", err)
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, fmt.Errorf("closing message: %w", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", fromAddress.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", (&mail.Address{Address: reply.Organizer}).String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: %s\r\n", messageID(fromAddress.Address))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n", parts.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// wrapBase64 encodes data as base64 with lines of 76 characters
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var wrapped bytes.Buffer
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+	return wrapped.Bytes()
+}
+
+// messageID generates a unique Message-ID in the domain of the sender
+func messageID(address string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		domain = address[at+1:]
+	}
+
+	random := make([]byte, 8)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
@@ -0,0 +1,117 @@
+package outbound
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// Participation statuses an attendee can answer an invitation with
+const (
+	PartstatAccepted  = "ACCEPTED"
+	PartstatDeclined  = "DECLINED"
+	PartstatTentative = "TENTATIVE"
+)
+
+// replyProperties are copied from the answered component into the reply.
+// RFC 5546 only requires UID, SEQUENCE, RECURRENCE-ID and ORGANIZER, the
+// others let mail clients show what the answer is about.
+var replyProperties = []string{"UID", "SEQUENCE", "RECURRENCE-ID", "ORGANIZER", "SUMMARY",
+	"DTSTART", "DTEND", "DURATION", "DUE"}
+
+// ParsePartstat maps an answer like "accept", "decline" or "tentative" to
+// its PARTSTAT value
+func ParsePartstat(answer string) (string, error) {
+	switch strings.ToLower(answer) {
+	case "accept", "accepted":
+		return PartstatAccepted, nil
+	case "decline", "declined":
+		return PartstatDeclined, nil
+	case "tentative":
+		return PartstatTentative, nil
+	default:
+		return "", fmt.Errorf("unknown answer %q, expected accept, decline or tentative", answer)
+	}
+}
+
+// Reply is an iTIP METHOD:REPLY for one attendee of a calendar object
+type Reply struct {
+	UID       string
+	Summary   string
+	Attendee  string // Email address of the answering attendee
+	Organizer string // Email address the reply is sent to
+	Partstat  string
+	Data      []byte // The METHOD:REPLY iCalendar data
+}
+
+// BuildReply builds the reply of an attendee to a calendar object. The
+// component is the master or the single occurrence being answered, cal the
+// calendar it is stored in.
+func BuildReply(cal *goical.Calendar, component *goical.Component, attendee, partstat string) (*Reply, error) {
+	organizer := component.Props.Get("ORGANIZER")
+	if organizer == nil {
+		return nil, fmt.Errorf("no ORGANIZER to reply to")
+	}
+	uid := component.Props.Get("UID")
+	if uid == nil {
+		return nil, fmt.Errorf("no UID in calendar object")
+	}
+
+	var attendeeProp *goical.Prop
+	for _, prop := range component.Props.Values("ATTENDEE") {
+		if ical.CalendarAddress(prop.Value) == ical.CalendarAddress(attendee) {
+			prop := prop
+			attendeeProp = &prop
+			break
+		}
+	}
+	if attendeeProp == nil {
+		return nil, fmt.Errorf("%s is not an attendee", attendee)
+	}
+
+	answer := goical.NewComponent(component.Name)
+	for _, name := range replyProperties {
+		if props, ok := component.Props[name]; ok {
+			answer.Props[name] = props
+		}
+	}
+	answer.Props.Set(&goical.Prop{Name: "DTSTAMP", Params: goical.Params{},
+		Value: time.Now().UTC().Format("20060102T150405Z")})
+
+	// Only the answering attendee goes into the reply, with a fresh
+	// parameter set so the stored component stays untouched
+	params := goical.Params{}
+	for name, values := range attendeeProp.Params {
+		if name != "RSVP" {
+			params[name] = append([]string(nil), values...)
+		}
+	}
+	params.Set("PARTSTAT", partstat)
+	answer.Props.Set(&goical.Prop{Name: "ATTENDEE", Params: params, Value: attendeeProp.Value})
+
+	replyCal := ical.NewCalendar()
+	replyCal.Props.SetText("METHOD", "REPLY")
+	replyCal.Children = append(replyCal.Children, answer)
+	ical.MergeTimezones(replyCal, cal)
+	ical.EnsureTimezones(replyCal)
+
+	data, err := ical.EncodeCalendar(replyCal)
+	if err != nil {
+		return nil, fmt.Errorf("encoding reply: %w", err)
+	}
+
+	reply := &Reply{
+		UID:       uid.Value,
+		Attendee:  ical.CalendarAddress(attendeeProp.Value),
+		Organizer: ical.CalendarAddress(organizer.Value),
+		Partstat:  partstat,
+		Data:      data,
+	}
+	if summary := component.Props.Get("SUMMARY"); summary != nil {
+		reply.Summary = summary.Value
+	}
+	return reply, nil
+}
@@ -0,0 +1,142 @@
+package outbound
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/email"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+const invitation = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:reply-test-event
+SUMMARY:Planning
+DTSTART;TZID=Europe/Berlin:20250310T100000
+DTEND;TZID=Europe/Berlin:20250310T110000
+SEQUENCE:2
+ORGANIZER;CN=Organizer:mailto:organizer@example.com
+ATTENDEE;CN=Attendee;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:Attendee@Example.com
+ATTENDEE;PARTSTAT=ACCEPTED:mailto:colleague@example.com
+DESCRIPTION:Agenda follows
+DTSTAMP:20250301T000000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParsePartstat(t *testing.T) {
+	tests := map[string]string{
+		"accept":    PartstatAccepted,
+		"Decline":   PartstatDeclined,
+		"tentative": PartstatTentative,
+	}
+	for answer, want := range tests {
+		if got, err := ParsePartstat(answer); err != nil || got != want {
+			t.Errorf("ParsePartstat(%q) = %q, %v, want %q", answer, got, err, want)
+		}
+	}
+	if _, err := ParsePartstat("maybe"); err == nil {
+		t.Error("ParsePartstat(\"maybe\") expected an error")
+	}
+}
+
+func TestBuildReply(t *testing.T) {
+	cal, err := ical.DecodeCalendar([]byte(invitation))
+	if err != nil {
+		t.Fatalf("DecodeCalendar() error = %v", err)
+	}
+	component := cal.Children[0]
+
+	reply, err := BuildReply(cal, component, "attendee@example.com", PartstatAccepted)
+	if err != nil {
+		t.Fatalf("BuildReply() error = %v", err)
+	}
+	if reply.Organizer != "organizer@example.com" || reply.Attendee != "attendee@example.com" {
+		t.Errorf("BuildReply() addresses = %s -> %s", reply.Attendee, reply.Organizer)
+	}
+
+	replyCal, err := ical.DecodeCalendar(reply.Data)
+	if err != nil {
+		t.Fatalf("reply is no valid iCalendar data: %v", err)
+	}
+	if method := replyCal.Props.Get("METHOD"); method == nil || method.Value != "REPLY" {
+		t.Errorf("METHOD = %v, want REPLY", method)
+	}
+
+	var answer *ical.Component
+	timezones := 0
+	for _, child := range replyCal.Children {
+		switch child.Name {
+		case "VEVENT":
+			answer = child
+		case "VTIMEZONE":
+			timezones++
+		}
+	}
+	if answer == nil {
+		t.Fatal("reply contains no VEVENT")
+	}
+	if timezones != 1 {
+		t.Errorf("expected a VTIMEZONE for Europe/Berlin, got %d", timezones)
+	}
+
+	attendees := answer.Props.Values("ATTENDEE")
+	if len(attendees) != 1 {
+		t.Fatalf("expected only the answering attendee, got %d", len(attendees))
+	}
+	if partstat := attendees[0].Params.Get("PARTSTAT"); partstat != PartstatAccepted {
+		t.Errorf("PARTSTAT = %s, want %s", partstat, PartstatAccepted)
+	}
+	if attendees[0].Params.Get("RSVP") != "" {
+		t.Error("expected RSVP to be removed from the reply")
+	}
+	if seq := answer.Props.Get("SEQUENCE"); seq == nil || seq.Value != "2" {
+		t.Errorf("SEQUENCE = %v, want 2", seq)
+	}
+	if answer.Props.Get("DESCRIPTION") != nil {
+		t.Error("expected DESCRIPTION not to be copied into the reply")
+	}
+
+	// The stored component must not change
+	if partstat := component.Props.Get("ATTENDEE").Params.Get("PARTSTAT"); partstat != "NEEDS-ACTION" {
+		t.Errorf("stored PARTSTAT changed to %s", partstat)
+	}
+
+	if _, err := BuildReply(cal, component, "stranger@example.com", PartstatAccepted); err == nil {
+		t.Error("expected an error for an address that is no attendee")
+	}
+}
+
+func TestBuildMessage(t *testing.T) {
+	cal, err := ical.DecodeCalendar([]byte(invitation))
+	if err != nil {
+		t.Fatalf("DecodeCalendar() error = %v", err)
+	}
+	reply, err := BuildReply(cal, cal.Children[0], "attendee@example.com", PartstatDeclined)
+	if err != nil {
+		t.Fatalf("BuildReply() error = %v", err)
+	}
+
+	msg, err := BuildMessage("Attendee <attendee@example.com>", reply, time.Date(2025, 3, 2, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("BuildMessage() error = %v", err)
+	}
+
+	parsed, err := email.Parse(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("message can't be parsed: %v", err)
+	}
+	if parsed.Subject != "Declined: Planning" {
+		t.Errorf("Subject = %q, want %q", parsed.Subject, "Declined: Planning")
+	}
+	if !strings.Contains(parsed.To, "organizer@example.com") {
+		t.Errorf("To = %q, want the organizer", parsed.To)
+	}
+	if !parsed.HasCalendar || parsed.Event.Method != "REPLY" || parsed.Event.UID != "reply-test-event" {
+		t.Fatalf("expected a REPLY for reply-test-event, got %+v", parsed.Event)
+	}
+}
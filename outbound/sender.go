@@ -0,0 +1,140 @@
+package outbound
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os/exec"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+)
+
+// DefaultSendmailPath is the sendmail binary used without configuration
+const DefaultSendmailPath = "/usr/sbin/sendmail"
+
+// Config configures how replies are sent. Without an SMTP server the
+// sendmail binary is used.
+type Config struct {
+	// From is the sender address, defaults to the attendee address
+	From string `yaml:"from"`
+	// Sendmail is the path of a sendmail compatible binary
+	Sendmail string `yaml:"sendmail"`
+	// SMTPAddr is the host:port of an SMTP server
+	SMTPAddr string `yaml:"smtp_addr"`
+	SMTPUser string `yaml:"smtp_user"`
+	SMTPPass string `yaml:"smtp_pass"`
+}
+
+// Sender delivers a complete MIME message to its recipients
+type Sender interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// SendmailSender delivers messages through a sendmail compatible binary
+type SendmailSender struct {
+	Path string
+}
+
+// Send pipes the message into sendmail
+func (s *SendmailSender) Send(from string, to []string, msg []byte) error {
+	path := s.Path
+	if path == "" {
+		path = DefaultSendmailPath
+	}
+
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running %s: %w: %s", path, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// SMTPSender delivers messages to an SMTP server, using STARTTLS when the
+// server offers it
+type SMTPSender struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+// Send submits the message to the SMTP server
+func (s *SMTPSender) Send(from string, to []string, msg []byte) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("parsing SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	if err := smtp.SendMail(s.Addr, auth, from, to, msg); err != nil {
+		return fmt.Errorf("sending mail via %s: %w", s.Addr, err)
+	}
+	return nil
+}
+
+// NewSenderFromConfig creates the sender selected by the configuration
+func NewSenderFromConfig(config Config) (Sender, error) {
+	if config.SMTPAddr != "" {
+		if config.Sendmail != "" {
+			return nil, fmt.Errorf("configure either sendmail or smtp_addr, not both")
+		}
+		return &SMTPSender{
+			Addr:     config.SMTPAddr,
+			Username: config.SMTPUser,
+			Password: config.SMTPPass,
+		}, nil
+	}
+	return &SendmailSender{Path: config.Sendmail}, nil
+}
+
+// Replier answers invitations on behalf of an attendee
+type Replier struct {
+	Sender Sender
+	From   string
+}
+
+// NewReplierFromConfig creates a replier with the configured sender
+func NewReplierFromConfig(config Config) (*Replier, error) {
+	sender, err := NewSenderFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Replier{Sender: sender, From: config.From}, nil
+}
+
+// Reply sends the answer of an attendee to the organizer of a calendar
+// object and returns the reply that was sent
+func (r *Replier) Reply(cal *goical.Calendar, component *goical.Component, attendee, partstat string) (*Reply, error) {
+	reply, err := BuildReply(cal, component, attendee, partstat)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := BuildMessage(r.From, reply, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// The envelope sender is the bare address
+	from := reply.Attendee
+	if r.From != "" {
+		address, err := mail.ParseAddress(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sender address: %w", err)
+		}
+		from = address.Address
+	}
+
+	if err := r.Sender.Send(from, []string{reply.Organizer}, msg); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,139 @@
+package outbound
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// smtpServer is a minimal SMTP server that accepts one message
+type smtpServer struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &smtpServer{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+
+	go server.serve()
+	return server
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP test")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	server := newSMTPServer(t)
+
+	sender, err := NewSenderFromConfig(Config{SMTPAddr: server.listener.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewSenderFromConfig() error = %v", err)
+	}
+	msg := "Subject: Accepted: Planning\r\n\r\nBody\r\n"
+	if err := sender.Send("attendee@example.com", []string{"organizer@example.com"}, []byte(msg)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	<-server.done
+
+	if server.from != "attendee@example.com" {
+		t.Errorf("MAIL FROM = %s, want attendee@example.com", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "organizer@example.com" {
+		t.Errorf("RCPT TO = %v, want organizer@example.com", server.to)
+	}
+	if !strings.Contains(server.data, "Subject: Accepted: Planning") {
+		t.Errorf("DATA = %q, missing the message", server.data)
+	}
+}
+
+func TestSendmailSender(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "sent")
+	script := filepath.Join(dir, "sendmail")
+	content := "#!/bin/sh\necho \"$@\" > " + output + ".args\ncat > " + output + "\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write sendmail script: %v", err)
+	}
+
+	sender := &SendmailSender{Path: script}
+	if err := sender.Send("attendee@example.com", []string{"organizer@example.com"}, []byte("Subject: Test\r\n\r\nBody\r\n")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	args, err := os.ReadFile(output + ".args")
+	if err != nil {
+		t.Fatalf("sendmail was not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(args)); got != "-i -f attendee@example.com -- organizer@example.com" {
+		t.Errorf("sendmail arguments = %q", got)
+	}
+	if msg, _ := os.ReadFile(output); !strings.Contains(string(msg), "Subject: Test") {
+		t.Errorf("sendmail input = %q, missing the message", msg)
+	}
+
+	if _, err := NewSenderFromConfig(Config{Sendmail: script, SMTPAddr: "localhost:25"}); err == nil {
+		t.Error("expected an error when both sendmail and SMTP are configured")
+	}
+}
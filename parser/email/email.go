@@ -17,6 +17,7 @@ type Email struct {
 	Subject           string
 	From              string
 	To                string
+	MessageID         string
 	Date              time.Time
 	HasCalendar       bool
 	Event             *ical.Event
@@ -35,6 +36,7 @@ func Parse(r io.Reader) (*Email, error) {
 		Subject:           msg.Header.Get("Subject"),
 		From:              msg.Header.Get("From"),
 		To:                msg.Header.Get("To"),
+		MessageID:         strings.TrimSpace(msg.Header.Get("Message-ID")),
 	}
 
 	// Parse the date
@@ -39,6 +39,11 @@ func TestParseEmail(t *testing.T) {
 		t.Errorf("Expected non-zero date")
 	}
 	t.Logf("Email date: %v", email.Date)
+
+	if email.MessageID == "" {
+		t.Errorf("Expected non-empty Message-ID")
+	}
+	t.Logf("Email Message-ID: %s", email.MessageID)
 	
 
 	// Check calendar information
@@ -3,6 +3,7 @@ package ical
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	goical "github.com/emersion/go-ical"
@@ -95,22 +96,22 @@ func extractDTSTAMP(icsData []byte) (time.Time, error) {
 		return time.Time{}, fmt.Errorf("parsing iCal data for DTSTAMP: %w", err)
 	}
 
-	// Find the first VEVENT component
+	// Find the first calendar object component
 	for _, component := range cal.Children {
-		if component.Name != "VEVENT" {
+		if !IsObjectComponent(component.Name) {
 			continue
 		}
 
 		dtstampProp := component.Props.Get("DTSTAMP")
 		if dtstampProp == nil {
-			return time.Time{}, fmt.Errorf("VEVENT missing DTSTAMP property")
+			return time.Time{}, fmt.Errorf("%s missing DTSTAMP property", component.Name)
 		}
 
 		// Parse the timestamp in iCalendar format
 		return parseICalTime(dtstampProp.Value)
 	}
 
-	return time.Time{}, fmt.Errorf("no VEVENT component found")
+	return time.Time{}, fmt.Errorf("no VEVENT, VTODO or VJOURNAL component found")
 }
 
 // parseICalTime parses an iCalendar timestamp string into a time.Time
@@ -139,3 +140,56 @@ func parseICalTime(timeStr string) (time.Time, error) {
 
 	return time.Parse(isoLayout, timeStr)
 }
+
+// significantProperties are the properties whose change makes attendees
+// reconsider their participation according to RFC 5546, section 2.1.4
+var significantProperties = []string{"DTSTART", "DTEND", "DURATION", "DUE", "RRULE", "RDATE", "EXDATE", "LOCATION"}
+
+// IsSignificantChange reports whether an update changes the time, the
+// recurrence or the location of a calendar object compared to the stored
+// version. Date-time values are compared as instants, so a re-sent
+// invitation that spells the same time in another zone is not significant.
+func IsSignificantChange(old *Component, oldCal *Calendar, updated *Component, updatedCal *Calendar) bool {
+	for _, name := range significantProperties {
+		switch name {
+		case "DTSTART", "DTEND", "DUE", "RDATE", "EXDATE":
+			if !sameInstants(dateTimeValues(old, name, oldCal), dateTimeValues(updated, name, updatedCal)) {
+				return true
+			}
+		default:
+			if propertyValues(old, name) != propertyValues(updated, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameInstants reports whether two lists contain the same points in time,
+// regardless of their order
+func sameInstants(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[int64]int)
+	for _, t := range a {
+		count[t.Unix()]++
+	}
+	for _, t := range b {
+		if count[t.Unix()] == 0 {
+			return false
+		}
+		count[t.Unix()]--
+	}
+	return true
+}
+
+// propertyValues joins the trimmed values of a property for comparison
+func propertyValues(component *Component, name string) string {
+	var values []string
+	for _, prop := range component.Props.Values(name) {
+		values = append(values, strings.TrimSpace(prop.Value))
+	}
+	return strings.Join(values, "\n")
+}
@@ -1,6 +1,7 @@
 package ical
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -196,4 +197,53 @@ func TestParseICalTime(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestIsSignificantChange(t *testing.T) {
+	stored := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:significant-change
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Berlin:20250310T110000
+DTEND;TZID=Europe/Berlin:20250310T120000
+RRULE:FREQ=WEEKLY;COUNT=4
+EXDATE:20250317T100000Z
+LOCATION:Room 1
+SUMMARY:Planning
+END:VEVENT
+END:VCALENDAR
+`
+
+	tests := []struct {
+		name        string
+		replace     [2]string
+		significant bool
+	}{
+		{"summary changed", [2]string{"SUMMARY:Planning", "SUMMARY:Planning (agenda)"}, false},
+		{"same start in UTC", [2]string{"DTSTART;TZID=Europe/Berlin:20250310T110000", "DTSTART:20250310T100000Z"}, false},
+		{"start moved", [2]string{"DTSTART;TZID=Europe/Berlin:20250310T110000", "DTSTART;TZID=Europe/Berlin:20250310T113000"}, true},
+		{"end moved", [2]string{"DTEND;TZID=Europe/Berlin:20250310T120000", "DTEND;TZID=Europe/Berlin:20250310T130000"}, true},
+		{"location changed", [2]string{"LOCATION:Room 1", "LOCATION:Room 2"}, true},
+		{"rule changed", [2]string{"COUNT=4", "COUNT=5"}, true},
+		{"occurrence excluded", [2]string{"EXDATE:20250317T100000Z", "EXDATE:20250317T100000Z,20250324T100000Z"}, true},
+	}
+
+	oldCal, err := DecodeCalendar([]byte(stored))
+	if err != nil {
+		t.Fatalf("DecodeCalendar() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newCal, err := DecodeCalendar([]byte(strings.Replace(stored, tt.replace[0], tt.replace[1], 1)))
+			if err != nil {
+				t.Fatalf("DecodeCalendar() error = %v", err)
+			}
+			got := IsSignificantChange(oldCal.Children[0], oldCal, newCal.Children[0], newCal)
+			if got != tt.significant {
+				t.Errorf("IsSignificantChange() = %v, want %v", got, tt.significant)
+			}
+		})
+	}
+}
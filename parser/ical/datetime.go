@@ -0,0 +1,245 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+const (
+	dateLayout          = "20060102"
+	localDateTimeLayout = "20060102T150405"
+)
+
+// ParseDateTime parses a DATE or DATE-TIME property such as DTSTART or
+// RECURRENCE-ID into a time.Time. A TZID that is not a known IANA zone
+// (e.g. Outlook's "W. Europe Standard Time") is resolved through the
+// VTIMEZONE components of cal, which may be nil. Floating times are
+// interpreted as UTC.
+func ParseDateTime(prop *Prop, cal *Calendar) (time.Time, error) {
+	if prop == nil {
+		return time.Time{}, fmt.Errorf("missing date-time property")
+	}
+
+	tzid := prop.Params.Get("TZID")
+	if tzid != "" && !IsDateValue(prop) && !strings.HasSuffix(prop.Value, "Z") {
+		if _, err := time.LoadLocation(tzid); err != nil {
+			timezone := FindTimezone(cal, tzid)
+			if timezone == nil {
+				return time.Time{}, fmt.Errorf("parsing %s: unknown TZID %q", prop.Name, tzid)
+			}
+
+			wall, err := time.Parse(localDateTimeLayout, prop.Value)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("parsing %s: %w", prop.Name, err)
+			}
+			t, err := wallClockInstant(timezone, wall)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("resolving TZID %q: %w", tzid, err)
+			}
+			return t, nil
+		}
+	}
+
+	if IsDateValue(prop) {
+		t, err := time.Parse(dateLayout, prop.Value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parsing %s: %w", prop.Name, err)
+		}
+		return t, nil
+	}
+
+	t, err := prop.DateTime(time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing %s: %w", prop.Name, err)
+	}
+	return t, nil
+}
+
+// IsDateValue reports whether a date-time property holds a DATE value
+// (all-day) instead of a DATE-TIME
+func IsDateValue(prop *Prop) bool {
+	if prop == nil {
+		return false
+	}
+	if prop.Params.Get("VALUE") == "DATE" {
+		return true
+	}
+	return len(prop.Value) == len(dateLayout)
+}
+
+// SameRecurrenceID reports whether two RECURRENCE-ID properties refer to
+// the same occurrence. Values are compared as instants, so
+// "20251003T100000Z" and "TZID=Europe/Berlin:20251003T120000" match. When
+// one of them is a DATE value only the calendar dates are compared. The
+// calendars are used to resolve TZIDs and may be nil.
+func SameRecurrenceID(id1 *Prop, cal1 *Calendar, id2 *Prop, cal2 *Calendar) bool {
+	if id1 == nil || id2 == nil {
+		return id1 == id2
+	}
+	if id1.Value == id2.Value && id1.Params.Get("TZID") == id2.Params.Get("TZID") {
+		return true
+	}
+
+	t1, err1 := ParseDateTime(id1, cal1)
+	t2, err2 := ParseDateTime(id2, cal2)
+	if err1 != nil || err2 != nil {
+		// Without a way to interpret them, only identical values match
+		return id1.Value == id2.Value
+	}
+
+	if IsDateValue(id1) || IsDateValue(id2) {
+		return t1.Format(dateLayout) == t2.Format(dateLayout)
+	}
+
+	return t1.Equal(t2)
+}
+
+// FindTimezone returns the VTIMEZONE component of a calendar with the given
+// TZID, or nil if there is none
+func FindTimezone(cal *Calendar, tzid string) *Component {
+	if cal == nil {
+		return nil
+	}
+	for _, component := range cal.Children {
+		if component.Name != "VTIMEZONE" {
+			continue
+		}
+		if prop := component.Props.Get("TZID"); prop != nil && prop.Value == tzid {
+			return component
+		}
+	}
+	return nil
+}
+
+// definedTimezone returns the VTIMEZONE component that resolves the TZID of
+// a date-time property which is no IANA zone name, or nil if the property
+// doesn't depend on one
+func definedTimezone(prop *Prop, cal *Calendar) *Component {
+	if prop == nil || IsDateValue(prop) || strings.HasSuffix(prop.Value, "Z") {
+		return nil
+	}
+	tzid := prop.Params.Get("TZID")
+	if tzid == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(tzid); err == nil {
+		return nil
+	}
+	return FindTimezone(cal, tzid)
+}
+
+// wallClockInstant returns the instant of a wall clock time, given as UTC,
+// in the zone a VTIMEZONE defines. The result carries the fixed offset in
+// effect at that time.
+func wallClockInstant(timezone *Component, wall time.Time) (time.Time, error) {
+	offset, err := timezoneOffset(timezone, wall)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return wall.Add(-time.Duration(offset) * time.Second).In(time.FixedZone(timezoneID(timezone), offset)), nil
+}
+
+// timezoneOffset returns the UTC offset in seconds that a VTIMEZONE defines
+// for a wall clock time, using the STANDARD or DAYLIGHT observance with the
+// latest onset before it
+func timezoneOffset(timezone *Component, wall time.Time) (int, error) {
+	var latestOnset time.Time
+	offset := 0
+	found := false
+
+	for _, observance := range timezone.Children {
+		if observance.Name != "STANDARD" && observance.Name != "DAYLIGHT" {
+			continue
+		}
+
+		dtstartProp := observance.Props.Get("DTSTART")
+		offsetProp := observance.Props.Get("TZOFFSETTO")
+		if dtstartProp == nil || offsetProp == nil {
+			continue
+		}
+
+		// Onsets are given in local time, comparing them as UTC wall
+		// clock times is exact enough to pick the observance
+		onset, err := time.Parse(localDateTimeLayout, dtstartProp.Value)
+		if err != nil {
+			continue
+		}
+		if onset.After(wall) {
+			continue
+		}
+
+		if rruleProp := observance.Props.Get("RRULE"); rruleProp != nil {
+			roption, err := rrule.StrToROption(rruleProp.Value)
+			if err != nil {
+				continue
+			}
+			// rrule-go gives up on long expansions, and VTIMEZONEs from
+			// Outlook start their rules in 1601, so start close to wall
+			if onset.Year() < wall.Year()-1 {
+				onset = time.Date(wall.Year()-1, onset.Month(), onset.Day(),
+					onset.Hour(), onset.Minute(), onset.Second(), 0, time.UTC)
+			}
+			roption.Dtstart = onset
+			rule, err := rrule.NewRRule(*roption)
+			if err != nil {
+				continue
+			}
+			if last := rule.Before(wall, true); !last.IsZero() {
+				onset = last
+			}
+		}
+
+		if !found || onset.After(latestOnset) {
+			observanceOffset, err := parseUTCOffset(offsetProp.Value)
+			if err != nil {
+				return 0, err
+			}
+			latestOnset = onset
+			offset = observanceOffset
+			found = true
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("no observance applies to %s", wall.Format(localDateTimeLayout))
+	}
+	return offset, nil
+}
+
+// parseUTCOffset parses a UTC-OFFSET value like "+0100" or "-053000" into
+// seconds
+func parseUTCOffset(value string) (int, error) {
+	if len(value) != 5 && len(value) != 7 {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	sign := 1
+	switch value[0] {
+	case '+':
+	case '-':
+		sign = -1
+	default:
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	seconds := 0
+	if len(value) == 7 {
+		if seconds, err = strconv.Atoi(value[5:7]); err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %q", value)
+		}
+	}
+
+	return sign * (hours*3600 + minutes*60 + seconds), nil
+}
@@ -0,0 +1,110 @@
+package ical
+
+import (
+	"testing"
+	"time"
+)
+
+const outlookTimezoneCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Microsoft Corporation//Outlook 16.0 MIMEDIR//EN
+BEGIN:VTIMEZONE
+TZID:W. Europe Standard Time
+BEGIN:STANDARD
+DTSTART:16011028T030000
+RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:16010325T020000
+RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:outlook-event
+DTSTAMP:20250901T000000Z
+DTSTART;TZID=W. Europe Standard Time:20251003T120000
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParseDateTime(t *testing.T) {
+	cal, err := DecodeCalendar([]byte(outlookTimezoneCalendar))
+	if err != nil {
+		t.Fatalf("Failed to decode calendar: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		tzid  string
+		want  time.Time
+	}{
+		{"UTC", "20251003T100000Z", "", time.Date(2025, 10, 3, 10, 0, 0, 0, time.UTC)},
+		{"IANA TZID in summer", "20251003T120000", "Europe/Berlin", time.Date(2025, 10, 3, 10, 0, 0, 0, time.UTC)},
+		{"VTIMEZONE in summer", "20251003T120000", "W. Europe Standard Time", time.Date(2025, 10, 3, 10, 0, 0, 0, time.UTC)},
+		{"VTIMEZONE in winter", "20251205T120000", "W. Europe Standard Time", time.Date(2025, 12, 5, 11, 0, 0, 0, time.UTC)},
+		{"DATE", "20251003", "", time.Date(2025, 10, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prop := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{}, Value: tt.value}
+			if tt.tzid != "" {
+				prop.Params.Set("TZID", tt.tzid)
+			}
+
+			got, err := ParseDateTime(prop, cal)
+			if err != nil {
+				t.Fatalf("ParseDateTime() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseDateTime() = %v, want %v", got.UTC(), tt.want)
+			}
+		})
+	}
+
+	// Unknown zones without a VTIMEZONE can't be resolved
+	prop := &Prop{Name: "DTSTART", Params: map[string][]string{"TZID": {"Nowhere Standard Time"}}, Value: "20251003T120000"}
+	if _, err := ParseDateTime(prop, cal); err == nil {
+		t.Errorf("Expected error for unknown TZID without VTIMEZONE")
+	}
+}
+
+func TestSameRecurrenceID(t *testing.T) {
+	cal, err := DecodeCalendar([]byte(outlookTimezoneCalendar))
+	if err != nil {
+		t.Fatalf("Failed to decode calendar: %v", err)
+	}
+
+	utc := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{}, Value: "20251003T100000Z"}
+	berlin := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{"TZID": {"Europe/Berlin"}}, Value: "20251003T120000"}
+	outlook := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{"TZID": {"W. Europe Standard Time"}}, Value: "20251003T120000"}
+	date := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{"VALUE": {"DATE"}}, Value: "20251003"}
+	otherDay := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{}, Value: "20251010T100000Z"}
+
+	tests := []struct {
+		name string
+		id1  *Prop
+		id2  *Prop
+		want bool
+	}{
+		{"identical", utc, utc, true},
+		{"UTC and IANA TZID", utc, berlin, true},
+		{"IANA TZID and VTIMEZONE", berlin, outlook, true},
+		{"DATE and DATE-TIME", date, berlin, true},
+		{"different occurrence", utc, otherDay, false},
+		{"DATE and other day", date, otherDay, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameRecurrenceID(tt.id1, cal, tt.id2, cal); got != tt.want {
+				t.Errorf("SameRecurrenceID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,42 @@ package ical
 
 import (
 	"bytes"
+	"strings"
 	"time"
 
 	goical "github.com/emersion/go-ical"
 )
 
-// Event represents calendar event information
+// Calendar object component types that are handled like events
+const (
+	ComponentEvent   = "VEVENT"
+	ComponentTodo    = "VTODO"
+	ComponentJournal = "VJOURNAL"
+)
+
+// IsObjectComponent reports whether a component is a calendar object
+// (VEVENT, VTODO or VJOURNAL) as opposed to e.g. a VTIMEZONE or VALARM
+func IsObjectComponent(name string) bool {
+	return name == ComponentEvent || name == ComponentTodo || name == ComponentJournal
+}
+
+// CalendarAddress normalizes a CAL-ADDRESS value like
+// "mailto:User@Example.com" to a lower case email address for comparison
+func CalendarAddress(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= len("mailto:") && strings.EqualFold(value[:len("mailto:")], "mailto:") {
+		value = value[len("mailto:"):]
+	}
+	return strings.ToLower(value)
+}
+
+// Event represents calendar object information. Despite the name it covers
+// all calendar objects: events (VEVENT), tasks (VTODO) and journal entries
+// (VJOURNAL), which share UID, SEQUENCE and iTIP handling.
 type Event struct {
 	UID         string
 	RawData     []byte // Raw iCalendar data
+	Component   string // Calendar object type (VEVENT, VTODO or VJOURNAL)
 	Summary     string
 	Start       time.Time
 	End         time.Time
@@ -19,6 +46,16 @@ type Event struct {
 	Description string
 	Method      string // Calendar method (REQUEST, REPLY, CANCEL, etc.)
 	Sequence    int    // Sequence number for event updates
+
+	// Task (VTODO) fields
+	Due             time.Time
+	Status          string
+	PercentComplete int
+}
+
+// IsTodo checks if the calendar object is a task
+func (e *Event) IsTodo() bool {
+	return e.Component == ComponentTodo
 }
 
 // IsRecurringUpdate checks if an event is a recurring event update
@@ -29,9 +66,9 @@ func (e *Event) IsRecurringUpdate() bool {
 		return false
 	}
 
-	// Check for RECURRENCE-ID in VEVENT components
+	// Check for RECURRENCE-ID in calendar object components
 	for _, component := range cal.Children {
-		if component.Name != "VEVENT" {
+		if !IsObjectComponent(component.Name) {
 			continue
 		}
 
@@ -42,3 +79,26 @@ func (e *Event) IsRecurringUpdate() bool {
 
 	return false
 }
+
+// IsThisAndFutureUpdate checks if an event is a recurring event update whose
+// RECURRENCE-ID carries RANGE=THISANDFUTURE, i.e. it applies to the given
+// occurrence and all following ones
+func (e *Event) IsThisAndFutureUpdate() bool {
+	cal, err := goical.NewDecoder(bytes.NewReader(e.RawData)).Decode()
+	if err != nil {
+		return false
+	}
+
+	for _, component := range cal.Children {
+		if !IsObjectComponent(component.Name) {
+			continue
+		}
+
+		recurrenceID := component.Props.Get("RECURRENCE-ID")
+		if recurrenceID != nil && recurrenceID.Params.Get("RANGE") == "THISANDFUTURE" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -70,7 +70,8 @@ func ParseCalendarReader(r io.Reader, encoding string) (*Event, error) {
 	return event, nil
 }
 
-// ParseICalData parses iCalendar data and extracts basic event information
+// ParseICalData parses iCalendar data and extracts basic information of the
+// first calendar object (VEVENT, VTODO or VJOURNAL)
 func ParseICalData(icsData []byte) (*Event, error) {
 	// IMPORTANT: The go-ical library can panic on malformed data.
 	// This defer-recover pattern is essential to prevent application crashes
@@ -105,11 +106,12 @@ func ParseICalData(icsData []byte) (*Event, error) {
 		event.Method = methodProp.Value
 	}
 
-	// Find the first VEVENT component
+	// Find the first calendar object component (VEVENT, VTODO or VJOURNAL)
 	for _, component := range cal.Children {
-		if component.Name != "VEVENT" {
+		if !IsObjectComponent(component.Name) {
 			continue
 		}
+		event.Component = component.Name
 
 		// Extract UID - required by iCalendar standard
 		uidProp := component.Props.Get("UID")
@@ -121,7 +123,7 @@ func ParseICalData(icsData []byte) (*Event, error) {
 			}
 		} else {
 			// No UID found - this violates the iCalendar standard
-			return nil, fmt.Errorf("VEVENT missing required UID property")
+			return nil, fmt.Errorf("%s missing required UID property", component.Name)
 		}
 
 		// Extract Summary (optional)
@@ -142,11 +144,47 @@ func ParseICalData(icsData []byte) (*Event, error) {
 			}
 		}
 
+		// Extract the time span (optional for tasks and journal entries)
+		if component.Props.Get("DTSTART") != nil {
+			if occurrence, err := singleOccurrence(component, cal); err == nil {
+				event.Start = occurrence.Start
+				event.End = occurrence.End
+			}
+		}
+
+		// Extract LOCATION and ORGANIZER (optional)
+		if locationProp := component.Props.Get("LOCATION"); locationProp != nil {
+			event.Location = locationProp.Value
+		}
+		if organizerProp := component.Props.Get("ORGANIZER"); organizerProp != nil {
+			event.Organizer = organizerProp.Value
+		}
+
+		// Extract STATUS (optional)
+		if statusProp := component.Props.Get("STATUS"); statusProp != nil {
+			event.Status = statusProp.Value
+		}
+
+		// Extract the task fields DUE and PERCENT-COMPLETE (optional)
+		if component.Name == ComponentTodo {
+			if dueProp := component.Props.Get("DUE"); dueProp != nil {
+				if due, err := ParseDateTime(dueProp, cal); err == nil {
+					event.Due = due
+				}
+			}
+			if percentProp := component.Props.Get("PERCENT-COMPLETE"); percentProp != nil {
+				var percent int
+				if _, err := fmt.Sscanf(percentProp.Value, "%d", &percent); err == nil {
+					event.PercentComplete = percent
+				}
+			}
+		}
+
 		return event, nil
 	}
 
-	// If no VEVENT found, return an error
-	return nil, fmt.Errorf("no VEVENT component found in iCalendar data")
+	// If no calendar object found, return an error
+	return nil, fmt.Errorf("no VEVENT, VTODO or VJOURNAL component found in iCalendar data")
 }
 
 // DecodeCalendar parses iCalendar data into a Calendar object
@@ -45,6 +45,52 @@ END:VCALENDAR
 	}
 }
 
+func TestParseICalData_Todo(t *testing.T) {
+	icsData := []byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//hacksw/handcal//NONSGML v1.0//EN
+METHOD:REQUEST
+BEGIN:VTODO
+UID:test-todo-123
+SUMMARY:Test Task
+SEQUENCE:2
+DUE;VALUE=DATE:20250315
+STATUS:IN-PROCESS
+PERCENT-COMPLETE:40
+END:VTODO
+END:VCALENDAR
+`)
+
+	event, err := ParseICalData(icsData)
+	if err != nil {
+		t.Fatalf("Failed to parse iCalendar data: %v", err)
+	}
+
+	if event.Component != ComponentTodo || !event.IsTodo() {
+		t.Errorf("Expected component 'VTODO', got '%s'", event.Component)
+	}
+
+	if event.UID != "test-todo-123" {
+		t.Errorf("Expected UID 'test-todo-123', got '%s'", event.UID)
+	}
+
+	if event.Sequence != 2 {
+		t.Errorf("Expected sequence 2, got %d", event.Sequence)
+	}
+
+	if event.Due.Format("20060102") != "20250315" {
+		t.Errorf("Expected due date 20250315, got %v", event.Due)
+	}
+
+	if event.Status != "IN-PROCESS" {
+		t.Errorf("Expected status 'IN-PROCESS', got '%s'", event.Status)
+	}
+
+	if event.PercentComplete != 40 {
+		t.Errorf("Expected percent complete 40, got %d", event.PercentComplete)
+	}
+}
+
 func TestParseCalendarData(t *testing.T) {
 	// Create a test MIME part with calendar data
 	icsData := []byte(`BEGIN:VCALENDAR
@@ -0,0 +1,164 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+)
+
+// Occurrence is a single instance of a calendar object in time
+type Occurrence struct {
+	// Component is the master or the exception the occurrence comes from
+	Component *Component
+	Start     time.Time
+	End       time.Time
+	// AllDay is set for occurrences with DATE values
+	AllDay bool
+}
+
+// Overlaps reports whether the occurrence overlaps the span from start to
+// end. Occurrences without duration overlap spans that contain their start.
+func (o Occurrence) Overlaps(start, end time.Time) bool {
+	if o.End.After(o.Start) {
+		return o.Start.Before(end) && o.End.After(start)
+	}
+	return !o.Start.Before(start) && o.Start.Before(end)
+}
+
+// Occurrences expands the calendar objects of a calendar into the
+// occurrences overlapping the span from start to end, sorted by start. The
+// RRULE and RDATE occurrences of the master are reduced by EXDATE and
+// replaced by the exceptions that override them.
+func Occurrences(cal *Calendar, start, end time.Time) ([]Occurrence, error) {
+	var master *Component
+	var exceptions []*Component
+	for _, component := range cal.Children {
+		if !IsObjectComponent(component.Name) || component.Props.Get("DTSTART") == nil {
+			continue
+		}
+		if component.Props.Get("RECURRENCE-ID") != nil {
+			exceptions = append(exceptions, component)
+		} else if master == nil {
+			master = component
+		}
+	}
+
+	var occurrences []Occurrence
+	for _, exception := range exceptions {
+		occurrence, err := singleOccurrence(exception, cal)
+		if err != nil {
+			return nil, err
+		}
+		if occurrence.Overlaps(start, end) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	if master != nil {
+		masterOccurrences, err := expandMaster(master, cal, exceptions, start, end)
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, masterOccurrences...)
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+	return occurrences, nil
+}
+
+// expandMaster returns the occurrences of a master component that overlap
+// the span and are not overridden by an exception
+func expandMaster(master *Component, cal *Calendar, exceptions []*Component, start, end time.Time) ([]Occurrence, error) {
+	first, err := singleOccurrence(master, cal)
+	if err != nil {
+		return nil, err
+	}
+	duration := first.End.Sub(first.Start)
+
+	starts := []time.Time{first.Start}
+	rule, err := recurrenceRule(master, cal)
+	if err != nil {
+		return nil, fmt.Errorf("parsing RRULE: %w", err)
+	}
+	if rule != nil {
+		// Occurrences starting before the span may still reach into it
+		starts = rule.Between(start.Add(-duration), end, true)
+	}
+	starts = append(starts, dateTimeValues(master, "RDATE", cal)...)
+
+	excluded := make(map[int64]bool)
+	for _, t := range dateTimeValues(master, "EXDATE", cal) {
+		excluded[t.Unix()] = true
+	}
+
+	var occurrences []Occurrence
+	seen := make(map[int64]bool)
+	for _, t := range starts {
+		if excluded[t.Unix()] || seen[t.Unix()] || overridden(master, cal, exceptions, t) {
+			continue
+		}
+		seen[t.Unix()] = true
+
+		occurrence := Occurrence{Component: master, Start: t, End: t.Add(duration), AllDay: first.AllDay}
+		if occurrence.Overlaps(start, end) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences, nil
+}
+
+// overridden reports whether an exception replaces the occurrence of the
+// master starting at t
+func overridden(master *Component, cal *Calendar, exceptions []*Component, t time.Time) bool {
+	id := &Prop{Name: "RECURRENCE-ID", Params: goical.Params{}, Value: t.UTC().Format("20060102T150405Z")}
+	if IsDateValue(master.Props.Get("DTSTART")) {
+		id.Params.Set("VALUE", "DATE")
+		id.Value = t.Format(dateLayout)
+	}
+
+	for _, exception := range exceptions {
+		if SameRecurrenceID(exception.Props.Get("RECURRENCE-ID"), cal, id, cal) {
+			return true
+		}
+	}
+	return false
+}
+
+// singleOccurrence returns the time span of a component by itself, taking
+// the end from DTEND, DUE or DURATION. DATE values without an end last one
+// day as defined by RFC 5545.
+func singleOccurrence(component *Component, cal *Calendar) (Occurrence, error) {
+	dtstart := component.Props.Get("DTSTART")
+	start, err := ParseDateTime(dtstart, cal)
+	if err != nil {
+		return Occurrence{}, fmt.Errorf("parsing DTSTART: %w", err)
+	}
+	occurrence := Occurrence{Component: component, Start: start, End: start, AllDay: IsDateValue(dtstart)}
+
+	endProp := component.Props.Get("DTEND")
+	if endProp == nil {
+		endProp = component.Props.Get("DUE")
+	}
+	switch {
+	case endProp != nil:
+		end, err := ParseDateTime(endProp, cal)
+		if err != nil {
+			return Occurrence{}, fmt.Errorf("parsing %s: %w", endProp.Name, err)
+		}
+		occurrence.End = end
+	case component.Props.Get("DURATION") != nil:
+		duration, err := component.Props.Get("DURATION").Duration()
+		if err != nil {
+			return Occurrence{}, fmt.Errorf("parsing DURATION: %w", err)
+		}
+		occurrence.End = start.Add(duration)
+	case occurrence.AllDay:
+		occurrence.End = start.AddDate(0, 0, 1)
+	}
+
+	return occurrence, nil
+}
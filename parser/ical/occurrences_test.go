@@ -0,0 +1,93 @@
+package ical
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOccurrences(t *testing.T) {
+	cal, err := DecodeCalendar([]byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:occurrences-test
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Berlin:20250303T100000
+DURATION:PT1H
+RRULE:FREQ=WEEKLY;COUNT=5
+EXDATE;TZID=Europe/Berlin:20250310T100000
+RDATE:20250305T150000Z
+SUMMARY:Weekly
+END:VEVENT
+BEGIN:VEVENT
+UID:occurrences-test
+DTSTAMP:20250101T000000Z
+RECURRENCE-ID;TZID=Europe/Berlin:20250317T100000
+DTSTART;TZID=Europe/Berlin:20250318T140000
+DTEND;TZID=Europe/Berlin:20250318T150000
+SUMMARY:Moved
+END:VEVENT
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatalf("DecodeCalendar() error = %v", err)
+	}
+
+	occurrences, err := Occurrences(cal,
+		time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 25, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Occurrences() error = %v", err)
+	}
+
+	// March 31st is outside the span, March 10th excluded and March 17th
+	// moved to the 18th; Berlin is UTC+1 in March until the 30th
+	want := []string{
+		"2025-03-03T09:00:00Z",
+		"2025-03-05T15:00:00Z",
+		"2025-03-18T13:00:00Z",
+		"2025-03-24T09:00:00Z",
+	}
+	if len(occurrences) != len(want) {
+		t.Fatalf("Occurrences() returned %d occurrences, want %d: %v", len(occurrences), len(want), occurrences)
+	}
+	for i, occurrence := range occurrences {
+		if got := occurrence.Start.UTC().Format(time.RFC3339); got != want[i] {
+			t.Errorf("occurrence %d starts %s, want %s", i, got, want[i])
+		}
+		if occurrence.End.Sub(occurrence.Start) != time.Hour {
+			t.Errorf("occurrence %d lasts %v, want 1h", i, occurrence.End.Sub(occurrence.Start))
+		}
+	}
+	if summary := occurrences[2].Component.Props.Get("SUMMARY"); summary == nil || summary.Value != "Moved" {
+		t.Errorf("expected the third occurrence to come from the exception, got %v", summary)
+	}
+}
+
+func TestOccurrences_AllDay(t *testing.T) {
+	cal, err := DecodeCalendar([]byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:all-day-test
+DTSTAMP:20250101T000000Z
+DTSTART;VALUE=DATE:20250310
+SUMMARY:Holiday
+END:VEVENT
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatalf("DecodeCalendar() error = %v", err)
+	}
+
+	occurrences, err := Occurrences(cal,
+		time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Occurrences() error = %v", err)
+	}
+	if len(occurrences) != 1 || !occurrences[0].AllDay {
+		t.Fatalf("expected one all-day occurrence, got %v", occurrences)
+	}
+	if occurrences[0].End.Sub(occurrences[0].Start) != 24*time.Hour {
+		t.Errorf("expected the occurrence to last one day, got %v", occurrences[0].End.Sub(occurrences[0].Start))
+	}
+}
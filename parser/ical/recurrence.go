@@ -0,0 +1,285 @@
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// recurrence expands the RRULE of a component. rrule-go generates the
+// occurrences in the location of DTSTART, which is only a fixed offset for
+// TZIDs that are defined by a VTIMEZONE alone. Such rules are expanded in
+// wall clock time instead, and every occurrence gets the offset that the
+// VTIMEZONE defines for it, so it keeps its local time across DST changes.
+type recurrence struct {
+	rule *rrule.RRule
+	// timezone is set when the rule is expanded in wall clock time
+	timezone *Component
+	// offset is the UTC offset of DTSTART, used for wall clock times that
+	// no observance of the timezone covers
+	offset int
+}
+
+// maxUTCOffset is larger than the difference between any wall clock time
+// and UTC
+const maxUTCOffset = 15 * time.Hour
+
+// recurrenceRule builds the RRULE of a component anchored at its DTSTART.
+// It returns nil if the component does not recur.
+func recurrenceRule(component *Component, cal *Calendar) (*recurrence, error) {
+	roption, err := component.Props.RecurrenceRule()
+	if err != nil {
+		return nil, err
+	}
+	if roption == nil {
+		return nil, nil
+	}
+
+	dtstartProp := component.Props.Get("DTSTART")
+	dtstart, err := ParseDateTime(dtstartProp, cal)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &recurrence{timezone: definedTimezone(dtstartProp, cal)}
+	if r.timezone != nil {
+		_, r.offset = dtstart.Zone()
+		dtstart = r.wallClock(dtstart)
+		if !roption.Until.IsZero() {
+			roption.Until = r.wallClock(roption.Until)
+		}
+	}
+	roption.Dtstart = dtstart
+
+	r.rule, err = rrule.NewRRule(*roption)
+	if err != nil {
+		return nil, fmt.Errorf("building RRULE: %w", err)
+	}
+	return r, nil
+}
+
+// wallClock returns the local time of an instant in the timezone of the
+// rule as UTC
+func (r *recurrence) wallClock(t time.Time) time.Time {
+	wall := t.UTC().Add(time.Duration(r.offset) * time.Second)
+	if offset, err := timezoneOffset(r.timezone, wall); err == nil {
+		wall = t.UTC().Add(time.Duration(offset) * time.Second)
+	}
+	return wall
+}
+
+// instant returns the occurrence for a wall clock time generated by the
+// rule
+func (r *recurrence) instant(wall time.Time) time.Time {
+	if r.timezone == nil {
+		return wall
+	}
+	t, err := wallClockInstant(r.timezone, wall)
+	if err != nil {
+		return wall.Add(-time.Duration(r.offset) * time.Second).In(time.FixedZone(timezoneID(r.timezone), r.offset))
+	}
+	return t
+}
+
+// Iterator returns the occurrences of the rule one by one
+func (r *recurrence) Iterator() rrule.Next {
+	next := r.rule.Iterator()
+	return func() (time.Time, bool) {
+		wall, ok := next()
+		if !ok {
+			return time.Time{}, false
+		}
+		return r.instant(wall), true
+	}
+}
+
+// Between returns the occurrences of the rule from after to before,
+// including both when inc is set
+func (r *recurrence) Between(after, before time.Time, inc bool) []time.Time {
+	if r.timezone == nil {
+		return r.rule.Between(after, before, inc)
+	}
+
+	var occurrences []time.Time
+	for _, wall := range r.rule.Between(after.UTC().Add(-maxUTCOffset), before.UTC().Add(maxUTCOffset), true) {
+		t := r.instant(wall)
+		if t.Before(after) || t.After(before) {
+			continue
+		}
+		if !inc && (t.Equal(after) || t.Equal(before)) {
+			continue
+		}
+		occurrences = append(occurrences, t)
+	}
+	return occurrences
+}
+
+// OccurrencesBefore counts the occurrences generated by the RRULE of a
+// component that start strictly before t. The calendar is used to resolve
+// the TZID of DTSTART and may be nil.
+func OccurrencesBefore(component *Component, cal *Calendar, t time.Time) (int, error) {
+	rule, err := recurrenceRule(component, cal)
+	if err != nil {
+		return 0, err
+	}
+	if rule == nil {
+		return 0, fmt.Errorf("component has no RRULE")
+	}
+
+	count := 0
+	next := rule.Iterator()
+	for {
+		occurrence, ok := next()
+		if !ok || !occurrence.Before(t) {
+			break
+		}
+		count++
+	}
+	return count, nil
+}
+
+// TruncateRecurrence ends the RRULE of a component so that no occurrence
+// starts at or after t. COUNT is replaced by an UNTIL in the value type
+// of DTSTART.
+func TruncateRecurrence(component *Component, t time.Time) error {
+	rruleProp := component.Props.Get("RRULE")
+	if rruleProp == nil {
+		return fmt.Errorf("component has no RRULE")
+	}
+
+	var until string
+	if IsDateValue(component.Props.Get("DTSTART")) {
+		until = t.AddDate(0, 0, -1).Format("20060102")
+	} else {
+		until = t.Add(-time.Second).UTC().Format("20060102T150405Z")
+	}
+
+	rruleProp.Value = replaceRuleParts(rruleProp.Value, map[string]string{"UNTIL": until}, "COUNT")
+	return nil
+}
+
+// SetRecurrenceCount replaces any COUNT or UNTIL of the RRULE of a
+// component with the given COUNT
+func SetRecurrenceCount(component *Component, count int) error {
+	rruleProp := component.Props.Get("RRULE")
+	if rruleProp == nil {
+		return fmt.Errorf("component has no RRULE")
+	}
+
+	rruleProp.Value = replaceRuleParts(rruleProp.Value, map[string]string{"COUNT": fmt.Sprint(count)}, "UNTIL")
+	return nil
+}
+
+// replaceRuleParts rewrites an RRULE value, dropping the parts named in
+// drop and setting the parts in set, while keeping the order of the rest
+func replaceRuleParts(value string, set map[string]string, drop ...string) string {
+	var parts []string
+	for _, part := range strings.Split(value, ";") {
+		name, _, _ := strings.Cut(part, "=")
+		name = strings.ToUpper(name)
+
+		skip := false
+		for _, d := range drop {
+			if name == d {
+				skip = true
+			}
+		}
+		if _, ok := set[name]; ok {
+			skip = true
+		}
+		if !skip && part != "" {
+			parts = append(parts, part)
+		}
+	}
+	for name, v := range set {
+		parts = append(parts, name+"="+v)
+	}
+	return strings.Join(parts, ";")
+}
+
+// IsOccurrence reports whether a RECURRENCE-ID refers to an occurrence of a
+// master component, taking DTSTART, RRULE, RDATE and EXDATE into account.
+// masterCal and idCal resolve the TZIDs of the master and the RECURRENCE-ID
+// and may be nil.
+func IsOccurrence(master *Component, masterCal *Calendar, recurrenceID *Prop, idCal *Calendar) (bool, error) {
+	target, err := ParseDateTime(recurrenceID, idCal)
+	if err != nil {
+		return false, err
+	}
+	dateOnly := IsDateValue(recurrenceID) || IsDateValue(master.Props.Get("DTSTART"))
+
+	matches := func(occurrence time.Time) bool {
+		if dateOnly {
+			return occurrence.Format(dateLayout) == occurrenceDate(recurrenceID, target)
+		}
+		return occurrence.Equal(target)
+	}
+
+	for _, exdate := range dateTimeValues(master, "EXDATE", masterCal) {
+		if matches(exdate) {
+			return false, nil
+		}
+	}
+
+	dtstart, err := ParseDateTime(master.Props.Get("DTSTART"), masterCal)
+	if err != nil {
+		return false, err
+	}
+	if matches(dtstart) {
+		return true, nil
+	}
+
+	for _, rdate := range dateTimeValues(master, "RDATE", masterCal) {
+		if matches(rdate) {
+			return true, nil
+		}
+	}
+
+	rule, err := recurrenceRule(master, masterCal)
+	if err != nil {
+		return false, err
+	}
+	if rule == nil {
+		return false, nil
+	}
+
+	// Look around the target, wide enough to cover any UTC offset when
+	// only dates are compared
+	window := time.Second
+	if dateOnly {
+		window = 48 * time.Hour
+	}
+	for _, occurrence := range rule.Between(target.Add(-window), target.Add(window), true) {
+		if matches(occurrence) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// occurrenceDate returns the calendar date a RECURRENCE-ID refers to, in
+// the zone it was given in
+func occurrenceDate(recurrenceID *Prop, t time.Time) string {
+	if IsDateValue(recurrenceID) || len(recurrenceID.Value) < len(dateLayout) {
+		return t.Format(dateLayout)
+	}
+	return recurrenceID.Value[:len(dateLayout)]
+}
+
+// dateTimeValues parses all values of a multi-valued date-time property
+// like EXDATE or RDATE, skipping values that can't be parsed
+func dateTimeValues(component *Component, name string, cal *Calendar) []time.Time {
+	var times []time.Time
+	for _, prop := range component.Props.Values(name) {
+		for _, value := range strings.Split(prop.Value, ",") {
+			single := prop
+			single.Value = value
+			if t, err := ParseDateTime(&single, cal); err == nil {
+				times = append(times, t)
+			}
+		}
+	}
+	return times
+}
@@ -0,0 +1,115 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsOccurrence(t *testing.T) {
+	cal, err := DecodeCalendar([]byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:occurrence-event
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Berlin:20250106T100000
+RRULE:FREQ=WEEKLY;COUNT=4
+EXDATE;TZID=Europe/Berlin:20250120T100000
+RDATE:20250301T090000Z
+END:VEVENT
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatalf("Failed to decode calendar: %v", err)
+	}
+	master := cal.Children[0]
+
+	tests := []struct {
+		name  string
+		value string
+		tzid  string
+		want  bool
+	}{
+		{"first occurrence", "20250106T100000", "Europe/Berlin", true},
+		{"occurrence in UTC", "20250113T090000Z", "", true},
+		{"excluded occurrence", "20250120T100000", "Europe/Berlin", false},
+		{"last occurrence", "20250127T100000", "Europe/Berlin", true},
+		{"after COUNT", "20250203T100000", "Europe/Berlin", false},
+		{"wrong time", "20250113T110000", "Europe/Berlin", false},
+		{"RDATE", "20250301T090000Z", "", true},
+		{"DATE of occurrence", "20250113", "", true},
+		{"DATE of other day", "20250114", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prop := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{}, Value: tt.value}
+			if tt.tzid != "" {
+				prop.Params.Set("TZID", tt.tzid)
+			}
+
+			got, err := IsOccurrence(master, cal, prop, cal)
+			if err != nil {
+				t.Fatalf("IsOccurrence() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsOccurrence() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOccurrence_AcrossDST(t *testing.T) {
+	// Outlook TZIDs are only defined by the VTIMEZONE of the calendar
+	data := strings.Replace(outlookTimezoneCalendar,
+		"DTSTART;TZID=W. Europe Standard Time:20251003T120000",
+		"DTSTART;TZID=W. Europe Standard Time:20250303T100000\nRRULE:FREQ=WEEKLY;UNTIL=20250505T080000Z", 1)
+	cal, err := DecodeCalendar([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to decode calendar: %v", err)
+	}
+	master := cal.Children[1]
+
+	tests := []struct {
+		name  string
+		value string
+		tzid  string
+		want  bool
+	}{
+		{"before DST", "20250310T100000", "W. Europe Standard Time", true},
+		{"after DST", "20250407T100000", "W. Europe Standard Time", true},
+		{"after DST in UTC", "20250407T080000Z", "", true},
+		{"after DST at the winter offset", "20250407T090000Z", "", false},
+		{"last occurrence", "20250505T100000", "W. Europe Standard Time", true},
+		{"after UNTIL", "20250512T100000", "W. Europe Standard Time", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prop := &Prop{Name: "RECURRENCE-ID", Params: map[string][]string{}, Value: tt.value}
+			if tt.tzid != "" {
+				prop.Params.Set("TZID", tt.tzid)
+			}
+
+			got, err := IsOccurrence(master, cal, prop, cal)
+			if err != nil {
+				t.Fatalf("IsOccurrence() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsOccurrence() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	occurrences, err := Occurrences(cal, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 4, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Occurrences() error = %v", err)
+	}
+	if len(occurrences) != 2 {
+		t.Fatalf("Expected 2 occurrences, got %d", len(occurrences))
+	}
+	if want := time.Date(2025, 4, 7, 8, 0, 0, 0, time.UTC); !occurrences[0].Start.Equal(want) {
+		t.Errorf("Expected occurrence at %v, got %v", want, occurrences[0].Start.UTC())
+	}
+}
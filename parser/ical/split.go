@@ -0,0 +1,94 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+)
+
+// DecodeCalendars parses a stream of one or more calendars, as found in
+// exported .ics files
+func DecodeCalendars(icsData []byte) ([]*Calendar, error) {
+	var cals []*Calendar
+	var err error
+
+	// Like DecodeCalendar this guards against panics of the decoder
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic in decoder: %v", r)
+			}
+		}()
+
+		dec := goical.NewDecoder(bytes.NewReader(icsData))
+		for {
+			cal, decodeErr := dec.Decode()
+			if errors.Is(decodeErr, io.EOF) {
+				return
+			}
+			if decodeErr != nil {
+				err = decodeErr
+				return
+			}
+			cals = append(cals, cal)
+		}
+	}()
+
+	if err != nil {
+		return nil, fmt.Errorf("decoding iCal data: %w", err)
+	}
+	return cals, nil
+}
+
+// SplitObjects splits a calendar into one calendar per UID, as they are
+// stored. Each keeps the calendar properties except METHOD and the time
+// zones its components reference.
+func SplitObjects(cal *Calendar) []*Calendar {
+	var uids []string
+	objects := make(map[string][]*Component)
+	for _, component := range cal.Children {
+		if !IsObjectComponent(component.Name) {
+			continue
+		}
+		uid := ""
+		if prop := component.Props.Get(goical.PropUID); prop != nil {
+			uid = prop.Value
+		}
+		if _, ok := objects[uid]; !ok {
+			uids = append(uids, uid)
+		}
+		objects[uid] = append(objects[uid], component)
+	}
+
+	split := make([]*Calendar, 0, len(uids))
+	for _, uid := range uids {
+		object := goical.NewCalendar()
+		for name, props := range cal.Props {
+			if name != goical.PropMethod {
+				object.Props[name] = props
+			}
+		}
+		object.Children = append(object.Children, objects[uid]...)
+
+		// Copy the time zones in use from the source, EnsureTimezones adds
+		// the ones it lacks
+		tzids := make(map[string]time.Time)
+		for _, component := range objects[uid] {
+			collectTZIDs(component, tzids)
+		}
+		var timezones []*Component
+		for _, component := range cal.Children {
+			if _, ok := tzids[timezoneID(component)]; ok && component.Name == goical.CompTimezone {
+				timezones = append(timezones, component)
+			}
+		}
+		insertTimezones(object, timezones)
+
+		split = append(split, object)
+	}
+	return split
+}
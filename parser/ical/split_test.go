@@ -0,0 +1,93 @@
+package ical
+
+import (
+	"testing"
+)
+
+func TestSplitObjects(t *testing.T) {
+	cals, err := DecodeCalendars([]byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+METHOD:PUBLISH
+BEGIN:VTIMEZONE
+TZID:Europe/Berlin
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VTIMEZONE
+TZID:America/New_York
+BEGIN:STANDARD
+DTSTART:19701101T020000
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:split-1
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Berlin:20250303T100000
+RRULE:FREQ=WEEKLY
+END:VEVENT
+BEGIN:VTODO
+UID:split-2
+DTSTAMP:20250101T000000Z
+END:VTODO
+BEGIN:VEVENT
+UID:split-1
+DTSTAMP:20250101T000000Z
+RECURRENCE-ID;TZID=Europe/Berlin:20250310T100000
+DTSTART;TZID=Europe/Berlin:20250310T110000
+END:VEVENT
+END:VCALENDAR
+BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:split-3
+DTSTAMP:20250101T000000Z
+DTSTART:20250303T100000Z
+END:VEVENT
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatalf("DecodeCalendars() error = %v", err)
+	}
+	if len(cals) != 2 {
+		t.Fatalf("Expected 2 calendars, got %d", len(cals))
+	}
+
+	objects := SplitObjects(cals[0])
+	if len(objects) != 2 {
+		t.Fatalf("Expected 2 objects, got %d", len(objects))
+	}
+
+	first := objects[0]
+	if first.Props.Get("METHOD") != nil {
+		t.Error("Expected METHOD to be removed")
+	}
+	var names []string
+	for _, component := range first.Children {
+		names = append(names, component.Name+":"+timezoneID(component))
+	}
+	want := []string{"VTIMEZONE:Europe/Berlin", "VEVENT:", "VEVENT:"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected components %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected components %v, got %v", want, names)
+			break
+		}
+	}
+
+	second := objects[1]
+	if len(second.Children) != 1 || second.Children[0].Name != "VTODO" {
+		t.Errorf("Expected only the VTODO in the second object, got %d components", len(second.Children))
+	}
+	if _, err := EncodeCalendar(second); err != nil {
+		t.Errorf("Failed to encode split object: %v", err)
+	}
+}
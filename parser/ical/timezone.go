@@ -0,0 +1,254 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+)
+
+// MergeTimezones adds the VTIMEZONE components of the source calendars to
+// dst, deduplicated by TZID. Definitions already in dst and in earlier
+// sources take precedence. The components are inserted before the first
+// calendar object, where clients expect them.
+func MergeTimezones(dst *Calendar, sources ...*Calendar) {
+	known := make(map[string]bool)
+	for _, component := range dst.Children {
+		if component.Name == "VTIMEZONE" {
+			known[timezoneID(component)] = true
+		}
+	}
+
+	var added []*Component
+	for _, src := range sources {
+		if src == nil {
+			continue
+		}
+		for _, component := range src.Children {
+			if component.Name != "VTIMEZONE" {
+				continue
+			}
+			tzid := timezoneID(component)
+			if tzid == "" || known[tzid] {
+				continue
+			}
+			known[tzid] = true
+			added = append(added, component)
+		}
+	}
+
+	insertTimezones(dst, added)
+}
+
+// EnsureTimezones adds a standard VTIMEZONE definition for every TZID that
+// is referenced in cal but not defined by it. TZIDs that are no IANA zone
+// names can't be generated and are left alone.
+func EnsureTimezones(cal *Calendar) {
+	defined := make(map[string]bool)
+	for _, component := range cal.Children {
+		if component.Name == "VTIMEZONE" {
+			defined[timezoneID(component)] = true
+		}
+	}
+
+	referenced := make(map[string]time.Time)
+	for _, component := range cal.Children {
+		if component.Name != "VTIMEZONE" {
+			collectTZIDs(component, referenced)
+		}
+	}
+
+	tzids := make([]string, 0, len(referenced))
+	for tzid := range referenced {
+		if !defined[tzid] {
+			tzids = append(tzids, tzid)
+		}
+	}
+	sort.Strings(tzids)
+
+	var added []*Component
+	for _, tzid := range tzids {
+		timezone, err := NewTimezone(tzid, referenced[tzid].Year())
+		if err != nil {
+			continue
+		}
+		added = append(added, timezone)
+	}
+
+	insertTimezones(cal, added)
+}
+
+// NewTimezone builds a VTIMEZONE component for an IANA time zone from the
+// Go time zone database, describing the rules in effect in the given year
+func NewTimezone(tzid string, year int) (*Component, error) {
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return nil, fmt.Errorf("loading time zone %q: %w", tzid, err)
+	}
+
+	timezone := goical.NewComponent("VTIMEZONE")
+	timezone.Props.Set(&goical.Prop{Name: "TZID", Params: goical.Params{}, Value: tzid})
+
+	transitions := zoneTransitions(loc, year)
+	if len(transitions) == 0 {
+		// No daylight saving time, a single observance is enough
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+		name, offset := start.Zone()
+		timezone.Children = append(timezone.Children,
+			newObservance("STANDARD", "19700101T000000", offset, offset, name, ""))
+		return timezone, nil
+	}
+
+	for _, transition := range transitions {
+		_, offsetFrom := transition.Add(-time.Second).Zone()
+		name, offsetTo := transition.Zone()
+
+		// Onsets are given in the local time before the transition
+		local := transition.Add(time.Duration(offsetFrom) * time.Second).UTC()
+
+		kind := "STANDARD"
+		if transition.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		timezone.Children = append(timezone.Children,
+			newObservance(kind, local.Format(localDateTimeLayout), offsetFrom, offsetTo, name, yearlyRule(local)))
+	}
+
+	return timezone, nil
+}
+
+// zoneTransitions returns the instants in a year at which the UTC offset of
+// a location changes
+func zoneTransitions(loc *time.Location, year int) []time.Time {
+	var transitions []time.Time
+
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	_, previous := start.In(loc).Zone()
+	for t := start.Add(time.Hour); t.Before(end); t = t.Add(time.Hour) {
+		_, offset := t.In(loc).Zone()
+		if offset == previous {
+			continue
+		}
+
+		// Narrow the change down to the second
+		low, high := t.Add(-time.Hour), t
+		for high.Sub(low) > time.Second {
+			mid := low.Add(high.Sub(low) / 2)
+			if _, midOffset := mid.In(loc).Zone(); midOffset == previous {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+
+		transitions = append(transitions, high.In(loc))
+		previous = offset
+	}
+
+	return transitions
+}
+
+// yearlyRule builds an RRULE that repeats a transition on the same weekday
+// of the month every year, e.g. the last Sunday of March
+func yearlyRule(local time.Time) string {
+	weekday := []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}[local.Weekday()]
+	daysInMonth := time.Date(local.Year(), local.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	nth := fmt.Sprint((local.Day()-1)/7 + 1)
+	if local.Day()+7 > daysInMonth {
+		nth = "-1"
+	}
+
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%s%s", int(local.Month()), nth, weekday)
+}
+
+// newObservance builds a STANDARD or DAYLIGHT sub-component of a VTIMEZONE
+func newObservance(kind, dtstart string, offsetFrom, offsetTo int, name, rrule string) *Component {
+	observance := goical.NewComponent(kind)
+	observance.Props.Set(&goical.Prop{Name: "DTSTART", Params: goical.Params{}, Value: dtstart})
+	observance.Props.Set(&goical.Prop{Name: "TZOFFSETFROM", Params: goical.Params{}, Value: formatUTCOffset(offsetFrom)})
+	observance.Props.Set(&goical.Prop{Name: "TZOFFSETTO", Params: goical.Params{}, Value: formatUTCOffset(offsetTo)})
+	if name != "" {
+		observance.Props.Set(&goical.Prop{Name: "TZNAME", Params: goical.Params{}, Value: name})
+	}
+	if rrule != "" {
+		observance.Props.Set(&goical.Prop{Name: "RRULE", Params: goical.Params{}, Value: rrule})
+	}
+	return observance
+}
+
+// formatUTCOffset formats an offset in seconds as UTC-OFFSET value
+func formatUTCOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	value := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+	if seconds := offset % 60; seconds != 0 {
+		value += fmt.Sprintf("%02d", seconds)
+	}
+	return value
+}
+
+// collectTZIDs records every TZID parameter used in a component and its
+// sub-components, together with the earliest date or date-time it is used
+// with. TZIDs without a value that can be parsed are not recorded.
+func collectTZIDs(component *Component, tzids map[string]time.Time) {
+	for _, props := range component.Props {
+		for _, prop := range props {
+			tzid := prop.Params.Get("TZID")
+			if tzid == "" {
+				continue
+			}
+
+			for _, value := range strings.Split(prop.Value, ",") {
+				t, err := time.Parse(localDateTimeLayout, value)
+				if err != nil {
+					if t, err = time.Parse(dateLayout, value); err != nil {
+						continue
+					}
+				}
+				if earliest, ok := tzids[tzid]; !ok || t.Before(earliest) {
+					tzids[tzid] = t
+				}
+			}
+		}
+	}
+	for _, child := range component.Children {
+		collectTZIDs(child, tzids)
+	}
+}
+
+// timezoneID returns the TZID of a VTIMEZONE component
+func timezoneID(component *Component) string {
+	if prop := component.Props.Get("TZID"); prop != nil {
+		return prop.Value
+	}
+	return ""
+}
+
+// insertTimezones inserts VTIMEZONE components before the first calendar
+// object of a calendar
+func insertTimezones(cal *Calendar, timezones []*Component) {
+	if len(timezones) == 0 {
+		return
+	}
+
+	index := len(cal.Children)
+	for i, component := range cal.Children {
+		if IsObjectComponent(component.Name) {
+			index = i
+			break
+		}
+	}
+
+	children := make([]*Component, 0, len(cal.Children)+len(timezones))
+	children = append(children, cal.Children[:index]...)
+	children = append(children, timezones...)
+	children = append(children, cal.Children[index:]...)
+	cal.Children = children
+}
@@ -0,0 +1,159 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+)
+
+func TestNewTimezone(t *testing.T) {
+	timezone, err := NewTimezone("Europe/Berlin", 2025)
+	if err != nil {
+		t.Fatalf("NewTimezone() error = %v", err)
+	}
+
+	observances := make(map[string]*Component)
+	for _, child := range timezone.Children {
+		observances[child.Name] = child
+	}
+
+	tests := []struct {
+		name    string
+		dtstart string
+		rrule   string
+		from    string
+		to      string
+	}{
+		{"DAYLIGHT", "20250330T020000", "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", "+0100", "+0200"},
+		{"STANDARD", "20251026T030000", "FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU", "+0200", "+0100"},
+	}
+	for _, tt := range tests {
+		observance := observances[tt.name]
+		if observance == nil {
+			t.Fatalf("missing %s observance", tt.name)
+		}
+		for prop, want := range map[string]string{
+			"DTSTART":      tt.dtstart,
+			"RRULE":        tt.rrule,
+			"TZOFFSETFROM": tt.from,
+			"TZOFFSETTO":   tt.to,
+		} {
+			if got := observance.Props.Get(prop); got == nil || got.Value != want {
+				t.Errorf("%s %s = %v, want %s", tt.name, prop, got, want)
+			}
+		}
+	}
+
+	// The generated definition must resolve times like the Go database does
+	cal := NewCalendar()
+	cal.Children = append(cal.Children, timezone)
+	for _, value := range []string{"20250115T120000", "20250715T120000", "20261115T120000"} {
+		prop := &Prop{Name: "DTSTART", Params: goical.Params{"TZID": {"Europe/Berlin"}}, Value: value}
+		got, err := ParseDateTime(prop, cal)
+		if err != nil {
+			t.Fatalf("ParseDateTime(%s) error = %v", value, err)
+		}
+
+		loc, _ := time.LoadLocation("Europe/Berlin")
+		want, _ := time.ParseInLocation(localDateTimeLayout, value, loc)
+		if !got.Equal(want) {
+			t.Errorf("ParseDateTime(%s) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestNewTimezone_NoDaylightSaving(t *testing.T) {
+	timezone, err := NewTimezone("Asia/Tokyo", 2025)
+	if err != nil {
+		t.Fatalf("NewTimezone() error = %v", err)
+	}
+	if len(timezone.Children) != 1 || timezone.Children[0].Name != "STANDARD" {
+		t.Fatalf("expected a single STANDARD observance, got %d components", len(timezone.Children))
+	}
+	if offset := timezone.Children[0].Props.Get("TZOFFSETTO"); offset == nil || offset.Value != "+0900" {
+		t.Errorf("TZOFFSETTO = %v, want +0900", offset)
+	}
+
+	if _, err := NewTimezone("W. Europe Standard Time", 2025); err == nil {
+		t.Error("expected an error for a non-IANA TZID")
+	}
+}
+
+func TestMergeAndEnsureTimezones(t *testing.T) {
+	stored, err := DecodeCalendar([]byte(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VTIMEZONE
+TZID:Custom Zone
+BEGIN:STANDARD
+DTSTART:19700101T000000
+TZOFFSETFROM:+0300
+TZOFFSETTO:+0300
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:merge-test
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Custom Zone:20250106T100000
+END:VEVENT
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatalf("DecodeCalendar() error = %v", err)
+	}
+
+	merged := NewCalendar()
+	event := stored.Children[1]
+	event.Props.Set(&Prop{Name: "DTEND", Params: goical.Params{"TZID": {"America/New_York"}}, Value: "20250106T120000"})
+	merged.Children = append(merged.Children, event)
+
+	MergeTimezones(merged, stored, stored)
+	EnsureTimezones(merged)
+
+	var tzids []string
+	for _, component := range merged.Children {
+		if component.Name == "VTIMEZONE" {
+			tzids = append(tzids, timezoneID(component))
+		}
+	}
+	if got := strings.Join(tzids, ","); got != "Custom Zone,America/New_York" {
+		t.Errorf("VTIMEZONE TZIDs = %s, want Custom Zone,America/New_York", got)
+	}
+	if last := merged.Children[len(merged.Children)-1]; last.Name != "VEVENT" {
+		t.Errorf("expected time zones before the VEVENT, last component is %s", last.Name)
+	}
+}
+
+func TestEnsureTimezones_EarliestUse(t *testing.T) {
+	// São Paulo observed daylight saving time until 2019, the definition
+	// has to cover the earliest occurrence
+	event := goical.NewComponent("VEVENT")
+	event.Props.Set(&Prop{Name: "DTSTART", Params: goical.Params{"TZID": {"America/Sao_Paulo"}}, Value: "20250106T100000"})
+	event.Props.Set(&Prop{Name: "EXDATE", Params: goical.Params{"TZID": {"America/Sao_Paulo"}}, Value: "20250113T100000,20180108T100000"})
+	event.Props.Set(&Prop{Name: "DTEND", Params: goical.Params{"TZID": {"Europe/Berlin"}}, Value: "not a date"})
+
+	for i := 0; i < 10; i++ {
+		cal := NewCalendar()
+		cal.Children = append(cal.Children, event)
+		EnsureTimezones(cal)
+
+		timezone := FindTimezone(cal, "America/Sao_Paulo")
+		if timezone == nil {
+			t.Fatalf("Expected a VTIMEZONE for America/Sao_Paulo")
+		}
+		daylight := false
+		for _, observance := range timezone.Children {
+			daylight = daylight || observance.Name == "DAYLIGHT"
+		}
+		if !daylight {
+			t.Fatalf("Expected the rules of 2018 with daylight saving time")
+		}
+
+		// Unparseable values give no reference date to build rules for
+		if FindTimezone(cal, "Europe/Berlin") != nil {
+			t.Errorf("Expected no VTIMEZONE for a TZID without a valid value")
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// Conflict policies select whether new invitations are checked against the
+// stored calendar
+const (
+	// ConflictsOff skips the check
+	ConflictsOff = "off"
+	// ConflictsReport mentions conflicts in the processing result
+	ConflictsReport = "report"
+	// ConflictsTag also marks the stored event with X-CALMAILPROC-CONFLICT
+	ConflictsTag = "tag"
+)
+
+// ConflictProperty lists the UIDs an event conflicts with
+const ConflictProperty = "X-CALMAILPROC-CONFLICT"
+
+// conflictHorizon limits how far recurring invitations are expanded
+const conflictHorizon = 365 * 24 * time.Hour
+
+// Conflict is a stored event that overlaps an invitation
+type Conflict struct {
+	UID     string
+	Summary string
+	Start   time.Time
+}
+
+// findConflicts returns the stored events that overlap any occurrence of
+// the calendar objects in cal, leaving out the event with the given UID
+func (p *Processor) findConflicts(cal *goical.Calendar, uid string) ([]Conflict, error) {
+	first, last, ok := occurrenceSpan(cal)
+	if !ok {
+		return nil, nil
+	}
+	occurrences, err := ical.Occurrences(cal, first, last)
+	if err != nil {
+		return nil, fmt.Errorf("expanding invitation: %w", err)
+	}
+
+	var busy []ical.Occurrence
+	for _, occurrence := range occurrences {
+		if p.isBusy(occurrence) {
+			busy = append(busy, occurrence)
+		}
+	}
+	if len(busy) == 0 {
+		return nil, nil
+	}
+	end := busy[0].End
+	for _, occurrence := range busy {
+		if occurrence.End.After(end) {
+			end = occurrence.End
+		}
+	}
+
+	stored, err := p.Storage.QueryEvents(context.Background(), busy[0].Start, end)
+	if err != nil {
+		return nil, fmt.Errorf("querying events: %w", err)
+	}
+
+	var conflicts []Conflict
+	for _, event := range stored {
+		if event.UID == uid {
+			continue
+		}
+		storedCal, err := ical.DecodeCalendar(event.RawData)
+		if err != nil {
+			continue
+		}
+		storedOccurrences, err := ical.Occurrences(storedCal, busy[0].Start, end)
+		if err != nil {
+			continue
+		}
+
+		if conflict, ok := p.firstOverlap(busy, storedOccurrences); ok {
+			conflicts = append(conflicts, Conflict{UID: event.UID, Summary: event.Summary, Start: conflict.Start})
+		}
+	}
+	return conflicts, nil
+}
+
+// firstOverlap returns the first busy stored occurrence that overlaps one of
+// the occurrences of an invitation
+func (p *Processor) firstOverlap(invitation, stored []ical.Occurrence) (ical.Occurrence, bool) {
+	for _, occurrence := range stored {
+		if !p.isBusy(occurrence) {
+			continue
+		}
+		for _, requested := range invitation {
+			if occurrence.Overlaps(requested.Start, requested.End) {
+				return occurrence, true
+			}
+		}
+	}
+	return ical.Occurrence{}, false
+}
+
+// isBusy reports whether an occurrence blocks time. Cancelled, transparent
+// and declined events don't, neither do all-day events unless they are
+// explicitly marked as OPAQUE.
+func (p *Processor) isBusy(occurrence ical.Occurrence) bool {
+	component := occurrence.Component
+	if component.Name != ical.ComponentEvent || !occurrence.End.After(occurrence.Start) {
+		return false
+	}
+	if status := component.Props.Get("STATUS"); status != nil && status.Value == "CANCELLED" {
+		return false
+	}
+
+	transp := component.Props.Get("TRANSP")
+	if transp != nil && transp.Value == "TRANSPARENT" {
+		return false
+	}
+	if occurrence.AllDay && (transp == nil || transp.Value != "OPAQUE") {
+		return false
+	}
+
+	if attendee := p.selfAttendee(component); attendee != nil && attendee.Params.Get("PARTSTAT") == "DECLINED" {
+		return false
+	}
+	return true
+}
+
+// occurrenceSpan returns the span in which the occurrences of a calendar
+// are looked for: from the earliest DTSTART up to the conflict horizon
+func occurrenceSpan(cal *goical.Calendar) (time.Time, time.Time, bool) {
+	var first time.Time
+	for _, component := range cal.Children {
+		if !ical.IsObjectComponent(component.Name) || component.Props.Get("DTSTART") == nil {
+			continue
+		}
+		start, err := ical.ParseDateTime(component.Props.Get("DTSTART"), cal)
+		if err != nil {
+			continue
+		}
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+	}
+	if first.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	return first, first.Add(conflictHorizon), true
+}
+
+// tagConflicts marks the calendar objects of an event with the UIDs of the
+// events they conflict with
+func tagConflicts(event *ical.Event, conflicts []Conflict) (*ical.Event, error) {
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		return nil, fmt.Errorf("parsing event data: %w", err)
+	}
+
+	uids := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		uids[i] = conflict.UID
+	}
+	for _, component := range cal.Children {
+		if ical.IsObjectComponent(component.Name) {
+			component.Props.Set(&goical.Prop{Name: ConflictProperty, Params: goical.Params{}, Value: strings.Join(uids, ",")})
+		}
+	}
+
+	data, err := ical.EncodeCalendar(cal)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event data: %w", err)
+	}
+	tagged := *event
+	tagged.RawData = data
+	return &tagged, nil
+}
+
+// describeConflicts formats conflicts for the processing result
+func describeConflicts(conflicts []Conflict) string {
+	names := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		names[i] = fmt.Sprintf("%q at %s", conflict.Summary, conflict.Start.UTC().Format("2006-01-02 15:04Z"))
+	}
+	return fmt.Sprintf("conflicts with %d events: %s", len(conflicts), strings.Join(names, ", "))
+}
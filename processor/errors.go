@@ -0,0 +1,51 @@
+package processor
+
+import (
+	"errors"
+
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// Categories of the errors returned by ProcessEmail, test for them with
+// errors.Is
+var (
+	// ErrInvalidData marks emails whose calendar data can't be parsed or
+	// applied. Processing them again gives the same result.
+	ErrInvalidData = errors.New("invalid calendar data")
+	// ErrTemporary marks failures of the storage or of sending replies,
+	// processing the email again later may succeed
+	ErrTemporary = errors.New("temporary failure")
+)
+
+// categoryError marks an error with a category, keeping its message
+type categoryError struct {
+	err      error
+	category error
+}
+
+func (e *categoryError) Error() string        { return e.err.Error() }
+func (e *categoryError) Unwrap() error        { return e.err }
+func (e *categoryError) Is(target error) bool { return target == e.category }
+
+// markError marks err with category, nil stays nil
+func markError(category, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &categoryError{err: err, category: category}
+}
+
+// classifyError marks an error of processing an email with its category.
+// Storage and journal failures are temporary, everything else comes from
+// the calendar data of the email.
+func classifyError(err error) error {
+	switch {
+	case err == nil, errors.Is(err, ErrTemporary), errors.Is(err, ErrInvalidData):
+		return err
+	case errors.Is(err, storage.ErrUnavailable), errors.Is(err, journal.ErrRecord):
+		return markError(ErrTemporary, err)
+	default:
+		return markError(ErrInvalidData, err)
+	}
+}
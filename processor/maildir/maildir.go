@@ -2,56 +2,93 @@ package maildir
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/mkbrechtel/calmailproc/logging"
 	"github.com/mkbrechtel/calmailproc/processor"
 )
 
 type MaildirConfig struct {
-	Path    string `yaml:"path"`
-	Verbose bool   `yaml:"verbose"`
+	Path string `yaml:"path"`
+	// Verbose enables debug logging if no log level is configured
+	Verbose bool `yaml:"verbose"`
+	// StatsFile receives the summary of every run, - is stdout
+	StatsFile string `yaml:"stats_file"`
+	// StatsFormat is text or json, empty means text
+	StatsFormat string `yaml:"stats_format"`
 }
 
-func ProcessWithConfig(config MaildirConfig, proc *processor.Processor) error {
-	return Process(config.Path, proc, config.Verbose)
+// walker processes the folders of one maildir run
+type walker struct {
+	proc    *processor.Processor
+	logger  *slog.Logger
+	summary *Summary
 }
 
-func Process(maildirPath string, proc *processor.Processor, verbose bool) error {
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Starting to process maildir: %s\n", maildirPath)
+// Run processes all emails of the configured maildir and its subfolders.
+// The results are printed to stdout, progress and problems go to logger,
+// which may be nil. Emails that fail don't stop the run, they are counted
+// in the summary, which is logged at the end and written to the stats file
+// if one is configured, also when the run fails.
+func Run(config MaildirConfig, proc *processor.Processor, logger *slog.Logger) (*Summary, error) {
+	if logger == nil {
+		logger = logging.Discard()
 	}
+	maildirPath := config.Path
+	logger.Debug("Starting to process maildir", "path", maildirPath)
 
-	// Check if directory exists
-	if _, err := os.Stat(maildirPath); os.IsNotExist(err) {
-		return fmt.Errorf("maildir path does not exist: %s", maildirPath)
+	if err := checkStatsFormat(config.StatsFormat); err != nil {
+		return nil, err
 	}
 
-	// List contents of the directory for debugging
-	if verbose {
-		entries, err := os.ReadDir(maildirPath)
-		if err != nil {
-			return fmt.Errorf("reading maildir: %w", err)
-		}
+	summary := &Summary{Maildir: maildirPath, Started: time.Now()}
+	var err error
 
-		fmt.Fprintf(os.Stderr, "Found %d entries in maildir root\n", len(entries))
-		for _, entry := range entries {
-			fmt.Fprintf(os.Stderr, "  - %s (dir: %t)\n", entry.Name(), entry.IsDir())
+	// Check if directory exists
+	if _, statErr := os.Stat(maildirPath); os.IsNotExist(statErr) {
+		err = fmt.Errorf("maildir path does not exist: %s", maildirPath)
+	} else {
+		// Process the current maildir
+		proc.EnableStats(&summary.Stats)
+		w := &walker{proc: proc, logger: logger, summary: summary}
+		if walkErr := w.processMaildirDirectory(maildirPath); walkErr != nil {
+			err = fmt.Errorf("processing maildir %s: %w", maildirPath, walkErr)
 		}
 	}
+	summary.Elapsed = Duration(time.Since(summary.Started))
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	logger.Info("Maildir run finished", summary.LogAttrs()...)
 
-	// Process the current maildir
-	if err := processMaildirDirectory(maildirPath, proc, verbose); err != nil {
-		return fmt.Errorf("processing maildir %s: %w", maildirPath, err)
+	// The stats are written for failed runs as well, monitoring needs
+	// them most then
+	if config.StatsFile != "" {
+		if writeErr := summary.WriteFile(config.StatsFile, config.StatsFormat); writeErr != nil {
+			if err != nil {
+				logger.Error("Writing stats file failed", "path", config.StatsFile, "error", writeErr)
+				return summary, err
+			}
+			return summary, writeErr
+		}
 	}
 
-	return nil
+	return summary, err
+}
+
+// Process processes all emails of a maildir like Run, without the summary
+func Process(maildirPath string, proc *processor.Processor, logger *slog.Logger) error {
+	_, err := Run(MaildirConfig{Path: maildirPath}, proc, logger)
+	return err
 }
 
 // processMaildirDirectory processes a maildir directory and all its subfolders
-func processMaildirDirectory(dirPath string, proc *processor.Processor, verbose bool) error {
+func (w *walker) processMaildirDirectory(dirPath string) error {
 	// Process the standard maildir folders (new and cur)
-	if err := processStandardMaildirFolders(dirPath, proc, verbose); err != nil {
+	if err := w.processStandardMaildirFolders(dirPath); err != nil {
 		return err
 	}
 
@@ -59,51 +96,45 @@ func processMaildirDirectory(dirPath string, proc *processor.Processor, verbose
 	// and subdirectories will be processed
 
 	// Process subdirectories recursively
-	return processSubdirectories(dirPath, proc, verbose)
+	return w.processSubdirectories(dirPath)
 }
 
 // processStandardMaildirFolders processes the standard 'new' and 'cur' folders of a maildir
-func processStandardMaildirFolders(maildirPath string, proc *processor.Processor, verbose bool) error {
+func (w *walker) processStandardMaildirFolders(maildirPath string) error {
 	// Process the 'new' folder if it exists
 	newDir := filepath.Join(maildirPath, "new")
 	if _, err := os.Stat(newDir); err == nil {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "Processing 'new' directory: %s\n", newDir)
-		}
-		if err := processEmailsInDirectory(newDir, proc, verbose); err != nil {
+		if err := w.processEmailsInDirectory(newDir); err != nil {
 			return fmt.Errorf("processing 'new' directory: %w", err)
 		}
-	} else if verbose {
-		fmt.Fprintf(os.Stderr, "'new' directory does not exist: %s\n", newDir)
+	} else {
+		w.logger.Debug("Directory does not exist", "path", newDir)
 	}
 
 	// Process the 'cur' folder if it exists
 	curDir := filepath.Join(maildirPath, "cur")
 	if _, err := os.Stat(curDir); err == nil {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "Processing 'cur' directory: %s\n", curDir)
-		}
-		if err := processEmailsInDirectory(curDir, proc, verbose); err != nil {
+		if err := w.processEmailsInDirectory(curDir); err != nil {
 			return fmt.Errorf("processing 'cur' directory: %w", err)
 		}
-	} else if verbose {
-		fmt.Fprintf(os.Stderr, "'cur' directory does not exist: %s\n", curDir)
+	} else {
+		w.logger.Debug("Directory does not exist", "path", curDir)
 	}
 
 	return nil
 }
 
 // processEmailsInDirectory processes all email files in a directory
-func processEmailsInDirectory(dirPath string, proc *processor.Processor, verbose bool) error {
+func (w *walker) processEmailsInDirectory(dirPath string) error {
 	files, err := os.ReadDir(dirPath)
 	if err != nil {
 		return fmt.Errorf("reading directory %s: %w", dirPath, err)
 	}
 
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Found %d files in directory: %s\n", len(files), dirPath)
-	}
+	w.logger.Debug("Processing directory", "path", dirPath, "files", len(files))
 
+	started := time.Now()
+	scanned, failed := w.summary.Scanned, w.summary.Errors
 	processedCount := 0
 	for _, file := range files {
 		if file.IsDir() {
@@ -118,48 +149,56 @@ func processEmailsInDirectory(dirPath string, proc *processor.Processor, verbose
 
 		// Process the email file
 		filePath := filepath.Join(dirPath, name)
-		if err := processEmailFile(filePath, proc, verbose); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		if err := w.processEmailFile(filePath); err != nil {
 			continue
 		}
 
 		processedCount++
 	}
 
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Processed %d/%d files in: %s\n", processedCount, len(files), dirPath)
-	}
+	w.logger.Debug("Processed directory", "path", dirPath, "processed", processedCount, "files", len(files))
+	w.summary.Folders = append(w.summary.Folders, FolderSummary{
+		Path:    dirPath,
+		Scanned: w.summary.Scanned - scanned,
+		Errors: processor.ErrorCounts{
+			InvalidData: w.summary.Errors.InvalidData - failed.InvalidData,
+			Temporary:   w.summary.Errors.Temporary - failed.Temporary,
+			Other:       w.summary.Errors.Other - failed.Other,
+		},
+		Elapsed: Duration(time.Since(started)),
+	})
 
 	return nil
 }
 
-// processEmailFile processes a single email file
-func processEmailFile(filePath string, proc *processor.Processor, verbose bool) error {
+// processEmailFile processes a single email file. Processing errors are
+// logged by the processor, with the UID and method of the email.
+func (w *walker) processEmailFile(filePath string) error {
 	// Open the file
 	f, err := os.Open(filePath)
 	if err != nil {
+		w.logger.Error("Opening email failed", "path", filePath, "error", err)
+		// The processor never sees the email, count it here
+		w.summary.Scanned++
+		w.summary.Errors.Other++
 		return fmt.Errorf("failed to open %s: %v", filePath, err)
 	}
 	defer f.Close()
 
 	// Process the email
-	msg, err := proc.ProcessEmail(f)
-	if verbose || msg != "Processed E-Mail without calendar event" {
+	msg, err := w.proc.ProcessEmailFrom(f, filePath)
+	if msg != "Processed E-Mail without calendar event" {
 		fmt.Fprintf(os.Stdout, "%s > %s\n", filePath, msg)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to process %s: %v", filePath, err)
+		return fmt.Errorf("failed to process %s: %w", filePath, err)
 	}
 
 	return nil
 }
 
 // processSubdirectories recursively processes all subdirectories
-func processSubdirectories(parentDir string, proc *processor.Processor, verbose bool) error {
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Looking for subfolders in: %s\n", parentDir)
-	}
-
+func (w *walker) processSubdirectories(parentDir string) error {
 	entries, err := os.ReadDir(parentDir)
 	if err != nil {
 		return fmt.Errorf("reading directory %s: %w", parentDir, err)
@@ -177,9 +216,6 @@ func processSubdirectories(parentDir string, proc *processor.Processor, verbose
 		}
 
 		subPath := filepath.Join(parentDir, name)
-		if verbose {
-			fmt.Fprintf(os.Stderr, "Checking subfolder: %s\n", subPath)
-		}
 
 		// Check if this is a maildir subfolder (has new and/or cur directories)
 		isMaildir := false
@@ -190,15 +226,11 @@ func processSubdirectories(parentDir string, proc *processor.Processor, verbose
 			isMaildir = true
 		}
 
-		if isMaildir && verbose {
-			fmt.Fprintf(os.Stderr, "Processing maildir subfolder: %s\n", subPath)
-		} else if verbose {
-			fmt.Fprintf(os.Stderr, "Processing non-maildir subfolder: %s\n", subPath)
-		}
+		w.logger.Debug("Processing subfolder", "path", subPath, "maildir", isMaildir)
 
 		// Process this directory (whether it's a maildir or not)
-		if err := processMaildirDirectory(subPath, proc, verbose); err != nil && verbose {
-			fmt.Fprintf(os.Stderr, "Warning: Error processing directory %s: %v\n", subPath, err)
+		if err := w.processMaildirDirectory(subPath); err != nil {
+			w.logger.Warn("Processing subfolder failed", "path", subPath, "error", err)
 		}
 	}
 
@@ -1,9 +1,15 @@
 package maildir
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/mkbrechtel/calmailproc/parser/ical"
 	"github.com/mkbrechtel/calmailproc/processor"
 	"github.com/mkbrechtel/calmailproc/storage"
 )
@@ -14,7 +20,7 @@ func TestProcess_InvalidPath(t *testing.T) {
 	proc := processor.NewProcessor(store, true)
 
 	// Test with a non-existent path
-	err := Process("/path/that/does/not/exist", proc, false)
+	err := Process("/path/that/does/not/exist", proc, nil)
 	if err == nil {
 		t.Errorf("Expected error for non-existent path, but got nil")
 	}
@@ -34,7 +40,7 @@ func TestProcess_ExistingMaildir(t *testing.T) {
 	proc := processor.NewProcessor(store, true)
 
 	// Process the test maildir
-	err := Process(testMaildir, proc, false)
+	err := Process(testMaildir, proc, nil)
 	if err != nil {
 		t.Errorf("Expected no error processing test maildir, but got: %v", err)
 	}
@@ -59,7 +65,7 @@ func TestProcess_MalformedEmails(t *testing.T) {
 	proc := processor.NewProcessor(store, true)
 	
 	// Process the test maildir with verbose mode to see outputs
-	err := Process(testMaildir, proc, true) 
+	err := Process(testMaildir, proc, nil) 
 	if err != nil {
 		t.Errorf("Expected no error processing maildir with malformed emails, but got: %v", err)
 	}
@@ -80,4 +86,118 @@ func TestProcess_MalformedEmails(t *testing.T) {
 			t.Errorf("Found event with empty UID in storage, which should never happen")
 		}
 	}
-}
\ No newline at end of file
+}
+
+// unavailableStorage fails to store anything, like an unreachable server
+type unavailableStorage struct {
+	*storage.MemoryStorage
+}
+
+func (unavailableStorage) StoreEvent(*ical.Event) error {
+	return fmt.Errorf("connection refused: %w", storage.ErrUnavailable)
+}
+
+func TestRun_Summary(t *testing.T) {
+	proc := processor.NewProcessor(storage.NewMemoryStorage(), true)
+	summary, err := Run(MaildirConfig{Path: "../../test/maildir"}, proc, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// test-12 to test-15 contain broken calendar data
+	if summary.Scanned == 0 || summary.Errors.Total() != 4 || summary.Errors.InvalidData != 4 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if err := summary.Err(); !errors.Is(err, processor.ErrInvalidData) {
+		t.Errorf("Expected an invalid data error, got %v", err)
+	}
+
+	// A failing storage takes precedence, the run must be retried
+	proc = processor.NewProcessor(unavailableStorage{storage.NewMemoryStorage()}, true)
+	summary, err = Run(MaildirConfig{Path: "../../test/maildir"}, proc, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if summary.Errors.Temporary == 0 || summary.Errors.InvalidData != 4 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if err := summary.Err(); !errors.Is(err, processor.ErrTemporary) {
+		t.Errorf("Expected a temporary error, got %v", err)
+	}
+
+	if err := (&Summary{Stats: processor.Stats{Scanned: 3}}).Err(); err != nil {
+		t.Errorf("Expected no error without failures, got %v", err)
+	}
+}
+
+func TestRun_StatsFile(t *testing.T) {
+	dir := t.TempDir()
+	statsFile := filepath.Join(dir, "stats.json")
+
+	proc := processor.NewProcessor(storage.NewMemoryStorage(), true)
+	config := MaildirConfig{Path: "../../test/maildir", StatsFile: statsFile, StatsFormat: StatsFormatJSON}
+	summary, err := Run(config, proc, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	data, err := os.ReadFile(statsFile)
+	if err != nil {
+		t.Fatalf("Failed to read stats file: %v", err)
+	}
+	var report map[string]interface{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Invalid stats file: %v\n%s", err, data)
+	}
+	if report["scanned"] != float64(summary.Scanned) || report["created"] != float64(summary.Created) {
+		t.Errorf("Unexpected report: %s", data)
+	}
+	if errs, _ := report["errors"].(map[string]interface{}); errs["invalid_data"] != float64(4) {
+		t.Errorf("Expected 4 invalid data errors, got %v", report["errors"])
+	}
+	folders, _ := report["folders"].([]interface{})
+	if len(folders) == 0 {
+		t.Fatalf("Expected folder timings, got %v", report["folders"])
+	}
+	if folder, _ := folders[0].(map[string]interface{}); folder["errors"].(map[string]interface{})["invalid_data"] != float64(4) {
+		t.Errorf("Expected the errors of the folder by category, got %v", folder)
+	}
+	if summary.WithCalendar == 0 || summary.Created == 0 || summary.WithCalendar > summary.Scanned {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	// The text report is written on the next run, replacing the file
+	config.StatsFormat = StatsFormatText
+	proc = processor.NewProcessor(storage.NewMemoryStorage(), true)
+	if _, err := Run(config, proc, nil); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	data, err = os.ReadFile(statsFile)
+	if err != nil {
+		t.Fatalf("Failed to read stats file: %v", err)
+	}
+	for _, line := range []string{"Scanned:", "With calendar data:", "Ignored as older:", "Errors:", "FOLDER"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("Expected %q in the report:\n%s", line, data)
+		}
+	}
+
+	// A failed run writes its stats as well
+	config.Path = filepath.Join(dir, "missing")
+	config.StatsFormat = StatsFormatJSON
+	if _, err := Run(config, proc, nil); err == nil {
+		t.Fatal("Expected an error for a missing maildir")
+	}
+	data, err = os.ReadFile(statsFile)
+	if err != nil {
+		t.Fatalf("Failed to read stats file: %v", err)
+	}
+	if !strings.Contains(string(data), `"error": "maildir path does not exist`) {
+		t.Errorf("Expected the error in the report:\n%s", data)
+	}
+
+	config.StatsFormat = "xml"
+	if _, err := Run(config, proc, nil); err == nil {
+		t.Error("Expected an error for an unknown stats format")
+	}
+}
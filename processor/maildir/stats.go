@@ -0,0 +1,182 @@
+package maildir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/processor"
+)
+
+// Formats of the stats file
+const (
+	StatsFormatText = "text"
+	StatsFormatJSON = "json"
+)
+
+// Duration is a time.Duration written as seconds to JSON
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).Round(time.Millisecond).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return strconv.AppendFloat(nil, time.Duration(d).Seconds(), 'f', 3, 64), nil
+}
+
+// FolderSummary counts the emails of one maildir folder
+type FolderSummary struct {
+	Path    string                `json:"path"`
+	Scanned int                   `json:"scanned"`
+	Errors  processor.ErrorCounts `json:"errors"`
+	Elapsed Duration              `json:"elapsed_seconds"`
+}
+
+// Summary reports a maildir run: the processor stats of all emails and
+// the time spent in every folder
+type Summary struct {
+	Maildir string    `json:"maildir"`
+	Started time.Time `json:"started"`
+	Elapsed Duration  `json:"elapsed_seconds"`
+	// Error is set if the run stopped early, the counts are incomplete
+	Error string `json:"error,omitempty"`
+	processor.Stats
+	Folders []FolderSummary `json:"folders"`
+}
+
+// Err returns an error if emails failed. It is marked with
+// processor.ErrTemporary if any failure was temporary, so that the run is
+// retried, otherwise with processor.ErrInvalidData if any email had invalid
+// calendar data.
+func (s *Summary) Err() error {
+	failed := s.Errors.Total()
+	switch {
+	case s.Errors.Temporary > 0:
+		return fmt.Errorf("%d of %d emails failed: %w", failed, s.Scanned, processor.ErrTemporary)
+	case s.Errors.InvalidData > 0:
+		return fmt.Errorf("%d of %d emails failed: %w", failed, s.Scanned, processor.ErrInvalidData)
+	case failed > 0:
+		return fmt.Errorf("%d of %d emails failed", failed, s.Scanned)
+	}
+	return nil
+}
+
+// LogAttrs returns the counts of the summary as log attributes
+func (s *Summary) LogAttrs() []any {
+	attrs := []any{
+		"maildir", s.Maildir,
+		"elapsed", s.Elapsed.String(),
+		"scanned", s.Scanned,
+		"with_calendar", s.WithCalendar,
+		"created", s.Created,
+		"updated", s.Updated,
+		"ignored_older", s.IgnoredOlder,
+		"replies_applied", s.RepliesApplied,
+		"replies_ignored", s.RepliesIgnored,
+		"invalid_data", s.Errors.InvalidData,
+		"temporary", s.Errors.Temporary,
+		"other_errors", s.Errors.Other,
+	}
+	if s.Error != "" {
+		attrs = append(attrs, "error", s.Error)
+	}
+	return attrs
+}
+
+// WriteText writes the summary as a human readable report
+func (s *Summary) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Maildir:\t%s\n", s.Maildir)
+	fmt.Fprintf(tw, "Started:\t%s\n", s.Started.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Elapsed:\t%s\n", s.Elapsed)
+	if s.Error != "" {
+		fmt.Fprintf(tw, "Failed:\t%s\n", s.Error)
+	}
+	fmt.Fprintf(tw, "Scanned:\t%d\n", s.Scanned)
+	fmt.Fprintf(tw, "With calendar data:\t%d\n", s.WithCalendar)
+	fmt.Fprintf(tw, "Created:\t%d\n", s.Created)
+	fmt.Fprintf(tw, "Updated:\t%d\n", s.Updated)
+	fmt.Fprintf(tw, "Ignored as older:\t%d\n", s.IgnoredOlder)
+	fmt.Fprintf(tw, "Replies applied:\t%d\n", s.RepliesApplied)
+	fmt.Fprintf(tw, "Replies ignored:\t%d\n", s.RepliesIgnored)
+	fmt.Fprintf(tw, "Errors:\t%d (invalid data %d, temporary %d, other %d)\n",
+		s.Errors.Total(), s.Errors.InvalidData, s.Errors.Temporary, s.Errors.Other)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(s.Folders) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FOLDER\tSCANNED\tINVALID DATA\tTEMPORARY\tOTHER\tELAPSED")
+	for _, folder := range s.Folders {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", folder.Path, folder.Scanned,
+			folder.Errors.InvalidData, folder.Errors.Temporary, folder.Errors.Other, folder.Elapsed)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the summary as a JSON object
+func (s *Summary) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// write writes the summary in the given format, empty means text
+func (s *Summary) write(w io.Writer, format string) error {
+	switch format {
+	case "", StatsFormatText:
+		return s.WriteText(w)
+	case StatsFormatJSON:
+		return s.WriteJSON(w)
+	default:
+		return fmt.Errorf("unknown stats format: %s", format)
+	}
+}
+
+// WriteFile writes the summary to path, - is stdout. The file is replaced
+// at once, so that monitoring never reads half a report.
+func (s *Summary) WriteFile(path, format string) error {
+	if path == "-" {
+		return s.write(os.Stdout, format)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating stats file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.write(tmp, format); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing stats file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing stats file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("writing stats file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing stats file: %w", err)
+	}
+	return nil
+}
+
+// checkStatsFormat returns an error for an unknown stats format
+func checkStatsFormat(format string) error {
+	switch format {
+	case "", StatsFormatText, StatsFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown stats format: %s", format)
+}
@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"strings"
+
+	goical "github.com/emersion/go-ical"
+)
+
+// Default local parts of a calendar object, which users typically change in
+// their calendar client and organizers don't care about
+var (
+	DefaultLocalProperties = []string{"CATEGORIES", "COLOR", "TRANSP", "X-*"}
+	DefaultLocalComponents = []string{"VALARM"}
+)
+
+// processorPropertyPrefix marks properties calmailproc sets itself. They
+// describe the current processing state and are never carried over.
+const processorPropertyPrefix = "X-CALMAILPROC-"
+
+// MergePolicy lists the parts of a calendar object that belong to the user
+// rather than the organizer. When an organizer update replaces a stored
+// component, local parts present in the stored version are carried over,
+// everything else comes from the update.
+type MergePolicy struct {
+	// Properties are property names, a trailing "*" matches all names with
+	// that prefix (e.g. "X-*"). Properties matched by name always keep the
+	// stored value, properties matched by prefix only when the update
+	// doesn't contain them, so organizer extensions still get updated.
+	Properties []string
+	// Components are sub-component names such as VALARM
+	Components []string
+}
+
+// DefaultMergePolicy returns the merge policy used without configuration
+func DefaultMergePolicy() MergePolicy {
+	return NewMergePolicy(DefaultLocalProperties, DefaultLocalComponents)
+}
+
+// NewMergePolicy builds a merge policy from configured names. A nil list
+// selects the defaults, an empty list makes nothing local.
+func NewMergePolicy(properties, components []string) MergePolicy {
+	if properties == nil {
+		properties = DefaultLocalProperties
+	}
+	if components == nil {
+		components = DefaultLocalComponents
+	}
+
+	policy := MergePolicy{}
+	for _, name := range properties {
+		policy.Properties = append(policy.Properties, strings.ToUpper(strings.TrimSpace(name)))
+	}
+	for _, name := range components {
+		policy.Components = append(policy.Components, strings.ToUpper(strings.TrimSpace(name)))
+	}
+	return policy
+}
+
+// IsLocalProperty reports whether a property is carried over from the
+// stored version
+func (m MergePolicy) IsLocalProperty(name string) bool {
+	local, _ := m.localProperty(name)
+	return local
+}
+
+// localProperty reports whether a property is local and whether it was
+// matched by its full name
+func (m MergePolicy) localProperty(name string) (local, exact bool) {
+	name = strings.ToUpper(name)
+	if strings.HasPrefix(name, processorPropertyPrefix) {
+		return false, false
+	}
+
+	for _, pattern := range m.Properties {
+		if name == pattern {
+			return true, true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(name, prefix) {
+			local = true
+		}
+	}
+	return local, false
+}
+
+// IsLocalComponent reports whether a sub-component is carried over from the
+// stored version
+func (m MergePolicy) IsLocalComponent(name string) bool {
+	name = strings.ToUpper(name)
+	for _, local := range m.Components {
+		if name == local {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply carries the local parts of a stored component over to the update
+// that replaces it. Local sub-components of the update are only kept where
+// the stored version has none of that name.
+func (m MergePolicy) Apply(stored, update *goical.Component) {
+	if stored == nil || update == nil {
+		return
+	}
+
+	for name, props := range stored.Props {
+		local, exact := m.localProperty(name)
+		if !local {
+			continue
+		}
+		if _, ok := update.Props[name]; ok && !exact {
+			continue
+		}
+		update.Props[name] = props
+	}
+
+	storedLocal := make(map[string][]*goical.Component)
+	for _, child := range stored.Children {
+		if m.IsLocalComponent(child.Name) {
+			storedLocal[child.Name] = append(storedLocal[child.Name], child)
+		}
+	}
+	if len(storedLocal) == 0 {
+		return
+	}
+
+	var children []*goical.Component
+	for _, child := range update.Children {
+		if _, ok := storedLocal[child.Name]; !ok {
+			children = append(children, child)
+		}
+	}
+	for _, child := range stored.Children {
+		if _, ok := storedLocal[child.Name]; ok {
+			children = append(children, child)
+		}
+	}
+	update.Children = children
+}
@@ -0,0 +1,51 @@
+package processor
+
+import (
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// isSelfAddress reports whether a CAL-ADDRESS is one of the configured
+// addresses of the calendar owner
+func (p *Processor) isSelfAddress(value string) bool {
+	address := ical.CalendarAddress(value)
+	for _, self := range p.SelfAddresses {
+		if ical.CalendarAddress(self) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// keepSelfPartstat copies the stored PARTSTAT of the calendar owner to an
+// update that replaces the stored component, unless the update is a
+// significant change that asks attendees to respond again
+func (p *Processor) keepSelfPartstat(stored *goical.Component, storedCal *goical.Calendar,
+	update *goical.Component, updateCal *goical.Calendar) {
+	if len(p.SelfAddresses) == 0 || stored == nil || update == nil {
+		return
+	}
+	if ical.IsSignificantChange(stored, storedCal, update, updateCal) {
+		return
+	}
+
+	storedStatus := make(map[string]string)
+	for _, attendee := range stored.Props.Values("ATTENDEE") {
+		if !p.isSelfAddress(attendee.Value) {
+			continue
+		}
+		if partstat := attendee.Params.Get("PARTSTAT"); partstat != "" {
+			storedStatus[ical.CalendarAddress(attendee.Value)] = partstat
+		}
+	}
+
+	for _, attendee := range update.Props.Values("ATTENDEE") {
+		partstat, ok := storedStatus[ical.CalendarAddress(attendee.Value)]
+		if !ok || attendee.Params.Get("PARTSTAT") == partstat {
+			continue
+		}
+		// Params is shared with the property stored in the component
+		attendee.Params.Set("PARTSTAT", partstat)
+		attendee.Params.Del("RSVP")
+	}
+}
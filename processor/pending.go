@@ -0,0 +1,197 @@
+package processor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// Orphan reply policies select what happens to a METHOD:REPLY whose event
+// is not (yet) in storage
+const (
+	// OrphanRepliesStore stores the reply payload as if it were the event
+	OrphanRepliesStore = "store"
+	// OrphanRepliesPending parks the reply until the matching REQUEST arrives
+	OrphanRepliesPending = "pending"
+	// OrphanRepliesDrop skips the reply, this is the default
+	OrphanRepliesDrop = "drop"
+)
+
+// PendingReplyStore keeps REPLY payloads keyed by UID until the event they
+// refer to has been stored. This covers maildirs where the answer to an
+// invitation is read before the invitation itself.
+type PendingReplyStore interface {
+	// AddReply parks a reply for later application
+	AddReply(reply *ical.Event) error
+
+	// Replies returns all parked replies for the UID in the order they
+	// were added. They stay parked until RemoveReplies.
+	Replies(uid string) ([]*ical.Event, error)
+
+	// RemoveReplies removes the first n parked replies for the UID, once
+	// the event they were applied to is stored
+	RemoveReplies(uid string, n int) error
+}
+
+// MemoryPendingReplyStore is a PendingReplyStore that only lives as long as
+// the process, which is enough for a single maildir run
+type MemoryPendingReplyStore struct {
+	replies map[string][]*ical.Event
+	mu      sync.Mutex
+}
+
+func NewMemoryPendingReplyStore() *MemoryPendingReplyStore {
+	return &MemoryPendingReplyStore{
+		replies: make(map[string][]*ical.Event),
+	}
+}
+
+func (m *MemoryPendingReplyStore) AddReply(reply *ical.Event) error {
+	if reply.UID == "" {
+		return fmt.Errorf("reply has no UID")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.replies[reply.UID] = append(m.replies[reply.UID], reply)
+	return nil
+}
+
+func (m *MemoryPendingReplyStore) Replies(uid string) ([]*ical.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*ical.Event(nil), m.replies[uid]...), nil
+}
+
+func (m *MemoryPendingReplyStore) RemoveReplies(uid string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n >= len(m.replies[uid]) {
+		delete(m.replies, uid)
+	} else {
+		m.replies[uid] = m.replies[uid][n:]
+	}
+	return nil
+}
+
+// DirPendingReplyStore is a PendingReplyStore that keeps the parked replies
+// as .ics files below a directory, so they survive between invocations
+// (e.g. one calmailproc call per mail from procmail)
+type DirPendingReplyStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewDirPendingReplyStore(dir string) (*DirPendingReplyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating pending replies directory: %w", err)
+	}
+	return &DirPendingReplyStore{dir: dir}, nil
+}
+
+// uidDir returns the directory holding the replies of one UID. The UID is
+// hex encoded as UIDs like ".." are valid but must not end up in a path.
+func (d *DirPendingReplyStore) uidDir(uid string) string {
+	return filepath.Join(d.dir, hex.EncodeToString([]byte(uid)))
+}
+
+func (d *DirPendingReplyStore) AddReply(reply *ical.Event) error {
+	if reply.UID == "" {
+		return fmt.Errorf("reply has no UID")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	uidDir := d.uidDir(reply.UID)
+	if err := os.MkdirAll(uidDir, 0700); err != nil {
+		return fmt.Errorf("creating pending reply directory: %w", err)
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".ics"
+	if err := os.WriteFile(filepath.Join(uidDir, name), reply.RawData, 0600); err != nil {
+		return fmt.Errorf("writing pending reply: %w", err)
+	}
+
+	return nil
+}
+
+// replyFiles returns the files of the replies parked for the UID, oldest
+// first
+func (d *DirPendingReplyStore) replyFiles(uid string) ([]string, error) {
+	entries, err := os.ReadDir(d.uidDir(uid))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pending replies: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d *DirPendingReplyStore) Replies(uid string) ([]*ical.Event, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names, err := d.replyFiles(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]*ical.Event, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(d.uidDir(uid), name))
+		if err != nil {
+			return nil, fmt.Errorf("reading pending reply: %w", err)
+		}
+
+		reply, err := ical.ParseICalData(data)
+		if err != nil {
+			// A reply that can't be parsed anymore is returned as is, it
+			// fails to apply and is removed with the others
+			reply = &ical.Event{UID: uid, Method: "REPLY", RawData: data}
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func (d *DirPendingReplyStore) RemoveReplies(uid string, n int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names, err := d.replyFiles(uid)
+	if err != nil {
+		return err
+	}
+	if n >= len(names) {
+		if err := os.RemoveAll(d.uidDir(uid)); err != nil {
+			return fmt.Errorf("removing pending replies: %w", err)
+		}
+		return nil
+	}
+	for _, name := range names[:n] {
+		if err := os.Remove(filepath.Join(d.uidDir(uid), name)); err != nil {
+			return fmt.Errorf("removing pending reply: %w", err)
+		}
+	}
+	return nil
+}
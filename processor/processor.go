@@ -1,63 +1,287 @@
 package processor
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"time"
 
 	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/logging"
+	"github.com/mkbrechtel/calmailproc/outbound"
 	"github.com/mkbrechtel/calmailproc/parser/email"
 	"github.com/mkbrechtel/calmailproc/parser/ical"
 	"github.com/mkbrechtel/calmailproc/storage"
 )
 
+// Orphaned exception policies select what happens to exceptions of a series
+// that no longer match the master after an organizer update
+const (
+	// OrphanedExceptionsDrop removes the exceptions
+	OrphanedExceptionsDrop = "drop"
+	// OrphanedExceptionsFlag keeps them marked with X-CALMAILPROC-ORPHANED
+	OrphanedExceptionsFlag = "flag"
+	// OrphanedExceptionsKeep keeps them unchanged
+	OrphanedExceptionsKeep = "keep"
+)
+
 type ProcessorConfig struct {
 	ProcessReplies bool `yaml:"process_replies"`
+
+	// OrphanReplies selects how a REPLY without a stored event is handled:
+	// "drop" (default), "pending" or "store"
+	OrphanReplies string `yaml:"orphan_replies"`
+	// PendingRepliesDir keeps pending replies on disk, it is required for
+	// the "pending" policy
+	PendingRepliesDir string `yaml:"pending_replies_dir"`
+
+	// OrphanedExceptions selects what happens to stored exceptions that are
+	// no longer occurrences of an updated master: "keep" (default), "flag"
+	// or "drop"
+	OrphanedExceptions string `yaml:"orphaned_exceptions"`
+
+	// CancelInstancesAsExdate adds cancelled occurrences as EXDATE to the
+	// master instead of keeping a cancelled exception
+	CancelInstancesAsExdate bool `yaml:"cancel_instances_as_exdate"`
+
+	// LocalProperties and LocalComponents name the parts of an event that
+	// are kept from the stored version when the organizer sends an update.
+	// Unset lists select DefaultLocalProperties and DefaultLocalComponents.
+	LocalProperties []string `yaml:"local_properties"`
+	LocalComponents []string `yaml:"local_components"`
+
+	// SelfAddresses are the calendar owner's addresses. Their PARTSTAT is
+	// kept on updates that don't change the time or location.
+	SelfAddresses []string `yaml:"self_addresses"`
+
+	// AutoAcceptOrganizers are organizer addresses (or "@domain" patterns)
+	// whose invitations are accepted automatically. Requires self_addresses
+	// and outbound mail.
+	AutoAcceptOrganizers []string `yaml:"auto_accept_organizers"`
+
+	// Conflicts selects whether new invitations are checked for overlaps
+	// with stored events: "off" (default), "report" or "tag"
+	Conflicts string `yaml:"conflicts"`
+
+	// JournalFile is a JSONL file every change to the storage is recorded
+	// in, no journal is kept if it is empty
+	JournalFile string `yaml:"journal_file"`
 }
 
 type Processor struct {
 	Storage        storage.Storage
 	ProcessReplies bool
+
+	// OrphanReplies is one of the OrphanReplies* policies, empty means drop
+	OrphanReplies  string
+	PendingReplies PendingReplyStore
+
+	// OrphanedExceptions is one of the OrphanedExceptions* policies, empty
+	// means keep
+	OrphanedExceptions string
+
+	// CancelInstancesAsExdate cancels single occurrences with an EXDATE on
+	// the master instead of a STATUS:CANCELLED exception
+	CancelInstancesAsExdate bool
+
+	// Merge selects what is carried over from stored events on updates
+	Merge MergePolicy
+
+	// SelfAddresses are the attendee addresses of the calendar owner
+	SelfAddresses []string
+
+	// Replier sends iTIP replies, without it the processor never answers
+	Replier *outbound.Replier
+	// AutoAcceptOrganizers are trusted organizers whose invitations are
+	// accepted on arrival
+	AutoAcceptOrganizers []string
+	// Now returns the current time, time.Now is used if it is nil.
+	// Invitations that are over by then are not accepted automatically.
+	Now func() time.Time
+
+	// Conflicts is one of the Conflicts* policies, empty means off
+	Conflicts string
+
+	// Journal records the changes to the storage, see EnableJournal
+	Journal   *journal.Journal
+	journaled *journal.Storage
+
+	// Stats counts the outcome of the emails, see EnableStats
+	Stats   *Stats
+	counted *statsStorage
+
+	// Logger receives warnings and debug output, never the results
+	Logger *slog.Logger
+	// log is Logger with the attributes of the email being processed
+	log *slog.Logger
 }
 
 func NewProcessor(storage storage.Storage, processReplies bool) *Processor {
 	return &Processor{
 		Storage:        storage,
 		ProcessReplies: processReplies,
+		Merge:          DefaultMergePolicy(),
+		Logger:         logging.Discard(),
 	}
 }
 
-func NewProcessorFromConfig(storage storage.Storage, config ProcessorConfig) *Processor {
-	return NewProcessor(storage, config.ProcessReplies)
+// logger returns the logger for the email being processed
+func (p *Processor) logger() *slog.Logger {
+	if p.log != nil {
+		return p.log
+	}
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return logging.Discard()
+}
+
+// now returns the current time of the processor
+func (p *Processor) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+func NewProcessorFromConfig(storage storage.Storage, config ProcessorConfig) (*Processor, error) {
+	p := NewProcessor(storage, config.ProcessReplies)
+	p.CancelInstancesAsExdate = config.CancelInstancesAsExdate
+	p.Merge = NewMergePolicy(config.LocalProperties, config.LocalComponents)
+	p.SelfAddresses = config.SelfAddresses
+	p.AutoAcceptOrganizers = config.AutoAcceptOrganizers
+
+	if config.JournalFile != "" {
+		j, err := journal.Open(config.JournalFile)
+		if err != nil {
+			return nil, err
+		}
+		p.EnableJournal(j)
+	}
+
+	switch config.OrphanReplies {
+	case "", OrphanRepliesStore, OrphanRepliesDrop:
+		p.OrphanReplies = config.OrphanReplies
+	case OrphanRepliesPending:
+		// Replies parked in memory would be lost when a single email is
+		// processed from stdin
+		if config.PendingRepliesDir == "" {
+			return nil, fmt.Errorf("orphan_replies policy %s requires pending_replies_dir", config.OrphanReplies)
+		}
+		pending, err := NewDirPendingReplyStore(config.PendingRepliesDir)
+		if err != nil {
+			return nil, err
+		}
+		p.OrphanReplies = config.OrphanReplies
+		p.PendingReplies = pending
+	default:
+		return nil, fmt.Errorf("unknown orphan_replies policy: %s", config.OrphanReplies)
+	}
+
+	switch config.Conflicts {
+	case "", ConflictsOff, ConflictsReport, ConflictsTag:
+		p.Conflicts = config.Conflicts
+	default:
+		return nil, fmt.Errorf("unknown conflicts policy: %s", config.Conflicts)
+	}
+
+	switch config.OrphanedExceptions {
+	case "", OrphanedExceptionsDrop, OrphanedExceptionsFlag, OrphanedExceptionsKeep:
+		p.OrphanedExceptions = config.OrphanedExceptions
+	default:
+		return nil, fmt.Errorf("unknown orphaned_exceptions policy: %s", config.OrphanedExceptions)
+	}
+
+	return p, nil
+}
+
+// EnableJournal records all following changes to the storage in j
+func (p *Processor) EnableJournal(j *journal.Journal) {
+	p.journaled = journal.NewStorage(p.Storage, j)
+	p.Storage = p.journaled
+	p.Journal = j
 }
 
 func (p *Processor) ProcessEmail(r io.Reader) (string, error) {
+	return p.ProcessEmailFrom(r, "")
+}
+
+// ProcessEmailFrom processes an email read from the file at path, which is
+// recorded in the journal as the source of the changes. Errors are marked
+// with ErrInvalidData or ErrTemporary, emails without calendar data are no
+// error.
+func (p *Processor) ProcessEmailFrom(r io.Reader, path string) (string, error) {
+	p.log = p.logger()
+	if path != "" {
+		p.log = p.log.With("path", path)
+	}
+	defer func() { p.log = nil }()
+
+	p.countStats(func(s *Stats) { s.Scanned++ })
+	if p.counted != nil {
+		p.counted.reset()
+	}
+
 	parsedEmail, err := email.Parse(r)
 	if err != nil {
-		return "E-Mail parsing error", fmt.Errorf("parsing email: %w", err)
+		p.log.Error("Parsing email failed", "error", err)
+		p.countStats(func(s *Stats) { s.Errors.Record(ErrInvalidData) })
+		return "E-Mail parsing error", markError(ErrInvalidData, fmt.Errorf("parsing email: %w", err))
+	}
+	if parsedEmail.MessageID != "" {
+		p.log = p.log.With("message_id", parsedEmail.MessageID)
+	}
+
+	if p.journaled != nil {
+		p.journaled.Source = journal.Source{
+			MessageID: parsedEmail.MessageID,
+			Path:      path,
+			Date:      parsedEmail.Date,
+		}
+		defer func() { p.journaled.Source = journal.Source{} }()
 	}
 
 	// Process the calendar event if one was found (always store if it has a valid UID)
 	if parsedEmail.HasCalendar && parsedEmail.Event.UID != "" {
-		// Validate the UID before processing
-		if err := ical.ValidateUID(parsedEmail.Event.UID); err != nil {
-			return fmt.Sprintf("Invalid UID for calendar event: %v", err), err
-		}
-		// Check if this is a METHOD:REQUEST or METHOD:CANCEL
-		if parsedEmail.Event.Method == "REQUEST" {
-			return p.processEventRequest(parsedEmail)
-		} else if parsedEmail.Event.Method == "CANCEL" {
-			return p.processEventCancelation(parsedEmail)
-		} else if parsedEmail.Event.Method == "REPLY" {
-			return p.processEventReply(parsedEmail)
+		p.log = p.log.With("uid", parsedEmail.Event.UID, "method", parsedEmail.Event.Method)
+		p.log.Debug("Processing calendar email", "component", parsedEmail.Event.Component)
+		p.countStats(func(s *Stats) { s.WithCalendar++ })
+
+		msg, err := p.processCalendarEmail(parsedEmail)
+		err = classifyError(err)
+		p.countStats(func(s *Stats) { s.Errors.Record(err) })
+		if err != nil {
+			p.log.Error("Processing calendar email failed", "result", msg, "error", err)
 		} else {
-			return p.processEvent(parsedEmail)
+			p.log.Debug("Processed calendar email", "result", msg)
 		}
+		return msg, err
 	} else {
+		p.log.Debug("Processed email without calendar event")
 		return "Processed E-Mail without calendar event", nil
 	}
 }
 
+// processCalendarEmail handles an email with calendar data by its method
+func (p *Processor) processCalendarEmail(parsedEmail *email.Email) (string, error) {
+	// Validate the UID before processing
+	if err := ical.ValidateUID(parsedEmail.Event.UID); err != nil {
+		return fmt.Sprintf("Invalid UID for calendar event: %v", err), err
+	}
+	// Check if this is a METHOD:REQUEST or METHOD:CANCEL
+	if parsedEmail.Event.Method == "REQUEST" {
+		return p.processEventRequest(parsedEmail)
+	} else if parsedEmail.Event.Method == "CANCEL" {
+		return p.processEventCancelation(parsedEmail)
+	} else if parsedEmail.Event.Method == "REPLY" {
+		return p.processEventReply(parsedEmail)
+	} else {
+		return p.processEvent(parsedEmail)
+	}
+}
+
 func (p *Processor) processEvent(parsedEmail *email.Email) (string, error) {
 	// First, validate the event by testing decode and encode
 	if err := ical.ValidateEvent(parsedEmail.Event.RawData); err != nil {
@@ -69,33 +293,19 @@ func (p *Processor) processEvent(parsedEmail *email.Email) (string, error) {
 	// We handle instance updates differently from parent event updates
 	isInstanceUpdate := parsedEmail.Event.IsRecurringUpdate()
 
-	// Check for existing event with the same UID
+	// Check for existing event with the same UID. Only an event that is
+	// known to be missing is stored as new, a failed lookup is retried.
 	existingEvent, err := p.Storage.GetEvent(parsedEmail.Event.UID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return "Error getting stored event", markError(ErrTemporary, fmt.Errorf("getting event: %w", err))
+	}
 	if err == nil && existingEvent != nil {
 		// If this is an instance update, we always process it regardless of parent sequence
 		if isInstanceUpdate {
-			// Handle recurring instance update
-			updatedEvent, err := p.handleRecurringEvent(existingEvent, parsedEmail.Event)
-			if err != nil {
-				return "Error handling recurring instance", fmt.Errorf("handling recurring instance: %w", err)
-			}
-
-			// Validate the updated event
-			if err := ical.ValidateEvent(updatedEvent.RawData); err != nil {
-				return fmt.Sprintf("Invalid calendar data after instance update for event with UID %s", updatedEvent.UID),
-					fmt.Errorf("validation error after instance update for event %s: %w", updatedEvent.UID, err)
-			}
-
-			// Prepare and store the updated event
-			preparedEvent, err := prepareEventForStorage(updatedEvent)
-			if err != nil {
-				return "Error preparing event for storage", fmt.Errorf("preparing event: %w", err)
-			}
-			if err := p.Storage.StoreEvent(preparedEvent); err != nil {
-				return "Error storing updated event", fmt.Errorf("storing updated event: %w", err)
+			if parsedEmail.Event.IsThisAndFutureUpdate() {
+				return p.processThisAndFutureUpdate(existingEvent, parsedEmail.Event)
 			}
-
-			return fmt.Sprintf("Updated recurring event instance with UID %s", parsedEmail.Event.UID), nil
+			return p.storeRecurringInstance(existingEvent, parsedEmail.Event)
 		} else {
 			// This is a parent event update, not an instance update
 			// Check if the existing event is also a parent or an instance
@@ -136,6 +346,7 @@ func (p *Processor) processEvent(parsedEmail *email.Email) (string, error) {
 			
 			// Only update if the new event is newer or equal to the existing one
 			if comparison == ical.SecondEventNewer {
+				p.countStats(func(s *Stats) { s.IgnoredOlder++ })
 				return fmt.Sprintf("Not processing older event (sequence: %d vs %d, DTSTAMP comparison) with UID %s",
 					parsedEmail.Event.Sequence, existingEvent.Sequence,
 					parsedEmail.Event.UID), nil
@@ -162,13 +373,43 @@ func (p *Processor) processEvent(parsedEmail *email.Email) (string, error) {
 					return "Error storing updated event", fmt.Errorf("storing updated event: %w", err)
 				}
 
+				// Replies are left parked if storing them failed before
+				applied, err := p.applyPendingReplies(preparedEvent)
+				if err != nil {
+					return "Error applying pending replies", fmt.Errorf("applying pending replies: %w", err)
+				}
+				if applied > 0 {
+					return fmt.Sprintf("Updated event with UID %s, new sequence: %d (applied %d pending replies)",
+						parsedEmail.Event.UID, parsedEmail.Event.Sequence, applied), nil
+				}
+
 				return fmt.Sprintf("Updated event with UID %s, new sequence: %d",
 					parsedEmail.Event.UID, parsedEmail.Event.Sequence), nil
 			}
 		}
 	} else {
+		// Check new invitations against the calendar before storing them
+		newEvent := parsedEmail.Event
+		var conflicts []Conflict
+		if parsedEmail.Event.Method == "REQUEST" && (p.Conflicts == ConflictsReport || p.Conflicts == ConflictsTag) {
+			newCal, err := ical.DecodeCalendar(newEvent.RawData)
+			if err != nil {
+				return "Error checking for conflicts", fmt.Errorf("parsing event data: %w", err)
+			}
+			conflicts, err = p.findConflicts(newCal, newEvent.UID)
+			if err != nil {
+				return "Error checking for conflicts", fmt.Errorf("checking for conflicts: %w", err)
+			}
+			if len(conflicts) > 0 && p.Conflicts == ConflictsTag {
+				newEvent, err = tagConflicts(newEvent, conflicts)
+				if err != nil {
+					return "Error tagging conflicts", fmt.Errorf("tagging conflicts: %w", err)
+				}
+			}
+		}
+
 		// No existing event found, prepare and store the new one
-		preparedEvent, err := prepareEventForStorage(parsedEmail.Event)
+		preparedEvent, err := prepareEventForStorage(newEvent)
 		if err != nil {
 			return "Error preparing event for storage", fmt.Errorf("preparing event: %w", err)
 		}
@@ -176,13 +417,125 @@ func (p *Processor) processEvent(parsedEmail *email.Email) (string, error) {
 			return "Error storing new event", fmt.Errorf("storing event: %w", err)
 		}
 
-		return fmt.Sprintf("Stored new event with UID %s", parsedEmail.Event.UID), nil
+		conflictNote := ""
+		if len(conflicts) > 0 {
+			conflictNote = " (" + describeConflicts(conflicts) + ")"
+		}
+
+		applied, err := p.applyPendingReplies(preparedEvent)
+		if err != nil {
+			return "Error applying pending replies", fmt.Errorf("applying pending replies: %w", err)
+		}
+		if applied > 0 {
+			return fmt.Sprintf("Stored new event with UID %s (applied %d pending replies)%s",
+				parsedEmail.Event.UID, applied, conflictNote), nil
+		}
+
+		return fmt.Sprintf("Stored new event with UID %s%s", parsedEmail.Event.UID, conflictNote), nil
+	}
+}
+
+// applyPendingReplies applies the replies parked for a freshly stored event
+// and returns how many of them updated an attendee. The replies are only
+// removed once the event is stored with them.
+func (p *Processor) applyPendingReplies(storedEvent *ical.Event) (int, error) {
+	if p.PendingReplies == nil {
+		return 0, nil
+	}
+
+	replies, err := p.PendingReplies.Replies(storedEvent.UID)
+	if err != nil {
+		return 0, markError(ErrTemporary, err)
+	}
+	if len(replies) == 0 {
+		return 0, nil
+	}
+
+	applied := 0
+	for _, reply := range replies {
+		// Replies that don't match an attendee of the event are dropped,
+		// just like they would be if the event had been there first
+		if err := p.updateAttendeeStatus(reply, storedEvent); err != nil {
+			p.logger().Warn("Dropping pending reply that does not apply", "error", err)
+			continue
+		}
+		applied++
+	}
+
+	if applied > 0 {
+		if err := p.Storage.StoreEvent(storedEvent); err != nil {
+			return 0, fmt.Errorf("storing event with pending replies: %w", err)
+		}
+		p.countStats(func(s *Stats) { s.RepliesApplied += applied })
+	}
+
+	if err := p.PendingReplies.RemoveReplies(storedEvent.UID, len(replies)); err != nil {
+		return applied, markError(ErrTemporary, err)
 	}
+	return applied, nil
+}
+
+// storeRecurringInstance merges a recurring instance update into the stored
+// event and stores the result
+func (p *Processor) storeRecurringInstance(existingEvent, newEvent *ical.Event) (string, error) {
+	// Handle recurring instance update
+	updatedEvent, err := p.handleRecurringEvent(existingEvent, newEvent)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("handling recurring instance: %w", err)
+	}
+
+	// Validate the updated event
+	if err := ical.ValidateEvent(updatedEvent.RawData); err != nil {
+		return fmt.Sprintf("Invalid calendar data after instance update for event with UID %s", updatedEvent.UID),
+			fmt.Errorf("validation error after instance update for event %s: %w", updatedEvent.UID, err)
+	}
+
+	// Prepare and store the updated event
+	preparedEvent, err := prepareEventForStorage(updatedEvent)
+	if err != nil {
+		return "Error preparing event for storage", fmt.Errorf("preparing event: %w", err)
+	}
+	if err := p.Storage.StoreEvent(preparedEvent); err != nil {
+		return "Error storing updated event", fmt.Errorf("storing updated event: %w", err)
+	}
+
+	return fmt.Sprintf("Updated recurring event instance with UID %s", newEvent.UID), nil
+}
+
+// storeUpdatedEvent validates, prepares and stores an event that was
+// modified by the processor and returns msg on success
+func (p *Processor) storeUpdatedEvent(updatedEvent *ical.Event, msg string) (string, error) {
+	if err := ical.ValidateEvent(updatedEvent.RawData); err != nil {
+		return fmt.Sprintf("Invalid calendar data after update for event with UID %s", updatedEvent.UID),
+			fmt.Errorf("validation error after update for event %s: %w", updatedEvent.UID, err)
+	}
+
+	preparedEvent, err := prepareEventForStorage(updatedEvent)
+	if err != nil {
+		return "Error preparing event for storage", fmt.Errorf("preparing event: %w", err)
+	}
+	if err := p.Storage.StoreEvent(preparedEvent); err != nil {
+		return "Error storing updated event", fmt.Errorf("storing updated event: %w", err)
+	}
+
+	return msg, nil
 }
 
 // processEventRequest handles calendar events with METHOD:REQUEST
 func (p *Processor) processEventRequest(parsedEmail *email.Email) (string, error) {
-	return p.processEvent(parsedEmail)
+	msg, err := p.processEvent(parsedEmail)
+	if err != nil {
+		return msg, err
+	}
+
+	accepted, err := p.autoAccept(parsedEmail.Event)
+	if err != nil {
+		return msg + " (auto-accept failed)", fmt.Errorf("auto-accepting event: %w", err)
+	}
+	if accepted {
+		msg += " (auto-accepted)"
+	}
+	return msg, nil
 }
 
 // processEventCancelation handles calendar events with METHOD:CANCEL
@@ -194,6 +547,7 @@ func (p *Processor) processEventCancelation(parsedEmail *email.Email) (string, e
 func (p *Processor) processEventReply(parsedEmail *email.Email) (string, error) {
 	if !p.ProcessReplies {
 		// Skip storing REPLY events when ProcessReplies is false
+		p.countStats(func(s *Stats) { s.RepliesIgnored++ })
 		return "Ignoring calendar REPLY method as configured", nil
 	}
 
@@ -203,12 +557,16 @@ func (p *Processor) processEventReply(parsedEmail *email.Email) (string, error)
 			fmt.Errorf("validation error for event reply %s: %w", parsedEmail.Event.UID, err)
 	}
 
-	// Try to find the existing event to update attendee status
+	// Try to find the existing event to update attendee status. Replies are
+	// only orphaned if the event is known to be missing.
 	existingEvent, err := p.Storage.GetEvent(parsedEmail.Event.UID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return "Error getting stored event", markError(ErrTemporary, fmt.Errorf("getting event: %w", err))
+	}
 	if err == nil && existingEvent != nil {
 		// Process the reply to update attendee status
 		if err := p.updateAttendeeStatus(parsedEmail.Event, existingEvent); err != nil {
-			fmt.Printf("Warning: Failed to update attendee status: %v\n", err)
+			p.logger().Warn("Failed to update attendee status, storing the reply as is", "error", err)
 
 			// If attendee update fails, prepare and store the event normally
 			preparedEvent, err := prepareEventForStorage(parsedEmail.Event)
@@ -236,12 +594,29 @@ func (p *Processor) processEventReply(parsedEmail *email.Email) (string, error)
 			if err := p.Storage.StoreEvent(preparedEvent); err != nil {
 				return "Error storing updated event with attendee status", fmt.Errorf("storing updated event: %w", err)
 			}
+			p.countStats(func(s *Stats) { s.RepliesApplied++ })
 
 			return fmt.Sprintf("Updated attendee status for event with UID %s",
 				parsedEmail.Event.UID), nil
 		}
 	} else {
-		// No existing event found, prepare and store the new one
+		// No existing event found, handle the orphan reply as configured
+		switch p.OrphanReplies {
+		case "", OrphanRepliesDrop:
+			p.countStats(func(s *Stats) { s.RepliesIgnored++ })
+			return fmt.Sprintf("Skipped reply for unknown event with UID %s", parsedEmail.Event.UID), nil
+		case OrphanRepliesPending:
+			if p.PendingReplies == nil {
+				return "Cannot park reply without a pending reply store",
+					markError(ErrTemporary, fmt.Errorf("no pending reply store configured"))
+			}
+			if err := p.PendingReplies.AddReply(parsedEmail.Event); err != nil {
+				return "Error parking pending reply", markError(ErrTemporary, fmt.Errorf("parking reply: %w", err))
+			}
+			return fmt.Sprintf("Parked reply for unknown event with UID %s until the event arrives",
+				parsedEmail.Event.UID), nil
+		}
+
 		preparedEvent, err := prepareEventForStorage(parsedEmail.Event)
 		if err != nil {
 			return "Error preparing event for storage", fmt.Errorf("preparing event: %w", err)
@@ -271,7 +646,7 @@ func (p *Processor) handleRecurringEvent(existingEvent, newEvent *ical.Event) (*
 	// Find the VEVENT component with RECURRENCE-ID
 	var recurrenceEvent *goical.Component
 	for _, component := range newCal.Children {
-		if component.Name != "VEVENT" {
+		if !ical.IsObjectComponent(component.Name) {
 			continue
 		}
 
@@ -298,16 +673,26 @@ func (p *Processor) handleRecurringEvent(existingEvent, newEvent *ical.Event) (*
 		return nil, fmt.Errorf("missing RECURRENCE-ID in event update")
 	}
 
+	// Cancelled instances can be excluded from the series instead, as some
+	// clients still display exceptions with STATUS:CANCELLED
+	excluded := false
+	if newEvent.Method == "CANCEL" && p.CancelInstancesAsExdate {
+		excluded = excludeInstance(existingCal, recurrenceID, newCal)
+	}
+
 	// Find if this specific occurrence already exists in the calendar
-	foundExisting := false
+	foundExisting := excluded
 	for i, component := range existingCal.Children {
-		if component.Name != "VEVENT" {
+		if excluded {
+			break
+		}
+		if !ical.IsObjectComponent(component.Name) {
 			continue
 		}
 
 		// Check if this is the same occurrence by matching RECURRENCE-ID
 		existingRecurrenceID := component.Props.Get("RECURRENCE-ID")
-		if existingRecurrenceID != nil && existingRecurrenceID.Value == recurrenceID.Value {
+		if existingRecurrenceID != nil && ical.SameRecurrenceID(existingRecurrenceID, existingCal, recurrenceID, newCal) {
 			// Found the existing occurrence to update
 			foundExisting = true
 
@@ -316,7 +701,10 @@ func (p *Processor) handleRecurringEvent(existingEvent, newEvent *ical.Event) (*
 				// For cancellations, we update the status to CANCELLED
 				component.Props.Set(&goical.Prop{Name: "STATUS", Value: "CANCELLED"})
 			} else {
-				// Replace the existing occurrence with the new one
+				// Replace the existing occurrence with the new one, keeping
+				// what the user changed locally
+				p.keepSelfPartstat(component, existingCal, recurrenceEvent, newCal)
+				p.Merge.Apply(component, recurrenceEvent)
 				existingCal.Children[i] = recurrenceEvent
 			}
 			break
@@ -337,7 +725,7 @@ func (p *Processor) handleRecurringEvent(existingEvent, newEvent *ical.Event) (*
 
 	// Ensure all components have DTSTAMP
 	for _, component := range existingCal.Children {
-		if component.Name != "VEVENT" {
+		if !ical.IsObjectComponent(component.Name) {
 			continue
 		}
 
@@ -347,6 +735,10 @@ func (p *Processor) handleRecurringEvent(existingEvent, newEvent *ical.Event) (*
 		}
 	}
 
+	// The occurrence may use time zones the stored calendar doesn't define yet
+	ical.MergeTimezones(existingCal, newCal)
+	ical.EnsureTimezones(existingCal)
+
 	// Encode the updated calendar back to bytes
 	calBytes, err := ical.EncodeCalendar(existingCal)
 	if err != nil {
@@ -388,13 +780,13 @@ func (p *Processor) updateAttendeeStatus(event *ical.Event, storageEvent *ical.E
 	// Extract the replying attendee from the new data
 	var replyEvent *goical.Component
 	for _, component := range newCal.Children {
-		if component.Name == "VEVENT" {
+		if ical.IsObjectComponent(component.Name) {
 			replyEvent = component
 			break
 		}
 	}
 	if replyEvent == nil {
-		return fmt.Errorf("no VEVENT component found in reply")
+		return fmt.Errorf("no calendar object component found in reply")
 	}
 
 	// Find attendee in the reply
@@ -416,12 +808,12 @@ func (p *Processor) updateAttendeeStatus(event *ical.Event, storageEvent *ical.E
 	// Update the attendee status in the existing event
 	updated := false
 	for _, component := range existingCal.Children {
-		if component.Name != "VEVENT" {
+		if !ical.IsObjectComponent(component.Name) {
 			continue
 		}
 
 		// Check if this is the same instance (RECURRENCE-ID matching if present)
-		if !matchesRecurrenceID(replyEvent, component) {
+		if !matchesRecurrenceID(replyEvent, newCal, component, existingCal) {
 			continue
 		}
 
@@ -478,7 +870,7 @@ func (p *Processor) handleParentEventUpdate(existingEvent, newEvent *ical.Event)
 	// Find the parent/master event component in the new calendar
 	var newParentComponent *goical.Component
 	for _, component := range newCal.Children {
-		if component.Name != "VEVENT" {
+		if !ical.IsObjectComponent(component.Name) {
 			continue
 		}
 		
@@ -495,9 +887,10 @@ func (p *Processor) handleParentEventUpdate(existingEvent, newEvent *ical.Event)
 
 	// Extract all existing instance exceptions
 	var existingInstanceComponents []*goical.Component
+	var existingParentComponent *goical.Component
 
 	for _, component := range existingCal.Children {
-		if component.Name != "VEVENT" {
+		if !ical.IsObjectComponent(component.Name) {
 			continue
 		}
 
@@ -505,9 +898,21 @@ func (p *Processor) handleParentEventUpdate(existingEvent, newEvent *ical.Event)
 		if component.Props.Get("RECURRENCE-ID") != nil {
 			// This is an instance exception, preserve it
 			existingInstanceComponents = append(existingInstanceComponents, component)
+		} else if existingParentComponent == nil {
+			existingParentComponent = component
 		}
 	}
 
+	// Exceptions that the new master no longer produces would show up as
+	// phantom occurrences
+	existingInstanceComponents = p.pruneExceptions(existingInstanceComponents, existingCal,
+		existingParentComponent, newParentComponent, newCal)
+
+	// Keep what the user changed locally, such as alarms and categories,
+	// and the own participation status unless the event changed significantly
+	p.keepSelfPartstat(existingParentComponent, existingCal, newParentComponent, newCal)
+	p.Merge.Apply(existingParentComponent, newParentComponent)
+
 	// Create a new calendar with updated parent event and preserved instances
 	updatedCal := goical.NewCalendar()
 	
@@ -522,6 +927,11 @@ func (p *Processor) handleParentEventUpdate(existingEvent, newEvent *ical.Event)
 	// Add all preserved instance exceptions
 	updatedCal.Children = append(updatedCal.Children, existingInstanceComponents...)
 
+	// Keep the time zone definitions of both versions, the preserved
+	// exceptions may still refer to zones only the stored data defines
+	ical.MergeTimezones(updatedCal, newCal, existingCal)
+	ical.EnsureTimezones(updatedCal)
+
 	// Encode the updated calendar back to bytes
 	calBytes, err := ical.EncodeCalendar(updatedCal)
 	if err != nil {
@@ -540,15 +950,158 @@ func (p *Processor) handleParentEventUpdate(existingEvent, newEvent *ical.Event)
 	return updatedEvent, nil
 }
 
+// excludeInstance adds an EXDATE for the occurrence to the master of a
+// calendar and removes any exception for it. It returns false if there is no
+// master to exclude the occurrence from.
+func excludeInstance(cal *goical.Calendar, recurrenceID *goical.Prop, idCal *goical.Calendar) bool {
+	var master *goical.Component
+	for _, component := range cal.Children {
+		if ical.IsObjectComponent(component.Name) && component.Props.Get("RECURRENCE-ID") == nil {
+			master = component
+			break
+		}
+	}
+	if master == nil {
+		return false
+	}
+
+	occurrence, err := ical.ParseDateTime(recurrenceID, idCal)
+	if err != nil {
+		return false
+	}
+
+	// Write the EXDATE in UTC or as DATE so it doesn't depend on a
+	// VTIMEZONE that may only exist in the cancellation
+	exdate := &goical.Prop{Name: "EXDATE", Params: goical.Params{}}
+	if ical.IsDateValue(master.Props.Get("DTSTART")) {
+		exdate.Params.Set("VALUE", "DATE")
+		exdate.Value = occurrence.Format("20060102")
+	} else {
+		exdate.Value = occurrence.UTC().Format("20060102T150405Z")
+	}
+
+	alreadyExcluded := false
+	for _, existing := range master.Props.Values("EXDATE") {
+		if ical.SameRecurrenceID(&existing, cal, exdate, cal) {
+			alreadyExcluded = true
+			break
+		}
+	}
+	if !alreadyExcluded {
+		master.Props.Add(exdate)
+	}
+
+	children := cal.Children[:0]
+	for _, component := range cal.Children {
+		existingRecurrenceID := component.Props.Get("RECURRENCE-ID")
+		if ical.IsObjectComponent(component.Name) && existingRecurrenceID != nil &&
+			ical.SameRecurrenceID(existingRecurrenceID, cal, recurrenceID, idCal) {
+			continue
+		}
+		children = append(children, component)
+	}
+	cal.Children = children
+
+	return true
+}
+
+// pruneExceptions applies the OrphanedExceptions policy to the exceptions
+// of a series whose master is replaced. An exception is orphaned if its
+// RECURRENCE-ID is not an occurrence of the new master, or if the organizer
+// reset the series (changed DTSTART or RRULE) and the exception has a lower
+// SEQUENCE than the new master.
+func (p *Processor) pruneExceptions(exceptions []*goical.Component, existingCal *goical.Calendar,
+	oldMaster, newMaster *goical.Component, newCal *goical.Calendar) []*goical.Component {
+	if p.OrphanedExceptions == "" || p.OrphanedExceptions == OrphanedExceptionsKeep || len(exceptions) == 0 {
+		return exceptions
+	}
+
+	reset := oldMaster != nil && seriesReset(oldMaster, existingCal, newMaster, newCal)
+	newSequence := componentSequence(newMaster)
+
+	var kept []*goical.Component
+	for _, exception := range exceptions {
+		orphaned := false
+
+		isOccurrence, err := ical.IsOccurrence(newMaster, newCal, exception.Props.Get("RECURRENCE-ID"), existingCal)
+		if err == nil && !isOccurrence {
+			orphaned = true
+		}
+		if reset && componentSequence(exception) < newSequence {
+			orphaned = true
+		}
+
+		if !orphaned {
+			kept = append(kept, exception)
+		} else if p.OrphanedExceptions == OrphanedExceptionsFlag {
+			exception.Props.Set(&goical.Prop{Name: "X-CALMAILPROC-ORPHANED", Value: "TRUE"})
+			kept = append(kept, exception)
+		}
+	}
+
+	return kept
+}
+
+// seriesReset reports whether the organizer changed the start or the
+// pattern of the recurrence rule of a series. A changed COUNT or UNTIL only
+// shortens or extends the series, exceptions beyond its end are no longer
+// occurrences and are found without a reset.
+func seriesReset(oldMaster *goical.Component, oldCal *goical.Calendar, newMaster *goical.Component, newCal *goical.Calendar) bool {
+	if recurrencePattern(oldMaster) != recurrencePattern(newMaster) {
+		return true
+	}
+
+	oldStart, err1 := ical.ParseDateTime(oldMaster.Props.Get("DTSTART"), oldCal)
+	newStart, err2 := ical.ParseDateTime(newMaster.Props.Get("DTSTART"), newCal)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return !oldStart.Equal(newStart)
+}
+
+// recurrencePattern returns the RRULE of a master in a canonical form
+// without COUNT and UNTIL, empty if the master does not recur
+func recurrencePattern(master *goical.Component) string {
+	roption, err := master.Props.RecurrenceRule()
+	if err != nil {
+		// Rules we can't parse are only compared by their text
+		return master.Props.Get("RRULE").Value
+	}
+	if roption == nil {
+		return ""
+	}
+
+	roption.Count = 0
+	roption.Until = time.Time{}
+	if roption.Interval == 0 {
+		roption.Interval = 1
+	}
+	return roption.RRuleString()
+}
+
+// componentSequence returns the SEQUENCE of a component, 0 if it has none
+func componentSequence(component *goical.Component) int {
+	prop := component.Props.Get("SEQUENCE")
+	if prop == nil {
+		return 0
+	}
+	var seq int
+	if _, err := fmt.Sscanf(prop.Value, "%d", &seq); err != nil {
+		return 0
+	}
+	return seq
+}
+
 // matchesRecurrenceID checks if two events refer to the same instance
-// by comparing their RECURRENCE-ID properties
-func matchesRecurrenceID(event1, event2 *goical.Component) bool {
+// by comparing their RECURRENCE-ID properties as instants. The calendars
+// the events belong to are needed to resolve TZIDs.
+func matchesRecurrenceID(event1 *goical.Component, cal1 *goical.Calendar, event2 *goical.Component, cal2 *goical.Calendar) bool {
 	recurrenceID1 := event1.Props.Get("RECURRENCE-ID")
 	recurrenceID2 := event2.Props.Get("RECURRENCE-ID")
 
 	// If both have RECURRENCE-ID, they must match
 	if recurrenceID1 != nil && recurrenceID2 != nil {
-		return recurrenceID1.Value == recurrenceID2.Value
+		return ical.SameRecurrenceID(recurrenceID1, cal1, recurrenceID2, cal2)
 	}
 
 	// If neither has RECURRENCE-ID, they refer to the master event
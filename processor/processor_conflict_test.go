@@ -0,0 +1,115 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const conflictingEvents = `BEGIN:VEVENT
+SUMMARY:Standup
+DTSTART:20250303T090000Z
+DTEND:20250303T093000Z
+UID:conflict-standup
+RRULE:FREQ=DAILY;COUNT=5
+DTSTAMP:20250301T000000Z
+END:VEVENT`
+
+const freeEvent = `BEGIN:VEVENT
+SUMMARY:Focus time
+DTSTART:20250305T140000Z
+DTEND:20250305T160000Z
+UID:conflict-focus
+TRANSP:TRANSPARENT
+DTSTAMP:20250301T000000Z
+END:VEVENT`
+
+func conflictInvitation(uid, start, end string) string {
+	return calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Review
+DTSTART:`+start+`
+DTEND:`+end+`
+UID:`+uid+`
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250302T000000Z
+END:VEVENT`)
+}
+
+func newConflictProcessor(t *testing.T, store *storage.MemoryStorage, policy string) *Processor {
+	t.Helper()
+
+	proc, err := NewProcessorFromConfig(store, ProcessorConfig{ProcessReplies: true, Conflicts: policy})
+	if err != nil {
+		t.Fatalf("Failed to create processor: %v", err)
+	}
+	processAll(t, proc, calendarEmail("REQUEST", conflictingEvents), calendarEmail("REQUEST", freeEvent))
+	return proc
+}
+
+func TestConflicts_Report(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := newConflictProcessor(t, store, ConflictsReport)
+
+	// Overlaps the standup on Wednesday
+	msg, err := proc.ProcessEmail(strings.NewReader(
+		conflictInvitation("conflict-review", "20250305T091500Z", "20250305T100000Z")))
+	if err != nil {
+		t.Fatalf("Failed to process invitation: %v", err)
+	}
+	if !strings.Contains(msg, "conflicts with 1 events") || !strings.Contains(msg, "Standup") {
+		t.Errorf("Expected the standup conflict to be reported, got: %s", msg)
+	}
+
+	// Only overlaps the transparent focus time
+	msg, err = proc.ProcessEmail(strings.NewReader(
+		conflictInvitation("conflict-free", "20250305T143000Z", "20250305T153000Z")))
+	if err != nil {
+		t.Fatalf("Failed to process invitation: %v", err)
+	}
+	if strings.Contains(msg, "conflicts") {
+		t.Errorf("Expected no conflict with a transparent event, got: %s", msg)
+	}
+
+	master := storedMaster(t, store, "conflict-review")
+	if master.Props.Get(ConflictProperty) != nil {
+		t.Error("Expected no conflict tag with the report policy")
+	}
+}
+
+func TestConflicts_Tag(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := newConflictProcessor(t, store, ConflictsTag)
+
+	processAll(t, proc, conflictInvitation("conflict-review", "20250307T090000Z", "20250307T100000Z"))
+
+	master := storedMaster(t, store, "conflict-review")
+	if tag := master.Props.Get(ConflictProperty); tag == nil || tag.Value != "conflict-standup" {
+		t.Errorf("Expected %s:conflict-standup, got %v", ConflictProperty, tag)
+	}
+
+	// After the last standup there is nothing to conflict with
+	processAll(t, proc, conflictInvitation("conflict-later", "20250308T090000Z", "20250308T100000Z"))
+	if tag := storedMaster(t, store, "conflict-later").Props.Get(ConflictProperty); tag != nil {
+		t.Errorf("Expected no conflict tag, got %v", tag)
+	}
+}
+
+func TestConflicts_BlockAutoAccept(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc, sender := newReplyingProcessor(t, store, "organizer@example.com")
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", conflictingEvents),
+		conflictInvitation("conflict-review", "20250304T090000Z", "20250304T100000Z"),
+		conflictInvitation("conflict-free", "20250304T110000Z", "20250304T120000Z"),
+	)
+
+	if len(sender.messages) != 1 {
+		t.Fatalf("Expected only the free invitation to be accepted, got %d replies", len(sender.messages))
+	}
+	if reply := sentReply(t, sender.messages[0]); reply.Event.UID != "conflict-free" {
+		t.Errorf("Expected conflict-free to be accepted, got %s", reply.Event.UID)
+	}
+}
@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func TestErrorCategories(t *testing.T) {
+	email := selfPartstatEmail(0, "NEEDS-ACTION", "Room 1")
+
+	proc := NewProcessor(failingStorage{storage.NewMemoryStorage()}, true)
+	_, err := proc.ProcessEmail(strings.NewReader(email))
+	if !errors.Is(err, ErrTemporary) || errors.Is(err, ErrInvalidData) {
+		t.Errorf("Expected a storage failure to be temporary, got %v", err)
+	}
+
+	// The event is stored when the journal fails, delivering the email
+	// again must be possible
+	j, err := journal.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemoryStorage()
+	proc = NewProcessor(store, true)
+	proc.EnableJournal(j)
+	_, err = proc.ProcessEmail(strings.NewReader(email))
+	if !errors.Is(err, ErrTemporary) || errors.Is(err, ErrInvalidData) {
+		t.Errorf("Expected a journal failure to be temporary, got %v", err)
+	}
+	if store.GetEventCount() != 1 {
+		t.Errorf("Expected the event to be stored, got %d events", store.GetEventCount())
+	}
+
+	proc = NewProcessor(storage.NewMemoryStorage(), true)
+	for _, name := range []string{"test-12.eml", "test-13.eml"} {
+		data, err := os.ReadFile(filepath.Join("..", "test", "maildir", "cur", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = proc.ProcessEmail(strings.NewReader(string(data)))
+		if !errors.Is(err, ErrInvalidData) || errors.Is(err, ErrTemporary) {
+			t.Errorf("%s: expected invalid data, got %v", name, err)
+		}
+	}
+
+	msg, err := proc.ProcessEmail(strings.NewReader("Subject: Lunch?\n\nNo calendar here.\n"))
+	if err != nil {
+		t.Errorf("Expected no error for an email without calendar data, got %v (%s)", err, msg)
+	}
+}
+
+// unreachableStorage can't look up events, like a CalDAV server that is down
+type unreachableStorage struct {
+	*storage.MemoryStorage
+}
+
+func (unreachableStorage) GetEvent(string) (*ical.Event, error) {
+	return nil, fmt.Errorf("connection refused: %w", storage.ErrUnavailable)
+}
+
+func TestLookupFailureIsTemporary(t *testing.T) {
+	tests := []struct {
+		name          string
+		orphanReplies string
+		email         string
+	}{
+		{"reply with drop", OrphanRepliesDrop, orphanReplyEmail},
+		{"reply with pending", OrphanRepliesPending, orphanReplyEmail},
+		{"reply with store", OrphanRepliesStore, orphanReplyEmail},
+		{"request", "", orphanReplyRequestEmail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			pending := NewMemoryPendingReplyStore()
+			proc := NewProcessor(unreachableStorage{store}, true)
+			proc.OrphanReplies = tt.orphanReplies
+			proc.PendingReplies = pending
+
+			msg, err := proc.ProcessEmail(strings.NewReader(tt.email))
+			if !errors.Is(err, ErrTemporary) {
+				t.Errorf("Expected a temporary error, got %v (%s)", err, msg)
+			}
+			if store.GetEventCount() != 0 {
+				t.Errorf("Expected nothing to be stored, got %d events", store.GetEventCount())
+			}
+			if replies, _ := pending.Replies("orphan-reply-event"); len(replies) != 0 {
+				t.Errorf("Expected no parked reply, got %d", len(replies))
+			}
+		})
+	}
+}
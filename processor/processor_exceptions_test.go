@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const prunedSeries = `BEGIN:VEVENT
+SUMMARY:Team meeting
+DTSTART:20250106T100000Z
+DTEND:20250106T110000Z
+UID:pruned-event
+SEQUENCE:1
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250101T000000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Team meeting (week 2)
+DTSTART:20250113T140000Z
+DTEND:20250113T150000Z
+UID:pruned-event
+SEQUENCE:1
+RECURRENCE-ID:20250113T100000Z
+DTSTAMP:20250101T000000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Team meeting (week 4)
+DTSTART:20250127T140000Z
+DTEND:20250127T150000Z
+UID:pruned-event
+SEQUENCE:1
+RECURRENCE-ID:20250127T100000Z
+DTSTAMP:20250101T000000Z
+END:VEVENT`
+
+// processPrunedSeries stores the series and applies a master update with
+// the given SEQUENCE and RRULE
+func processPrunedSeries(t *testing.T, proc *Processor, sequence, rrule string) {
+	t.Helper()
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", prunedSeries),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Team meeting
+DTSTART:20250106T100000Z
+DTEND:20250106T110000Z
+UID:pruned-event
+SEQUENCE:`+sequence+`
+RRULE:`+rrule+`
+DTSTAMP:20250105T000000Z
+END:VEVENT`),
+	)
+}
+
+func TestOrphanedExceptions_ShortenedSeries(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.OrphanedExceptions = OrphanedExceptionsDrop
+
+	processPrunedSeries(t, proc, "1", "FREQ=WEEKLY;COUNT=2")
+
+	_, exceptions := splitComponents(t, store, "pruned-event")
+	if _, ok := exceptions["20250113T100000Z"]; !ok {
+		t.Errorf("Expected exception within the shortened series to be kept")
+	}
+	if _, ok := exceptions["20250127T100000Z"]; ok {
+		t.Errorf("Expected exception after the end of the shortened series to be dropped")
+	}
+}
+
+func TestOrphanedExceptions_ChangedWeekday(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.OrphanedExceptions = OrphanedExceptionsDrop
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", prunedSeries),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Team meeting
+DTSTART:20250107T100000Z
+DTEND:20250107T110000Z
+UID:pruned-event
+SEQUENCE:1
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250105T000000Z
+END:VEVENT`),
+	)
+
+	if _, exceptions := splitComponents(t, store, "pruned-event"); len(exceptions) != 0 {
+		t.Errorf("Expected all Monday exceptions to be dropped after moving to Tuesday, got %d", len(exceptions))
+	}
+}
+
+func TestOrphanedExceptions_SeriesReset(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.OrphanedExceptions = OrphanedExceptionsDrop
+
+	// Both exceptions are still occurrences, but the organizer changed the
+	// rule with a higher SEQUENCE
+	processPrunedSeries(t, proc, "3", "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=8")
+
+	if _, exceptions := splitComponents(t, store, "pruned-event"); len(exceptions) != 0 {
+		t.Errorf("Expected exceptions with a lower SEQUENCE to be dropped on series reset, got %d", len(exceptions))
+	}
+}
+
+func TestOrphanedExceptions_SameRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rrule string
+	}{
+		{"reordered parts", "COUNT=4;FREQ=WEEKLY"},
+		{"explicit interval", "FREQ=WEEKLY;INTERVAL=1;COUNT=4"},
+		{"extended COUNT", "FREQ=WEEKLY;COUNT=8"},
+		{"COUNT replaced by UNTIL", "FREQ=WEEKLY;UNTIL=20250303T100000Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			proc := NewProcessor(store, true)
+			proc.OrphanedExceptions = OrphanedExceptionsDrop
+
+			processPrunedSeries(t, proc, "3", tt.rrule)
+
+			if _, exceptions := splitComponents(t, store, "pruned-event"); len(exceptions) != 2 {
+				t.Errorf("Expected exceptions to be kept when the rule keeps its pattern, got %d", len(exceptions))
+			}
+		})
+	}
+}
+
+func TestOrphanedExceptions_Flag(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.OrphanedExceptions = OrphanedExceptionsFlag
+
+	processPrunedSeries(t, proc, "1", "FREQ=WEEKLY;COUNT=2")
+
+	_, exceptions := splitComponents(t, store, "pruned-event")
+	if len(exceptions) != 2 {
+		t.Fatalf("Expected both exceptions to be kept when flagging, got %d", len(exceptions))
+	}
+	if exceptions["20250127T100000Z"].Props.Get("X-CALMAILPROC-ORPHANED") == nil {
+		t.Errorf("Expected orphaned exception to be flagged")
+	}
+	if exceptions["20250113T100000Z"].Props.Get("X-CALMAILPROC-ORPHANED") != nil {
+		t.Errorf("Expected valid exception not to be flagged")
+	}
+}
+
+func TestOrphanedExceptions_Keep(t *testing.T) {
+	for _, policy := range []string{OrphanedExceptionsKeep, ""} {
+		t.Run("policy "+policy, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			proc := NewProcessor(store, true)
+			proc.OrphanedExceptions = policy
+
+			processPrunedSeries(t, proc, "1", "FREQ=WEEKLY;COUNT=2")
+
+			event, err := store.GetEvent("pruned-event")
+			if err != nil {
+				t.Fatalf("Failed to retrieve event: %v", err)
+			}
+			if strings.Count(string(event.RawData), "RECURRENCE-ID") != 2 {
+				t.Errorf("Expected all exceptions to be kept")
+			}
+		})
+	}
+}
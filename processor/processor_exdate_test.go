@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const exdateSeries = `BEGIN:VEVENT
+SUMMARY:Standup
+DTSTART;TZID=Europe/Berlin:20250106T090000
+DTEND;TZID=Europe/Berlin:20250106T091500
+UID:exdate-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250101T000000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Standup (moved)
+DTSTART;TZID=Europe/Berlin:20250113T100000
+DTEND;TZID=Europe/Berlin:20250113T101500
+UID:exdate-event
+SEQUENCE:1
+RECURRENCE-ID;TZID=Europe/Berlin:20250113T090000
+DTSTAMP:20250102T000000Z
+END:VEVENT`
+
+// cancelExdateInstances cancels the modified occurrence on 2025-01-13 and the
+// plain occurrence on 2025-01-20
+func cancelExdateInstances(t *testing.T, proc *Processor) {
+	t.Helper()
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", exdateSeries),
+		calendarEmail("CANCEL", `BEGIN:VEVENT
+DTSTART:20250113T080000Z
+UID:exdate-event
+SEQUENCE:2
+RECURRENCE-ID:20250113T080000Z
+STATUS:CANCELLED
+DTSTAMP:20250103T000000Z
+END:VEVENT`),
+		calendarEmail("CANCEL", `BEGIN:VEVENT
+DTSTART;TZID=Europe/Berlin:20250120T090000
+UID:exdate-event
+SEQUENCE:2
+RECURRENCE-ID;TZID=Europe/Berlin:20250120T090000
+STATUS:CANCELLED
+DTSTAMP:20250103T000000Z
+END:VEVENT`),
+	)
+}
+
+func TestCancelInstance_Exdate(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.CancelInstancesAsExdate = true
+
+	cancelExdateInstances(t, proc)
+
+	master, exceptions := splitComponents(t, store, "exdate-event")
+	if len(exceptions) != 0 {
+		t.Errorf("Expected cancelled exceptions to be removed, got %d", len(exceptions))
+	}
+
+	var exdates []string
+	for _, exdate := range master.Props.Values("EXDATE") {
+		exdates = append(exdates, exdate.Value)
+	}
+	if len(exdates) != 2 || exdates[0] != "20250113T080000Z" || exdates[1] != "20250120T080000Z" {
+		t.Errorf("Expected EXDATEs for both cancelled occurrences, got %v", exdates)
+	}
+}
+
+func TestCancelInstance_StatusCancelledByDefault(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	cancelExdateInstances(t, proc)
+
+	master, exceptions := splitComponents(t, store, "exdate-event")
+	if master.Props.Get("EXDATE") != nil {
+		t.Errorf("Expected no EXDATE without the option")
+	}
+	if len(exceptions) != 2 {
+		t.Fatalf("Expected 2 cancelled exceptions, got %d", len(exceptions))
+	}
+	for id, exception := range exceptions {
+		if status := exception.Props.Get("STATUS"); status == nil || status.Value != "CANCELLED" {
+			t.Errorf("Expected exception %s to be cancelled", id)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/journal"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func TestJournal(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc, err := NewProcessorFromConfig(store, ProcessorConfig{
+		ProcessReplies: true,
+		JournalFile:    filepath.Join(t.TempDir(), "journal.jsonl"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create processor: %v", err)
+	}
+
+	emails := []struct {
+		messageID string
+		path      string
+		email     string
+	}{
+		{"<invite@example.com>", "/mail/cur/invite", selfPartstatEmail(0, "NEEDS-ACTION", "Room 1")},
+		{"<moved@example.com>", "", selfPartstatEmail(1, "NEEDS-ACTION", "Room 2")},
+	}
+	for _, e := range emails {
+		msg, err := proc.ProcessEmailFrom(strings.NewReader("Message-ID: "+e.messageID+"\n"+e.email), e.path)
+		if err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+		t.Logf("Result: %s", msg)
+	}
+
+	history, err := proc.Journal.History("self-partstat-event")
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 journal entries, got %d: %+v", len(history), history)
+	}
+
+	if history[0].Action != journal.ActionCreate || history[0].MessageID != "<invite@example.com>" ||
+		history[0].Path != "/mail/cur/invite" || history[0].BeforeHash != "" {
+		t.Errorf("Unexpected first entry: %+v", history[0])
+	}
+	if history[1].Action != journal.ActionUpdate || history[1].MessageID != "<moved@example.com>" ||
+		history[1].BeforeHash != history[0].AfterHash {
+		t.Errorf("Unexpected second entry: %+v", history[1])
+	}
+
+	event, err := store.GetEvent("self-partstat-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	if history[1].AfterHash != journal.Hash(event.RawData) {
+		t.Errorf("Expected the last entry to hash the stored data")
+	}
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// failingStorage fails to store anything, like an unreachable server
+type failingStorage struct {
+	*storage.MemoryStorage
+}
+
+func (failingStorage) StoreEvent(*ical.Event) error {
+	return fmt.Errorf("connection refused: %w", storage.ErrUnavailable)
+}
+
+// logRecords decodes the records of a JSON log
+func logRecords(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggingAttributes(t *testing.T) {
+	var out bytes.Buffer
+	proc := NewProcessor(storage.NewMemoryStorage(), true)
+	proc.Logger = slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	email := "Message-ID: <invite@example.com>\n" + selfPartstatEmail(0, "NEEDS-ACTION", "Room 1")
+	if _, err := proc.ProcessEmailFrom(strings.NewReader(email), "/mail/cur/invite"); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	records := logRecords(t, &out)
+	last := records[len(records)-1]
+	want := map[string]string{
+		"msg":        "Processed calendar email",
+		"uid":        "self-partstat-event",
+		"method":     "REQUEST",
+		"path":       "/mail/cur/invite",
+		"message_id": "<invite@example.com>",
+	}
+	for key, value := range want {
+		if last[key] != value {
+			t.Errorf("Expected %s=%q, got %v", key, value, last[key])
+		}
+	}
+
+	// Failures are logged as errors, the attributes of the previous email
+	// are gone
+	out.Reset()
+	proc.Storage = failingStorage{storage.NewMemoryStorage()}
+	proc.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+	if _, err := proc.ProcessEmail(strings.NewReader(selfPartstatEmail(0, "NEEDS-ACTION", "Room 1"))); err == nil {
+		t.Fatal("Expected an error from the storage")
+	}
+
+	records = logRecords(t, &out)
+	if len(records) != 1 {
+		t.Fatalf("Expected one record at info level, got %v", records)
+	}
+	if records[0]["level"] != "ERROR" || records[0]["uid"] != "self-partstat-event" || records[0]["error"] == nil {
+		t.Errorf("Unexpected record: %v", records[0])
+	}
+	if _, ok := records[0]["path"]; ok {
+		t.Errorf("Expected no path for an email without file, got %v", records[0])
+	}
+}
@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"testing"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const localPropertiesEvent = `BEGIN:VEVENT
+SUMMARY:Planning
+DTSTART:20250310T100000Z
+DTEND:20250310T110000Z
+UID:local-properties-event
+SEQUENCE:0
+ORGANIZER:mailto:organizer@example.com
+X-MICROSOFT-CDO-BUSYSTATUS:BUSY
+DTSTAMP:20250301T000000Z
+END:VEVENT`
+
+// storeLocallyEditedEvent stores an event as a calendar client would have
+// saved it after the user added an alarm, a category and other local changes
+func storeLocallyEditedEvent(t *testing.T, store *storage.MemoryStorage) {
+	t.Helper()
+
+	processAll(t, NewProcessor(store, true), calendarEmail("REQUEST", localPropertiesEvent))
+
+	event, err := store.GetEvent("local-properties-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+
+	master := cal.Children[0]
+	master.Props.Set(&goical.Prop{Name: "CATEGORIES", Value: "Work"})
+	master.Props.Set(&goical.Prop{Name: "TRANSP", Value: "TRANSPARENT"})
+	master.Props.Set(&goical.Prop{Name: "X-MOZ-LASTACK", Value: "20250305T000000Z"})
+	master.Props.Set(&goical.Prop{Name: "X-MICROSOFT-CDO-BUSYSTATUS", Value: "FREE"})
+	alarm := goical.NewComponent("VALARM")
+	alarm.Props.Set(&goical.Prop{Name: "ACTION", Value: "DISPLAY"})
+	alarm.Props.Set(&goical.Prop{Name: "TRIGGER", Value: "-PT15M"})
+	alarm.Props.Set(&goical.Prop{Name: "DESCRIPTION", Value: "Reminder"})
+	master.Children = append(master.Children, alarm)
+
+	event.RawData, err = ical.EncodeCalendar(cal)
+	if err != nil {
+		t.Fatalf("Failed to encode calendar data: %v", err)
+	}
+	if err := store.StoreEvent(event); err != nil {
+		t.Fatalf("Failed to store event: %v", err)
+	}
+}
+
+// storedMaster returns the first calendar object of a stored event
+func storedMaster(t *testing.T, store *storage.MemoryStorage, uid string) *goical.Component {
+	t.Helper()
+
+	event, err := store.GetEvent(uid)
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+	for _, component := range cal.Children {
+		if ical.IsObjectComponent(component.Name) {
+			return component
+		}
+	}
+	t.Fatalf("No calendar object stored for %s", uid)
+	return nil
+}
+
+const localPropertiesUpdate = `BEGIN:VEVENT
+SUMMARY:Planning (new room)
+DTSTART:20250310T100000Z
+DTEND:20250310T110000Z
+UID:local-properties-event
+SEQUENCE:1
+ORGANIZER:mailto:organizer@example.com
+LOCATION:Room 2
+TRANSP:OPAQUE
+X-MICROSOFT-CDO-BUSYSTATUS:BUSY
+DTSTAMP:20250302T000000Z
+END:VEVENT`
+
+func TestMergePolicy_KeepsLocalProperties(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	storeLocallyEditedEvent(t, store)
+
+	processAll(t, NewProcessor(store, true), calendarEmail("REQUEST", localPropertiesUpdate))
+
+	master := storedMaster(t, store, "local-properties-event")
+	expected := map[string]string{
+		// From the organizer
+		"SUMMARY":  "Planning (new room)",
+		"LOCATION": "Room 2",
+		"SEQUENCE": "1",
+		// Organizer extensions are updated, prefix matches only fill gaps
+		"X-MICROSOFT-CDO-BUSYSTATUS": "BUSY",
+		// Local
+		"CATEGORIES":    "Work",
+		"TRANSP":        "TRANSPARENT",
+		"X-MOZ-LASTACK": "20250305T000000Z",
+	}
+	for name, want := range expected {
+		if prop := master.Props.Get(name); prop == nil || prop.Value != want {
+			t.Errorf("Expected %s to be %q, got %v", name, want, prop)
+		}
+	}
+
+	alarms := 0
+	for _, child := range master.Children {
+		if child.Name == "VALARM" {
+			alarms++
+		}
+	}
+	if alarms != 1 {
+		t.Errorf("Expected the local VALARM to be kept, got %d alarms", alarms)
+	}
+}
+
+func TestMergePolicy_Configured(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	storeLocallyEditedEvent(t, store)
+
+	proc, err := NewProcessorFromConfig(store, ProcessorConfig{
+		ProcessReplies:  true,
+		LocalProperties: []string{"categories"},
+		LocalComponents: []string{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create processor: %v", err)
+	}
+	processAll(t, proc, calendarEmail("REQUEST", localPropertiesUpdate))
+
+	master := storedMaster(t, store, "local-properties-event")
+	if prop := master.Props.Get("CATEGORIES"); prop == nil || prop.Value != "Work" {
+		t.Errorf("Expected CATEGORIES to be kept, got %v", prop)
+	}
+	if prop := master.Props.Get("TRANSP"); prop == nil || prop.Value != "OPAQUE" {
+		t.Errorf("Expected the organizer's TRANSP, got %v", prop)
+	}
+	if prop := master.Props.Get("X-MOZ-LASTACK"); prop != nil {
+		t.Errorf("Expected X-MOZ-LASTACK to be dropped, got %v", prop)
+	}
+	for _, child := range master.Children {
+		if child.Name == "VALARM" {
+			t.Error("Expected the VALARM to be dropped without local components")
+		}
+	}
+}
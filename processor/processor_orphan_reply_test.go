@@ -0,0 +1,256 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const orphanReplyRequestEmail = `From: organizer@example.com
+To: attendee@example.com
+Subject: Planning
+MIME-Version: 1.0
+Content-Type: text/calendar; method=REQUEST; charset=UTF-8
+
+BEGIN:VCALENDAR
+PRODID:-//Test//EN
+VERSION:2.0
+METHOD:REQUEST
+BEGIN:VEVENT
+SUMMARY:Planning
+DTSTART:20250301T100000Z
+DTEND:20250301T110000Z
+UID:orphan-reply-event
+SEQUENCE:0
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250201T000000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+const orphanReplyEmail = `From: attendee@example.com
+To: organizer@example.com
+Subject: Accepted: Planning
+MIME-Version: 1.0
+Content-Type: text/calendar; method=REPLY; charset=UTF-8
+
+BEGIN:VCALENDAR
+PRODID:-//Test//EN
+VERSION:2.0
+METHOD:REPLY
+BEGIN:VEVENT
+UID:orphan-reply-event
+SEQUENCE:0
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=ACCEPTED:mailto:attendee@example.com
+DTSTAMP:20250202T000000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+// attendeePartstat returns the PARTSTAT of an attendee of the master VEVENT
+func attendeePartstat(t *testing.T, event *ical.Event, attendee string) string {
+	t.Helper()
+
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+	for _, component := range cal.Children {
+		if component.Name != "VEVENT" || component.Props.Get("RECURRENCE-ID") != nil {
+			continue
+		}
+		for _, prop := range component.Props.Values("ATTENDEE") {
+			if prop.Value == attendee {
+				return prop.Params.Get("PARTSTAT")
+			}
+		}
+	}
+	return ""
+}
+
+func TestOrphanReply_Pending(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pending PendingReplyStore
+	}{
+		{"memory", NewMemoryPendingReplyStore()},
+		{"dir", mustDirPendingReplyStore(t)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			proc := NewProcessor(store, true)
+			proc.OrphanReplies = OrphanRepliesPending
+			proc.PendingReplies = tc.pending
+
+			msg, err := proc.ProcessEmail(strings.NewReader(orphanReplyEmail))
+			if err != nil {
+				t.Fatalf("Failed to process reply: %v", err)
+			}
+			t.Logf("Reply result: %s", msg)
+
+			if count := store.GetEventCount(); count != 0 {
+				t.Fatalf("Expected the orphan reply not to be stored, got %d events", count)
+			}
+
+			msg, err = proc.ProcessEmail(bytes.NewBufferString(orphanReplyRequestEmail))
+			if err != nil {
+				t.Fatalf("Failed to process request: %v", err)
+			}
+			t.Logf("Request result: %s", msg)
+
+			event, err := store.GetEvent("orphan-reply-event")
+			if err != nil {
+				t.Fatalf("Failed to retrieve event: %v", err)
+			}
+			if got := attendeePartstat(t, event, "mailto:attendee@example.com"); got != "ACCEPTED" {
+				t.Errorf("Expected pending reply to set PARTSTAT=ACCEPTED, got %q", got)
+			}
+
+			replies, err := tc.pending.Replies("orphan-reply-event")
+			if err != nil {
+				t.Fatalf("Failed to read replies: %v", err)
+			}
+			if len(replies) != 0 {
+				t.Errorf("Expected pending replies to be consumed, got %d", len(replies))
+			}
+		})
+	}
+}
+
+func TestOrphanReply_Drop(t *testing.T) {
+	for _, policy := range []string{OrphanRepliesDrop, ""} {
+		t.Run("policy "+policy, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			proc := NewProcessor(store, true)
+			proc.OrphanReplies = policy
+
+			msg, err := proc.ProcessEmail(strings.NewReader(orphanReplyEmail))
+			if err != nil {
+				t.Fatalf("Failed to process reply: %v", err)
+			}
+			if !strings.HasPrefix(msg, "Skipped") {
+				t.Errorf("Expected a skipped result, got %q", msg)
+			}
+			if count := store.GetEventCount(); count != 0 {
+				t.Errorf("Expected the orphan reply not to be stored, got %d events", count)
+			}
+		})
+	}
+}
+
+func TestOrphanReply_Store(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.OrphanReplies = OrphanRepliesStore
+
+	if _, err := proc.ProcessEmail(strings.NewReader(orphanReplyEmail)); err != nil {
+		t.Fatalf("Failed to process reply: %v", err)
+	}
+	if count := store.GetEventCount(); count != 1 {
+		t.Errorf("Expected the orphan reply to be stored, got %d events", count)
+	}
+}
+
+func TestOrphanReply_PendingRequiresDir(t *testing.T) {
+	config := ProcessorConfig{ProcessReplies: true, OrphanReplies: OrphanRepliesPending}
+	if _, err := NewProcessorFromConfig(storage.NewMemoryStorage(), config); err == nil {
+		t.Errorf("Expected an error for the pending policy without pending_replies_dir")
+	}
+
+	config.PendingRepliesDir = t.TempDir()
+	proc, err := NewProcessorFromConfig(storage.NewMemoryStorage(), config)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %v", err)
+	}
+	if _, ok := proc.PendingReplies.(*DirPendingReplyStore); !ok {
+		t.Errorf("Expected replies to be parked on disk, got %T", proc.PendingReplies)
+	}
+
+	// Without a store the reply is not reported as parked
+	proc.PendingReplies = nil
+	if _, err := proc.ProcessEmail(strings.NewReader(orphanReplyEmail)); !errors.Is(err, ErrTemporary) {
+		t.Errorf("Expected a temporary error without a pending reply store, got %v", err)
+	}
+}
+
+func mustDirPendingReplyStore(t *testing.T) *DirPendingReplyStore {
+	t.Helper()
+	pending, err := NewDirPendingReplyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create pending reply store: %v", err)
+	}
+	return pending
+}
+
+// flakyStorage fails to store while failing is set
+type flakyStorage struct {
+	*storage.MemoryStorage
+	failing bool
+	stores  int
+}
+
+func (s *flakyStorage) StoreEvent(event *ical.Event) error {
+	s.stores++
+	if s.failing && s.stores > 1 {
+		return fmt.Errorf("connection reset: %w", storage.ErrUnavailable)
+	}
+	return s.MemoryStorage.StoreEvent(event)
+}
+
+func TestOrphanReply_PendingKeptOnFailure(t *testing.T) {
+	pending := mustDirPendingReplyStore(t)
+	store := &flakyStorage{MemoryStorage: storage.NewMemoryStorage(), failing: true}
+	var logs bytes.Buffer
+	proc := NewProcessor(store, true)
+	proc.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	proc.OrphanReplies = OrphanRepliesPending
+	proc.PendingReplies = pending
+
+	if _, err := proc.ProcessEmail(strings.NewReader(orphanReplyEmail)); err != nil {
+		t.Fatalf("Failed to process reply: %v", err)
+	}
+	if err := pending.AddReply(&ical.Event{UID: "orphan-reply-event", RawData: []byte("not a calendar")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The event is stored, but not with the replies: they stay parked
+	if _, err := proc.ProcessEmail(strings.NewReader(orphanReplyRequestEmail)); !errors.Is(err, ErrTemporary) {
+		t.Fatalf("Expected a temporary error, got %v", err)
+	}
+	if replies, err := pending.Replies("orphan-reply-event"); err != nil || len(replies) != 2 {
+		t.Fatalf("Expected the replies to stay parked, got %d (%v)", len(replies), err)
+	}
+
+	// Delivering the invitation again applies them
+	store.failing = false
+	msg, err := proc.ProcessEmail(strings.NewReader(orphanReplyRequestEmail))
+	if err != nil {
+		t.Fatalf("Failed to process request again: %v", err)
+	}
+	if !strings.Contains(msg, "applied 1 pending replies") {
+		t.Errorf("Expected the pending reply to be applied, got %q", msg)
+	}
+	event, err := store.GetEvent("orphan-reply-event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attendeePartstat(t, event, "mailto:attendee@example.com"); got != "ACCEPTED" {
+		t.Errorf("Expected pending reply to set PARTSTAT=ACCEPTED, got %q", got)
+	}
+	if replies, err := pending.Replies("orphan-reply-event"); err != nil || len(replies) != 0 {
+		t.Errorf("Expected the replies to be removed, got %d (%v)", len(replies), err)
+	}
+
+	// The broken reply is dropped with a warning
+	if !strings.Contains(logs.String(), "Dropping pending reply") {
+		t.Errorf("Expected a warning for the broken reply, got %s", logs.String())
+	}
+}
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// selfPartstatEvent is an invitation for attendee@example.com with the
+// SEQUENCE, the attendee's PARTSTAT and the LOCATION to be filled in
+const selfPartstatEvent = `BEGIN:VEVENT
+SUMMARY:Review
+DTSTART:20250310T100000Z
+DTEND:20250310T110000Z
+UID:self-partstat-event
+SEQUENCE:%d
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=%s;RSVP=TRUE:mailto:Attendee@Example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:colleague@example.com
+LOCATION:%s
+DTSTAMP:2025030%dT000000Z
+END:VEVENT`
+
+func selfPartstatEmail(sequence int, partstat, location string) string {
+	return calendarEmail("REQUEST", fmt.Sprintf(selfPartstatEvent, sequence, partstat, location, sequence+1))
+}
+
+func newSelfPartstatProcessor(t *testing.T, store *storage.MemoryStorage) *Processor {
+	t.Helper()
+
+	proc, err := NewProcessorFromConfig(store, ProcessorConfig{
+		ProcessReplies: true,
+		SelfAddresses:  []string{"attendee@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create processor: %v", err)
+	}
+	return proc
+}
+
+func TestSelfPartstat_KeptOnMinorUpdate(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := newSelfPartstatProcessor(t, store)
+
+	processAll(t, proc,
+		selfPartstatEmail(0, "ACCEPTED", "Room 1"),
+		selfPartstatEmail(1, "NEEDS-ACTION", "Room 1"),
+	)
+
+	event, err := store.GetEvent("self-partstat-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	if status := attendeePartstat(t, event, "mailto:Attendee@Example.com"); status != "ACCEPTED" {
+		t.Errorf("Expected own PARTSTAT ACCEPTED to be kept, got %s", status)
+	}
+	if event.Sequence != 1 {
+		t.Errorf("Expected the update to be stored, got SEQUENCE %d", event.Sequence)
+	}
+}
+
+func TestSelfPartstat_ResetOnSignificantChange(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := newSelfPartstatProcessor(t, store)
+
+	processAll(t, proc,
+		selfPartstatEmail(0, "ACCEPTED", "Room 1"),
+		selfPartstatEmail(1, "NEEDS-ACTION", "Room 2"),
+	)
+
+	event, err := store.GetEvent("self-partstat-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	if status := attendeePartstat(t, event, "mailto:Attendee@Example.com"); status != "NEEDS-ACTION" {
+		t.Errorf("Expected own PARTSTAT to be reset after a location change, got %s", status)
+	}
+}
+
+func TestSelfPartstat_WithoutSelfAddresses(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		selfPartstatEmail(0, "ACCEPTED", "Room 1"),
+		selfPartstatEmail(1, "NEEDS-ACTION", "Room 1"),
+	)
+
+	event, err := store.GetEvent("self-partstat-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	if status := attendeePartstat(t, event, "mailto:Attendee@Example.com"); status != "NEEDS-ACTION" {
+		t.Errorf("Expected the organizer's PARTSTAT without self addresses, got %s", status)
+	}
+}
@@ -0,0 +1,360 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const outlookTimezone = `BEGIN:VTIMEZONE
+TZID:W. Europe Standard Time
+BEGIN:STANDARD
+DTSTART:16011028T030000
+RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:16010325T020000
+RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+END:DAYLIGHT
+END:VTIMEZONE`
+
+// countExceptions returns the number of VEVENT components with RECURRENCE-ID
+// and the SUMMARY of the last one
+func countExceptions(t *testing.T, store *storage.MemoryStorage, uid string) (int, string) {
+	t.Helper()
+
+	event, err := store.GetEvent(uid)
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+
+	count := 0
+	summary := ""
+	for _, component := range cal.Children {
+		if component.Name != "VEVENT" || component.Props.Get("RECURRENCE-ID") == nil {
+			continue
+		}
+		count++
+		if prop := component.Props.Get("SUMMARY"); prop != nil {
+			summary = prop.Value
+		}
+	}
+	return count, summary
+}
+
+// processAll processes a sequence of calendar emails and fails on errors
+func processAll(t *testing.T, proc *Processor, emails ...string) {
+	t.Helper()
+	for i, email := range emails {
+		msg, err := proc.ProcessEmail(strings.NewReader(email))
+		if err != nil {
+			t.Fatalf("Failed to process email %d: %v", i+1, err)
+		}
+		t.Logf("Email %d result: %s", i+1, msg)
+	}
+}
+
+// TestRecurrenceID_UTCAndTZID tests that an instance update addressed in UTC
+// and a later one addressed in the local zone update the same exception
+func TestRecurrenceID_UTCAndTZID(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=Europe/Berlin:20250926T120000
+DTEND;TZID=Europe/Berlin:20250926T130000
+UID:tz-recurring-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250901T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe (moved)
+DTSTART:20251003T120000Z
+DTEND:20251003T130000Z
+UID:tz-recurring-event
+SEQUENCE:1
+RECURRENCE-ID:20251003T100000Z
+DTSTAMP:20250902T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe (moved again)
+DTSTART;TZID=Europe/Berlin:20251003T150000
+DTEND;TZID=Europe/Berlin:20251003T160000
+UID:tz-recurring-event
+SEQUENCE:2
+RECURRENCE-ID;TZID=Europe/Berlin:20251003T120000
+DTSTAMP:20250903T000000Z
+END:VEVENT`),
+	)
+
+	count, summary := countExceptions(t, store, "tz-recurring-event")
+	if count != 1 {
+		t.Errorf("Expected 1 exception for the same occurrence, got %d", count)
+	}
+	if summary != "Jour fixe (moved again)" {
+		t.Errorf("Expected the exception to be replaced by the latest update, got %q", summary)
+	}
+}
+
+// TestRecurrenceID_VTIMEZONE tests that a TZID that is only defined by a
+// VTIMEZONE component is resolved when matching instances
+func TestRecurrenceID_VTIMEZONE(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", outlookTimezone+`
+BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=W. Europe Standard Time:20250926T120000
+DTEND;TZID=W. Europe Standard Time:20250926T130000
+UID:vtimezone-recurring-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250901T000000Z
+END:VEVENT`),
+		calendarEmail("CANCEL", outlookTimezone+`
+BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=W. Europe Standard Time:20251003T120000
+UID:vtimezone-recurring-event
+SEQUENCE:1
+RECURRENCE-ID;TZID=W. Europe Standard Time:20251003T120000
+DTSTAMP:20250902T000000Z
+END:VEVENT`),
+		calendarEmail("CANCEL", `BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART:20251003T100000Z
+UID:vtimezone-recurring-event
+SEQUENCE:1
+RECURRENCE-ID:20251003T100000Z
+DTSTAMP:20250902T000000Z
+END:VEVENT`),
+	)
+
+	count, _ := countExceptions(t, store, "vtimezone-recurring-event")
+	if count != 1 {
+		t.Errorf("Expected 1 cancelled exception, got %d", count)
+	}
+}
+
+// TestRecurrenceID_DateValue tests that a DATE RECURRENCE-ID matches the
+// DATE-TIME one of the same occurrence
+func TestRecurrenceID_DateValue(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=Europe/Berlin:20250926T120000
+DTEND;TZID=Europe/Berlin:20250926T130000
+UID:date-recurring-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250901T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe (moved)
+DTSTART;TZID=Europe/Berlin:20251003T140000
+DTEND;TZID=Europe/Berlin:20251003T150000
+UID:date-recurring-event
+SEQUENCE:1
+RECURRENCE-ID;TZID=Europe/Berlin:20251003T120000
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250902T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe (moved again)
+DTSTART;TZID=Europe/Berlin:20251003T160000
+DTEND;TZID=Europe/Berlin:20251003T170000
+UID:date-recurring-event
+SEQUENCE:2
+RECURRENCE-ID;VALUE=DATE:20251003
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250903T000000Z
+END:VEVENT`),
+	)
+
+	count, summary := countExceptions(t, store, "date-recurring-event")
+	if count != 1 {
+		t.Errorf("Expected 1 exception for the same occurrence, got %d", count)
+	}
+	if summary != "Jour fixe (moved again)" {
+		t.Errorf("Expected the exception to be replaced by the latest update, got %q", summary)
+	}
+}
+
+// TestRecurrenceID_ReplyInOtherForm tests that a REPLY for an instance
+// updates the attendee of the exception even if it uses another form
+func TestRecurrenceID_ReplyInOtherForm(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=Europe/Berlin:20250926T120000
+DTEND;TZID=Europe/Berlin:20250926T130000
+UID:reply-recurring-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+ORGANIZER:mailto:organizer@example.com
+DTSTAMP:20250901T000000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Jour fixe (moved)
+DTSTART;TZID=Europe/Berlin:20251003T140000
+DTEND;TZID=Europe/Berlin:20251003T150000
+UID:reply-recurring-event
+SEQUENCE:1
+RECURRENCE-ID;TZID=Europe/Berlin:20251003T120000
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250902T000000Z
+END:VEVENT`),
+		calendarEmail("REPLY", `BEGIN:VEVENT
+UID:reply-recurring-event
+SEQUENCE:1
+RECURRENCE-ID:20251003T100000Z
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=DECLINED:mailto:attendee@example.com
+DTSTAMP:20250903T000000Z
+END:VEVENT`),
+	)
+
+	event, err := store.GetEvent("reply-recurring-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	if !strings.Contains(string(event.RawData), "PARTSTAT=DECLINED") {
+		t.Errorf("Expected the reply to update the exception attendee, got:\n%s", event.RawData)
+	}
+	if count, _ := countExceptions(t, store, "reply-recurring-event"); count != 1 {
+		t.Errorf("Expected the reply not to add an exception, got %d", count)
+	}
+}
+
+// TestParentUpdate_KeepsTimezones tests that a master update keeps the time
+// zone definitions the preserved exceptions refer to and defines the zones
+// the update uses
+func TestParentUpdate_KeepsTimezones(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", outlookTimezone+`
+BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=W. Europe Standard Time:20250926T120000
+DTEND;TZID=W. Europe Standard Time:20250926T130000
+UID:timezone-merge-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250901T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", outlookTimezone+`
+BEGIN:VEVENT
+SUMMARY:Jour fixe (moved)
+DTSTART;TZID=W. Europe Standard Time:20251003T140000
+DTEND;TZID=W. Europe Standard Time:20251003T150000
+UID:timezone-merge-event
+SEQUENCE:1
+RECURRENCE-ID;TZID=W. Europe Standard Time:20251003T120000
+DTSTAMP:20250902T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", `BEGIN:VEVENT
+SUMMARY:Jour fixe (new room)
+DTSTART;TZID=Europe/Berlin:20250926T120000
+DTEND;TZID=Europe/Berlin:20250926T130000
+UID:timezone-merge-event
+SEQUENCE:2
+RRULE:FREQ=WEEKLY;COUNT=4
+LOCATION:Room 2
+DTSTAMP:20250903T000000Z
+END:VEVENT`),
+	)
+
+	event, err := store.GetEvent("timezone-merge-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+
+	timezones := make(map[string]int)
+	for _, component := range cal.Children {
+		if component.Name == "VTIMEZONE" {
+			timezones[component.Props.Get("TZID").Value]++
+		}
+	}
+	for _, tzid := range []string{"W. Europe Standard Time", "Europe/Berlin"} {
+		if timezones[tzid] != 1 {
+			t.Errorf("Expected one VTIMEZONE for %s, got %d", tzid, timezones[tzid])
+		}
+	}
+
+	count, summary := countExceptions(t, store, "timezone-merge-event")
+	if count != 1 || summary != "Jour fixe (moved)" {
+		t.Errorf("Expected the moved exception to be kept, got %d exceptions (%q)", count, summary)
+	}
+}
+
+// TestRecurrenceID_VTIMEZONEAcrossDST tests that an exception after a DST
+// change is still an occurrence of a master in a VTIMEZONE-only zone when
+// the master is updated
+func TestRecurrenceID_VTIMEZONEAcrossDST(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+	proc.OrphanedExceptions = OrphanedExceptionsDrop
+
+	master := func(summary string) string {
+		return outlookTimezone + `
+BEGIN:VEVENT
+SUMMARY:` + summary + `
+DTSTART;TZID=W. Europe Standard Time:20250303T100000
+DTEND;TZID=W. Europe Standard Time:20250303T110000
+UID:vtimezone-dst-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=10
+DTSTAMP:20250301T000000Z
+END:VEVENT`
+	}
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", master("Jour fixe")),
+		calendarEmail("REQUEST", outlookTimezone+`
+BEGIN:VEVENT
+SUMMARY:Jour fixe (moved)
+DTSTART;TZID=W. Europe Standard Time:20250407T140000
+DTEND;TZID=W. Europe Standard Time:20250407T150000
+UID:vtimezone-dst-event
+SEQUENCE:0
+RECURRENCE-ID;TZID=W. Europe Standard Time:20250407T100000
+DTSTAMP:20250302T000000Z
+END:VEVENT`),
+		calendarEmail("REQUEST", master("Jour fixe (renamed)")),
+	)
+
+	count, summary := countExceptions(t, store, "vtimezone-dst-event")
+	if count != 1 || summary != "Jour fixe (moved)" {
+		t.Errorf("Expected the exception after the DST change to be kept, got %d exceptions", count)
+	}
+}
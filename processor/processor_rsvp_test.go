@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/outbound"
+	"github.com/mkbrechtel/calmailproc/parser/email"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// recordingSender keeps sent messages instead of delivering them
+type recordingSender struct {
+	messages [][]byte
+	to       [][]string
+}
+
+func (s *recordingSender) Send(from string, to []string, msg []byte) error {
+	s.messages = append(s.messages, msg)
+	s.to = append(s.to, to)
+	return nil
+}
+
+// sentReply parses a sent message and returns the REPLY it carries
+func sentReply(t *testing.T, msg []byte) *email.Email {
+	t.Helper()
+
+	parsed, err := email.Parse(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Failed to parse sent message: %v", err)
+	}
+	if !parsed.HasCalendar || parsed.Event.Method != "REPLY" {
+		t.Fatalf("Expected a METHOD:REPLY message, got %+v", parsed.Event)
+	}
+	return parsed
+}
+
+func newReplyingProcessor(t *testing.T, store *storage.MemoryStorage, trusted ...string) (*Processor, *recordingSender) {
+	t.Helper()
+
+	proc, err := NewProcessorFromConfig(store, ProcessorConfig{
+		ProcessReplies:       true,
+		SelfAddresses:        []string{"attendee@example.com"},
+		AutoAcceptOrganizers: trusted,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create processor: %v", err)
+	}
+	sender := &recordingSender{}
+	proc.Replier = &outbound.Replier{Sender: sender}
+	// The invitations of the tests take place on 2025-03-10
+	proc.Now = func() time.Time { return time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC) }
+	return proc, sender
+}
+
+func TestRSVP(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc, sender := newReplyingProcessor(t, store)
+
+	processAll(t, proc, selfPartstatEmail(0, "NEEDS-ACTION", "Room 1"))
+	if len(sender.messages) != 0 {
+		t.Fatalf("Expected no reply without auto-accept, got %d", len(sender.messages))
+	}
+
+	msg, err := proc.RSVP("self-partstat-event", outbound.PartstatTentative)
+	if err != nil {
+		t.Fatalf("RSVP() error = %v", err)
+	}
+	t.Logf("RSVP result: %s", msg)
+
+	if len(sender.messages) != 1 || sender.to[0][0] != "organizer@example.com" {
+		t.Fatalf("Expected one reply to the organizer, got %v", sender.to)
+	}
+	reply := sentReply(t, sender.messages[0])
+	if reply.Event.UID != "self-partstat-event" {
+		t.Errorf("Expected a reply for self-partstat-event, got %s", reply.Event.UID)
+	}
+
+	event, err := store.GetEvent("self-partstat-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	if status := attendeePartstat(t, event, "mailto:Attendee@Example.com"); status != "TENTATIVE" {
+		t.Errorf("Expected the stored PARTSTAT to be TENTATIVE, got %s", status)
+	}
+
+	if _, err := proc.RSVP("unknown-event", outbound.PartstatAccepted); err == nil {
+		t.Error("Expected an error for an unknown UID")
+	}
+}
+
+func TestAutoAccept(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		trusted  []string
+		accepted bool
+	}{
+		{"trusted organizer", []string{"organizer@example.com"}, true},
+		{"trusted domain", []string{"@example.com"}, true},
+		{"untrusted organizer", []string{"boss@example.org"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			proc, sender := newReplyingProcessor(t, store, tc.trusted...)
+
+			processAll(t, proc,
+				selfPartstatEmail(0, "NEEDS-ACTION", "Room 1"),
+				// A minor update must not be answered again
+				selfPartstatEmail(1, "NEEDS-ACTION", "Room 1"),
+			)
+
+			event, err := store.GetEvent("self-partstat-event")
+			if err != nil {
+				t.Fatalf("Failed to retrieve event: %v", err)
+			}
+			status := attendeePartstat(t, event, "mailto:Attendee@Example.com")
+
+			if tc.accepted {
+				if len(sender.messages) != 1 {
+					t.Fatalf("Expected one reply, got %d", len(sender.messages))
+				}
+				sentReply(t, sender.messages[0])
+				if status != "ACCEPTED" {
+					t.Errorf("Expected the stored PARTSTAT to be ACCEPTED, got %s", status)
+				}
+			} else {
+				if len(sender.messages) != 0 {
+					t.Errorf("Expected no reply, got %d", len(sender.messages))
+				}
+				if status != "NEEDS-ACTION" {
+					t.Errorf("Expected the stored PARTSTAT to stay NEEDS-ACTION, got %s", status)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoAccept_PastInvitation(t *testing.T) {
+	weekly := strings.Replace(selfPartstatEmail(0, "NEEDS-ACTION", "Room 1"),
+		"DTEND:20250310T110000Z", "DTEND:20250310T110000Z\nRRULE:FREQ=WEEKLY;COUNT=4", 1)
+
+	for _, tc := range []struct {
+		name     string
+		email    string
+		now      time.Time
+		accepted bool
+	}{
+		{"upcoming", selfPartstatEmail(0, "NEEDS-ACTION", "Room 1"), time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"over", selfPartstatEmail(0, "NEEDS-ACTION", "Room 1"), time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), false},
+		{"series in progress", weekly, time.Date(2025, 3, 20, 0, 0, 0, 0, time.UTC), true},
+		{"series over", weekly, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			proc, sender := newReplyingProcessor(t, store, "organizer@example.com")
+			proc.Now = func() time.Time { return tc.now }
+
+			processAll(t, proc, tc.email)
+
+			if accepted := len(sender.messages) == 1; accepted != tc.accepted {
+				t.Errorf("Expected accepted = %v, got %d replies", tc.accepted, len(sender.messages))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+func TestStats(t *testing.T) {
+	proc := NewProcessor(storage.NewMemoryStorage(), true)
+	var stats Stats
+	proc.EnableStats(&stats)
+
+	emails := []string{
+		selfPartstatEmail(1, "NEEDS-ACTION", "Room 1"), // created
+		selfPartstatEmail(2, "NEEDS-ACTION", "Room 2"), // updated
+		selfPartstatEmail(0, "NEEDS-ACTION", "Room 0"), // ignored as older
+		orphanReplyRequestEmail,                        // created
+		orphanReplyEmail,                               // reply applied, updated
+		"From: someone@example.com\nSubject: Hello\n\nNo calendar here\n",
+	}
+	for _, email := range emails {
+		if _, err := proc.ProcessEmail(strings.NewReader(email)); err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+
+	// Replies are ignored as configured
+	proc.ProcessReplies = false
+	if _, err := proc.ProcessEmail(strings.NewReader(orphanReplyEmail)); err != nil {
+		t.Fatalf("Failed to process reply: %v", err)
+	}
+
+	want := Stats{
+		Scanned:        7,
+		WithCalendar:   6,
+		Created:        2,
+		Updated:        2,
+		IgnoredOlder:   1,
+		RepliesApplied: 1,
+		RepliesIgnored: 1,
+	}
+	if stats != want {
+		t.Errorf("Expected %+v, got %+v", want, stats)
+	}
+
+	// Failures are counted by category, enabling stats again only switches
+	// the counts
+	proc = NewProcessor(failingStorage{storage.NewMemoryStorage()}, true)
+	proc.EnableStats(&Stats{})
+	stats = Stats{}
+	proc.EnableStats(&stats)
+	proc.ProcessEmail(strings.NewReader(selfPartstatEmail(0, "NEEDS-ACTION", "Room 1")))
+	proc.ProcessEmail(strings.NewReader(calendarEmail("REQUEST", "BEGIN:VEVENT\nUID:broken\nEND:VEVENT")))
+
+	if stats.Scanned != 2 || stats.Errors.Temporary != 1 || stats.Errors.Total() != 2 || stats.Created != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
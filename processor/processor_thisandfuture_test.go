@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// calendarEmail wraps iCalendar data into a minimal invitation email
+func calendarEmail(method, icsData string) string {
+	return fmt.Sprintf(`From: organizer@example.com
+To: attendee@example.com
+Subject: Weekly sync
+MIME-Version: 1.0
+Content-Type: text/calendar; method=%s; charset=UTF-8
+
+BEGIN:VCALENDAR
+PRODID:-//Test//EN
+VERSION:2.0
+METHOD:%s
+%s
+END:VCALENDAR
+`, method, method, strings.TrimSpace(icsData))
+}
+
+const thisAndFutureSeries = `BEGIN:VEVENT
+SUMMARY:Weekly sync
+DTSTART:20250106T100000Z
+DTEND:20250106T110000Z
+UID:tf-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=6
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250101T000000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Weekly sync (early exception)
+DTSTART:20250120T090000Z
+DTEND:20250120T100000Z
+UID:tf-event
+SEQUENCE:1
+RECURRENCE-ID:20250120T100000Z
+DTSTAMP:20250102T000000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Weekly sync (late exception)
+DTSTART:20250203T090000Z
+DTEND:20250203T100000Z
+UID:tf-event
+SEQUENCE:1
+RECURRENCE-ID:20250203T100000Z
+DTSTAMP:20250102T000000Z
+END:VEVENT`
+
+// processThisAndFutureFixture stores the series and then applies a
+// THISANDFUTURE update for the occurrence on 2025-01-27
+func processThisAndFutureFixture(t *testing.T, method, update string) *storage.MemoryStorage {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	if _, err := proc.ProcessEmail(strings.NewReader(calendarEmail("REQUEST", thisAndFutureSeries))); err != nil {
+		t.Fatalf("Failed to process series: %v", err)
+	}
+
+	msg, err := proc.ProcessEmail(strings.NewReader(calendarEmail(method, update)))
+	if err != nil {
+		t.Fatalf("Failed to process THISANDFUTURE update: %v", err)
+	}
+	t.Logf("Update result: %s", msg)
+
+	return store
+}
+
+// splitComponents returns the master and the exceptions keyed by RECURRENCE-ID
+func splitComponents(t *testing.T, store *storage.MemoryStorage, uid string) (*goical.Component, map[string]*goical.Component) {
+	t.Helper()
+
+	event, err := store.GetEvent(uid)
+	if err != nil {
+		t.Fatalf("Failed to retrieve event %s: %v", uid, err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+
+	var master *goical.Component
+	exceptions := make(map[string]*goical.Component)
+	for _, component := range cal.Children {
+		if component.Name != "VEVENT" {
+			continue
+		}
+		if recurrenceID := component.Props.Get("RECURRENCE-ID"); recurrenceID != nil {
+			exceptions[recurrenceID.Value] = component
+		} else {
+			master = component
+		}
+	}
+	if master == nil {
+		t.Fatalf("No master component in event %s", uid)
+	}
+	return master, exceptions
+}
+
+func TestThisAndFuture_SplitsSeries(t *testing.T) {
+	store := processThisAndFutureFixture(t, "REQUEST", `BEGIN:VEVENT
+SUMMARY:Weekly sync (new slot)
+DTSTART:20250127T110000Z
+DTEND:20250127T120000Z
+UID:tf-event
+SEQUENCE:2
+RECURRENCE-ID;RANGE=THISANDFUTURE:20250127T100000Z
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250110T000000Z
+END:VEVENT`)
+
+	if count := store.GetEventCount(); count != 2 {
+		t.Fatalf("Expected the series to be split into 2 objects, got %d", count)
+	}
+
+	master, exceptions := splitComponents(t, store, "tf-event")
+	if rrule := master.Props.Get("RRULE").Value; rrule != "FREQ=WEEKLY;UNTIL=20250127T095959Z" {
+		t.Errorf("Expected master RRULE to be truncated, got %s", rrule)
+	}
+	if _, ok := exceptions["20250120T100000Z"]; !ok {
+		t.Errorf("Expected exception before the split point to be kept")
+	}
+	if _, ok := exceptions["20250203T100000Z"]; ok {
+		t.Errorf("Expected exception after the split point to be removed from the original series")
+	}
+
+	futureMaster, futureExceptions := splitComponents(t, store, "tf-event-R20250127T100000Z")
+	if dtstart := futureMaster.Props.Get("DTSTART").Value; dtstart != "20250127T110000Z" {
+		t.Errorf("Expected future series to start at the new slot, got %s", dtstart)
+	}
+	if rrule := futureMaster.Props.Get("RRULE").Value; rrule != "FREQ=WEEKLY;COUNT=3" {
+		t.Errorf("Expected future series to have the remaining 3 occurrences, got %s", rrule)
+	}
+	if futureMaster.Props.Get("RECURRENCE-ID") != nil {
+		t.Errorf("Future series master must not have a RECURRENCE-ID")
+	}
+	if len(futureExceptions) != 0 {
+		t.Errorf("Expected exceptions of shifted occurrences to be dropped, got %d", len(futureExceptions))
+	}
+}
+
+func TestThisAndFuture_MovesExceptions(t *testing.T) {
+	store := processThisAndFutureFixture(t, "REQUEST", `BEGIN:VEVENT
+SUMMARY:Weekly sync (renamed)
+DTSTART:20250127T100000Z
+DTEND:20250127T110000Z
+UID:tf-event
+SEQUENCE:2
+RECURRENCE-ID;RANGE=THISANDFUTURE:20250127T100000Z
+DTSTAMP:20250110T000000Z
+END:VEVENT`)
+
+	_, futureExceptions := splitComponents(t, store, "tf-event-R20250127T100000Z")
+	exception, ok := futureExceptions["20250203T100000Z"]
+	if !ok {
+		t.Fatalf("Expected exception after the split point to move to the future series")
+	}
+	if uid := exception.Props.Get("UID").Value; uid != "tf-event-R20250127T100000Z" {
+		t.Errorf("Expected moved exception to carry the new UID, got %s", uid)
+	}
+}
+
+func TestThisAndFuture_Cancel(t *testing.T) {
+	store := processThisAndFutureFixture(t, "CANCEL", `BEGIN:VEVENT
+DTSTART:20250127T100000Z
+UID:tf-event
+SEQUENCE:2
+RECURRENCE-ID;RANGE=THISANDFUTURE:20250127T100000Z
+STATUS:CANCELLED
+DTSTAMP:20250110T000000Z
+END:VEVENT`)
+
+	if count := store.GetEventCount(); count != 1 {
+		t.Fatalf("Expected no future series for a cancellation, got %d objects", count)
+	}
+
+	master, exceptions := splitComponents(t, store, "tf-event")
+	if rrule := master.Props.Get("RRULE").Value; rrule != "FREQ=WEEKLY;UNTIL=20250127T095959Z" {
+		t.Errorf("Expected master RRULE to be truncated, got %s", rrule)
+	}
+	if len(exceptions) != 1 {
+		t.Errorf("Expected only the exception before the split point, got %d", len(exceptions))
+	}
+}
+
+func TestThisAndFuture_CancelFromFirstOccurrenceKeepsTimezones(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc,
+		calendarEmail("REQUEST", outlookTimezone+`
+BEGIN:VEVENT
+SUMMARY:Jour fixe
+DTSTART;TZID=W. Europe Standard Time:20250303T100000
+DTEND;TZID=W. Europe Standard Time:20250303T110000
+UID:tf-outlook-event
+SEQUENCE:0
+RRULE:FREQ=WEEKLY;COUNT=4
+DTSTAMP:20250301T000000Z
+END:VEVENT`),
+		calendarEmail("CANCEL", outlookTimezone+`
+BEGIN:VEVENT
+DTSTART;TZID=W. Europe Standard Time:20250303T100000
+UID:tf-outlook-event
+SEQUENCE:1
+RECURRENCE-ID;RANGE=THISANDFUTURE;TZID=W. Europe Standard Time:20250303T100000
+STATUS:CANCELLED
+DTSTAMP:20250302T000000Z
+END:VEVENT`),
+	)
+
+	event, err := store.GetEvent("tf-outlook-event")
+	if err != nil {
+		t.Fatalf("Failed to retrieve event: %v", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar data: %v", err)
+	}
+	if ical.FindTimezone(cal, "W. Europe Standard Time") == nil {
+		t.Fatalf("Expected the VTIMEZONE to be kept")
+	}
+
+	master, _ := splitComponents(t, store, "tf-outlook-event")
+	if status := master.Props.Get("STATUS"); status == nil || status.Value != "CANCELLED" {
+		t.Errorf("Expected the series to be cancelled")
+	}
+	if _, err := ical.ParseDateTime(master.Props.Get("DTSTART"), cal); err != nil {
+		t.Errorf("Expected DTSTART to resolve: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+const todoRequest = `BEGIN:VTODO
+SUMMARY:Review budget
+DUE:20250315T170000Z
+STATUS:NEEDS-ACTION
+UID:todo-assignment
+SEQUENCE:0
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:attendee@example.com
+DTSTAMP:20250301T000000Z
+END:VTODO`
+
+func TestTodo_RequestReplyCancel(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc, calendarEmail("REQUEST", todoRequest))
+
+	event, err := store.GetEvent("todo-assignment")
+	if err != nil {
+		t.Fatalf("Failed to retrieve task: %v", err)
+	}
+	if !event.IsTodo() {
+		t.Errorf("Expected stored object to be a VTODO, got %q", event.Component)
+	}
+	if event.Due.IsZero() || event.Due.Format("20060102T150405Z") != "20250315T170000Z" {
+		t.Errorf("Expected DUE to be parsed, got %v", event.Due)
+	}
+
+	// The assignee accepts and reports progress
+	processAll(t, proc, calendarEmail("REPLY", `BEGIN:VTODO
+UID:todo-assignment
+SEQUENCE:0
+ORGANIZER:mailto:organizer@example.com
+ATTENDEE;PARTSTAT=IN-PROCESS:mailto:attendee@example.com
+DTSTAMP:20250302T000000Z
+END:VTODO`))
+
+	event, err = store.GetEvent("todo-assignment")
+	if err != nil {
+		t.Fatalf("Failed to retrieve task: %v", err)
+	}
+	if !strings.Contains(string(event.RawData), "PARTSTAT=IN-PROCESS") {
+		t.Errorf("Expected the reply to update the assignee, got:\n%s", event.RawData)
+	}
+
+	// The organizer cancels the assignment with a higher SEQUENCE
+	processAll(t, proc,
+		calendarEmail("CANCEL", strings.Replace(strings.Replace(todoRequest,
+			"STATUS:NEEDS-ACTION", "STATUS:CANCELLED", 1),
+			"SEQUENCE:0", "SEQUENCE:1", 1)),
+	)
+
+	event, err = store.GetEvent("todo-assignment")
+	if err != nil {
+		t.Fatalf("Failed to retrieve task: %v", err)
+	}
+	if !strings.Contains(string(event.RawData), "STATUS:CANCELLED") {
+		t.Errorf("Expected the task to be cancelled, got:\n%s", event.RawData)
+	}
+	if strings.Contains(string(event.RawData), "METHOD") {
+		t.Errorf("Expected METHOD to be removed before storage")
+	}
+}
+
+func TestJournal_Request(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	proc := NewProcessor(store, true)
+
+	processAll(t, proc, calendarEmail("PUBLISH", `BEGIN:VJOURNAL
+SUMMARY:Meeting minutes
+DTSTART;VALUE=DATE:20250301
+UID:journal-entry
+DTSTAMP:20250301T000000Z
+END:VJOURNAL`))
+
+	event, err := store.GetEvent("journal-entry")
+	if err != nil {
+		t.Fatalf("Failed to retrieve journal entry: %v", err)
+	}
+	if event.Component != "VJOURNAL" {
+		t.Errorf("Expected stored object to be a VJOURNAL, got %q", event.Component)
+	}
+}
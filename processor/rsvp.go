@@ -0,0 +1,193 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/outbound"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// RSVP answers a stored invitation on behalf of the calendar owner. It sends
+// a METHOD:REPLY with the PARTSTAT to the organizer and records the answer in
+// the stored event.
+func (p *Processor) RSVP(uid, partstat string) (string, error) {
+	event, err := p.Storage.GetEvent(uid)
+	if err != nil {
+		return fmt.Sprintf("Event with UID %s not found", uid), fmt.Errorf("getting event: %w", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		return "Error parsing stored event", fmt.Errorf("parsing event data: %w", err)
+	}
+
+	var master *goical.Component
+	for _, component := range cal.Children {
+		if ical.IsObjectComponent(component.Name) && component.Props.Get("RECURRENCE-ID") == nil {
+			master = component
+			break
+		}
+	}
+	if master == nil {
+		return fmt.Sprintf("No series to answer for event with UID %s", uid),
+			fmt.Errorf("no master component in event %s", uid)
+	}
+
+	return p.sendReply(event, cal, master, partstat)
+}
+
+// autoAccept accepts a stored invitation from a trusted organizer that the
+// calendar owner hasn't answered yet and that doesn't conflict with other
+// events. It reports whether a reply was sent.
+func (p *Processor) autoAccept(request *ical.Event) (bool, error) {
+	if p.Replier == nil || len(p.AutoAcceptOrganizers) == 0 || len(p.SelfAddresses) == 0 {
+		return false, nil
+	}
+
+	requestCal, err := ical.DecodeCalendar(request.RawData)
+	if err != nil {
+		return false, fmt.Errorf("parsing request data: %w", err)
+	}
+	var requested *goical.Component
+	for _, component := range requestCal.Children {
+		if ical.IsObjectComponent(component.Name) {
+			requested = component
+			break
+		}
+	}
+	if requested == nil {
+		return false, nil
+	}
+
+	event, err := p.Storage.GetEvent(request.UID)
+	if err != nil {
+		return false, fmt.Errorf("getting stored event: %w", err)
+	}
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		return false, fmt.Errorf("parsing stored event data: %w", err)
+	}
+
+	// Answer the stored version of what was requested, the request may have
+	// been older than what is stored
+	var stored *goical.Component
+	for _, component := range cal.Children {
+		if ical.IsObjectComponent(component.Name) && matchesRecurrenceID(requested, requestCal, component, cal) {
+			stored = component
+			break
+		}
+	}
+	if stored == nil || !p.awaitsAnswer(stored) {
+		return false, nil
+	}
+
+	// Invitations that are over, like those found when processing an old
+	// maildir again, are not answered anymore
+	answered := &goical.Calendar{Component: &goical.Component{Name: cal.Name, Props: cal.Props}}
+	answered.Children = append(nonObjectChildren(cal), stored)
+	if isOver(answered, p.now()) {
+		return false, nil
+	}
+
+	// Conflicting invitations are left for the calendar owner to answer
+	conflicts, err := p.findConflicts(answered, event.UID)
+	if err != nil {
+		return false, fmt.Errorf("checking for conflicts: %w", err)
+	}
+	if len(conflicts) > 0 {
+		return false, nil
+	}
+
+	if _, err := p.sendReply(event, cal, stored, outbound.PartstatAccepted); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// awaitsAnswer reports whether a component is an active invitation from a
+// trusted organizer that the calendar owner hasn't answered
+func (p *Processor) awaitsAnswer(component *goical.Component) bool {
+	if status := component.Props.Get("STATUS"); status != nil && status.Value == "CANCELLED" {
+		return false
+	}
+	organizer := component.Props.Get("ORGANIZER")
+	if organizer == nil || !p.isTrustedOrganizer(organizer.Value) {
+		return false
+	}
+
+	attendee := p.selfAttendee(component)
+	if attendee == nil {
+		return false
+	}
+	partstat := attendee.Params.Get("PARTSTAT")
+	return partstat == "" || partstat == "NEEDS-ACTION"
+}
+
+// isOver reports whether no occurrence of a calendar ends after now.
+// Recurrences are looked for up to the conflict horizon ahead.
+func isOver(cal *goical.Calendar, now time.Time) bool {
+	first, _, ok := occurrenceSpan(cal)
+	if !ok || first.After(now) {
+		return false
+	}
+	occurrences, err := ical.Occurrences(cal, now, now.Add(conflictHorizon))
+	if err != nil {
+		return false
+	}
+	return len(occurrences) == 0
+}
+
+// isTrustedOrganizer reports whether invitations of an organizer are
+// accepted automatically
+func (p *Processor) isTrustedOrganizer(value string) bool {
+	address := ical.CalendarAddress(value)
+	for _, trusted := range p.AutoAcceptOrganizers {
+		trusted = ical.CalendarAddress(trusted)
+		if strings.HasPrefix(trusted, "@") {
+			if strings.HasSuffix(address, trusted) {
+				return true
+			}
+		} else if address == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+// selfAttendee returns the ATTENDEE property of the calendar owner
+func (p *Processor) selfAttendee(component *goical.Component) *goical.Prop {
+	for _, attendee := range component.Props.Values("ATTENDEE") {
+		if p.isSelfAddress(attendee.Value) {
+			return &attendee
+		}
+	}
+	return nil
+}
+
+// sendReply sends the calendar owner's answer for a component of a stored
+// event and records the new PARTSTAT in storage
+func (p *Processor) sendReply(event *ical.Event, cal *goical.Calendar, component *goical.Component, partstat string) (string, error) {
+	if p.Replier == nil {
+		return "Cannot reply without outbound mail", fmt.Errorf("no outbound mail configured")
+	}
+
+	attendee := p.selfAttendee(component)
+	if attendee == nil {
+		return fmt.Sprintf("Not an attendee of event with UID %s", event.UID),
+			fmt.Errorf("none of the self addresses is an attendee of event %s", event.UID)
+	}
+
+	reply, err := p.Replier.Reply(cal, component, attendee.Value, partstat)
+	if err != nil {
+		return "Error sending reply", markError(ErrTemporary, fmt.Errorf("sending reply: %w", err))
+	}
+
+	// Params is shared with the property stored in the component
+	attendee.Params.Set("PARTSTAT", partstat)
+	attendee.Params.Del("RSVP")
+
+	return p.storeCalendar(event, cal, fmt.Sprintf("Sent %s reply for event with UID %s to %s",
+		partstat, event.UID, reply.Organizer))
+}
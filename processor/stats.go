@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"errors"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// Stats counts what processing emails did, see EnableStats
+type Stats struct {
+	// Scanned is the number of emails processed, WithCalendar the number
+	// of them with calendar data
+	Scanned      int `json:"scanned"`
+	WithCalendar int `json:"with_calendar"`
+	// Created and Updated count the stored objects that were new or
+	// changed, once per email
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	// IgnoredOlder counts updates older than the stored version
+	IgnoredOlder int `json:"ignored_older"`
+	// RepliesApplied counts replies that updated an attendee, including
+	// pending replies applied when their event arrived
+	RepliesApplied int `json:"replies_applied"`
+	// RepliesIgnored counts replies that were not applied by configuration:
+	// all replies while ProcessReplies is off, and replies for unknown
+	// events with the default OrphanRepliesDrop. Invalid replies count as errors.
+	RepliesIgnored int `json:"replies_ignored"`
+	// Errors counts the emails that failed by category
+	Errors ErrorCounts `json:"errors"`
+}
+
+// ErrorCounts counts failed emails by the category of their error
+type ErrorCounts struct {
+	InvalidData int `json:"invalid_data"`
+	Temporary   int `json:"temporary"`
+	Other       int `json:"other"`
+}
+
+// Total returns the number of failed emails
+func (c ErrorCounts) Total() int {
+	return c.InvalidData + c.Temporary + c.Other
+}
+
+// Record counts an error by its category, nil is not counted
+func (c *ErrorCounts) Record(err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTemporary):
+		c.Temporary++
+	case errors.Is(err, ErrInvalidData):
+		c.InvalidData++
+	default:
+		c.Other++
+	}
+}
+
+// EnableStats counts the outcome of all following emails in stats. The
+// storage is wrapped to tell created from updated objects; calling it
+// again only switches to the new stats.
+func (p *Processor) EnableStats(stats *Stats) {
+	if p.counted == nil {
+		p.counted = &statsStorage{Storage: p.Storage}
+		p.Storage = p.counted
+	}
+	p.counted.stats = stats
+	p.Stats = stats
+}
+
+// countStats updates the stats if they are enabled
+func (p *Processor) countStats(update func(stats *Stats)) {
+	if p.Stats != nil {
+		update(p.Stats)
+	}
+}
+
+// statsStorage counts the objects stored while processing an email. An
+// object is updated if the processor found it with GetEvent before, so no
+// extra requests are needed.
+type statsStorage struct {
+	storage.Storage
+	stats *Stats
+
+	// found and stored are the UIDs of the current email
+	found  map[string]bool
+	stored map[string]bool
+}
+
+// reset starts counting a new email
+func (s *statsStorage) reset() {
+	s.found = make(map[string]bool)
+	s.stored = make(map[string]bool)
+}
+
+func (s *statsStorage) GetEvent(uid string) (*ical.Event, error) {
+	event, err := s.Storage.GetEvent(uid)
+	if err == nil && event != nil && s.found != nil {
+		s.found[uid] = true
+	}
+	return event, err
+}
+
+func (s *statsStorage) StoreEvent(event *ical.Event) error {
+	if err := s.Storage.StoreEvent(event); err != nil {
+		return err
+	}
+	if s.stored == nil || s.stored[event.UID] {
+		return nil
+	}
+	s.stored[event.UID] = true
+	if s.found[event.UID] {
+		s.stats.Updated++
+	} else {
+		s.stats.Created++
+	}
+	return nil
+}
@@ -3,13 +3,22 @@ package stdin
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 
+	"github.com/mkbrechtel/calmailproc/logging"
 	"github.com/mkbrechtel/calmailproc/processor"
 )
 
-// Process processes a single email from stdin
-func Process(proc *processor.Processor) error {
+// Process processes a single email from stdin, the result is printed to
+// stdout. logger may be nil. Errors keep the category the processor marked
+// them with, see processor.ErrInvalidData and processor.ErrTemporary.
+func Process(proc *processor.Processor, logger *slog.Logger) error {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	logger.Debug("Reading email from stdin")
+
 	// Process email from stdin
 	msg, err := proc.ProcessEmail(os.Stdin)
 	if err != nil {
@@ -21,7 +30,12 @@ func Process(proc *processor.Processor) error {
 
 // ProcessReader processes a single email from an io.Reader
 // Useful for testing and for cases where the input isn't strictly stdin
-func ProcessReader(r io.Reader, proc *processor.Processor) error {
+func ProcessReader(r io.Reader, proc *processor.Processor, logger *slog.Logger) error {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	logger.Debug("Reading email")
+
 	// Process email from reader
 	msg, err := proc.ProcessEmail(r)
 	if err != nil {
@@ -28,7 +28,7 @@ func TestProcessReader(t *testing.T) {
 	emailReader := bytes.NewReader(emailBytes)
 	
 	// Process the email
-	err = ProcessReader(emailReader, proc)
+	err = ProcessReader(emailReader, proc, nil)
 	if err != nil {
 		t.Fatalf("ProcessReader failed: %v", err)
 	}
@@ -0,0 +1,308 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+	"github.com/mkbrechtel/calmailproc/storage"
+)
+
+// splitUID derives the UID of the future part of a series that was split at
+// the given occurrence
+func splitUID(uid string, split time.Time) string {
+	return fmt.Sprintf("%s-R%s", uid, split.UTC().Format("20060102T150405Z"))
+}
+
+// processThisAndFutureUpdate handles an instance update with
+// RECURRENCE-ID;RANGE=THISANDFUTURE, as sent by Exchange and Google when
+// "this and following events" are edited.
+//
+// The stored series is split at the referenced occurrence: the master RRULE
+// is truncated with an UNTIL and the following occurrences become a new
+// series with its own UID, built from the update. Exceptions after the split
+// point move to the new series if the occurrence times stay the same and are
+// dropped otherwise. A CANCEL only truncates the series.
+func (p *Processor) processThisAndFutureUpdate(existingEvent, newEvent *ical.Event) (string, error) {
+	newCal, err := ical.DecodeCalendar(newEvent.RawData)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("parsing new event data: %w", err)
+	}
+	existingCal, err := ical.DecodeCalendar(existingEvent.RawData)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("parsing existing event data: %w", err)
+	}
+
+	var update *goical.Component
+	for _, component := range newCal.Children {
+		if ical.IsObjectComponent(component.Name) && component.Props.Get("RECURRENCE-ID") != nil {
+			update = component
+			break
+		}
+	}
+	var master *goical.Component
+	for _, component := range existingCal.Children {
+		if ical.IsObjectComponent(component.Name) && component.Props.Get("RECURRENCE-ID") == nil {
+			master = component
+			break
+		}
+	}
+
+	// Without a recurring master there is no series to split, so the update
+	// can only be kept as a plain exception
+	if update == nil || master == nil || master.Props.Get("RRULE") == nil {
+		return p.storeRecurringInstance(existingEvent, newEvent)
+	}
+
+	split, err := ical.ParseDateTime(update.Props.Get("RECURRENCE-ID"), newCal)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("parsing RECURRENCE-ID: %w", err)
+	}
+
+	// A later THISANDFUTURE update for the same occurrence updates the
+	// future part that was split off before
+	futureUID := splitUID(existingEvent.UID, split)
+	existingFuture, err := p.Storage.GetEvent(futureUID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return "Error getting split series", markError(ErrTemporary, fmt.Errorf("getting split series: %w", err))
+	}
+	if err == nil && existingFuture != nil {
+		return p.updateSplitSeries(existingFuture, update, newCal, newEvent.Method)
+	}
+
+	before, err := ical.OccurrencesBefore(master, existingCal, split)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("expanding recurrence: %w", err)
+	}
+
+	// Build the future part before the master is modified
+	var future *goical.Component
+	if newEvent.Method != "CANCEL" {
+		future, err = futureSeries(master, existingCal, update, split, before)
+		if err != nil {
+			return "Error handling recurring instance", fmt.Errorf("building future series: %w", err)
+		}
+	}
+
+	// Separate the exceptions before and after the split point
+	offset := time.Duration(0)
+	if future != nil {
+		newStart, err := ical.ParseDateTime(future.Props.Get("DTSTART"), newCal)
+		if err == nil {
+			offset = newStart.Sub(split)
+		}
+	}
+	var pastChildren, movedExceptions []*goical.Component
+	for _, component := range existingCal.Children {
+		recurrenceID := component.Props.Get("RECURRENCE-ID")
+		if !ical.IsObjectComponent(component.Name) || recurrenceID == nil {
+			pastChildren = append(pastChildren, component)
+			continue
+		}
+
+		occurrence, err := ical.ParseDateTime(recurrenceID, existingCal)
+		if err != nil || occurrence.Before(split) {
+			pastChildren = append(pastChildren, component)
+			continue
+		}
+
+		// Exceptions of shifted occurrences no longer match the new series
+		if future != nil && offset == 0 {
+			component.Props.Set(&goical.Prop{Name: "UID", Value: futureUID})
+			movedExceptions = append(movedExceptions, component)
+		}
+	}
+
+	if before == 0 {
+		// The split is at the first occurrence, so the whole series changes
+		// and the object keeps its UID
+		if future == nil {
+			master.Props.Set(&goical.Prop{Name: "STATUS", Value: "CANCELLED"})
+			existingCal.Children = append(nonObjectChildren(existingCal), master)
+			ical.EnsureTimezones(existingCal)
+			return p.storeCalendar(existingEvent, existingCal,
+				fmt.Sprintf("Cancelled recurring event with UID %s from its first occurrence", existingEvent.UID))
+		}
+
+		future.Props.Set(&goical.Prop{Name: "UID", Value: existingEvent.UID})
+		for _, exception := range movedExceptions {
+			exception.Props.Set(&goical.Prop{Name: "UID", Value: existingEvent.UID})
+		}
+		existingCal.Children = append(nonObjectChildren(existingCal), future)
+		existingCal.Children = append(existingCal.Children, movedExceptions...)
+		ical.MergeTimezones(existingCal, newCal)
+		ical.EnsureTimezones(existingCal)
+		return p.storeCalendar(existingEvent, existingCal,
+			fmt.Sprintf("Updated recurring event with UID %s from its first occurrence", existingEvent.UID))
+	}
+
+	if err := ical.TruncateRecurrence(master, split); err != nil {
+		return "Error handling recurring instance", fmt.Errorf("truncating recurrence: %w", err)
+	}
+	existingCal.Children = pastChildren
+
+	if future != nil {
+		if err := ical.ValidateUID(futureUID); err != nil {
+			return fmt.Sprintf("Invalid UID for split recurring event: %v", err), err
+		}
+
+		futureCal := ical.NewCalendar()
+		futureCal.Children = append(futureCal.Children, nonObjectChildren(existingCal)...)
+		futureCal.Children = append(futureCal.Children, future)
+		futureCal.Children = append(futureCal.Children, movedExceptions...)
+		ical.MergeTimezones(futureCal, newCal)
+		ical.EnsureTimezones(futureCal)
+
+		futureEvent := &ical.Event{
+			UID:      futureUID,
+			Summary:  newEvent.Summary,
+			Sequence: newEvent.Sequence,
+		}
+		if msg, err := p.storeCalendar(futureEvent, futureCal, ""); err != nil {
+			return msg, err
+		}
+	}
+
+	msg := fmt.Sprintf("Split recurring event with UID %s, following occurrences stored with UID %s",
+		existingEvent.UID, futureUID)
+	if future == nil {
+		msg = fmt.Sprintf("Cancelled this and following occurrences of recurring event with UID %s", existingEvent.UID)
+	}
+	return p.storeCalendar(existingEvent, existingCal, msg)
+}
+
+// futureSeries builds the master of the series that continues a split
+// series from the THISANDFUTURE update
+func futureSeries(master *goical.Component, existingCal *goical.Calendar, update *goical.Component, split time.Time, before int) (*goical.Component, error) {
+	future := goical.NewComponent(update.Name)
+	for name, props := range update.Props {
+		future.Props[name] = append([]goical.Prop(nil), props...)
+	}
+	future.Children = update.Children
+	future.Props.Del("RECURRENCE-ID")
+
+	// Updates usually don't repeat the RRULE, so the remaining occurrences
+	// of the original rule are continued
+	if future.Props.Get("RRULE") == nil {
+		rruleProp := *master.Props.Get("RRULE")
+		future.Props.Set(&rruleProp)
+
+		roption, err := master.Props.RecurrenceRule()
+		if err != nil {
+			return nil, err
+		}
+		if roption.Count > 0 {
+			if err := ical.SetRecurrenceCount(future, roption.Count-before); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Keep the excluded dates that fall into the future part
+	for _, exdate := range master.Props.Values("EXDATE") {
+		t, err := ical.ParseDateTime(&exdate, existingCal)
+		if err == nil && !t.Before(split) {
+			future.Props.Add(&exdate)
+		}
+	}
+
+	if future.Props.Get("DTSTAMP") == nil {
+		now := time.Now().UTC().Format("20060102T150405Z")
+		future.Props.Set(&goical.Prop{Name: "DTSTAMP", Value: now})
+	}
+
+	return future, nil
+}
+
+// updateSplitSeries applies a repeated THISANDFUTURE update to the future
+// part of a series that was already split
+func (p *Processor) updateSplitSeries(existingFuture *ical.Event, update *goical.Component, newCal *goical.Calendar, method string) (string, error) {
+	futureCal, err := ical.DecodeCalendar(existingFuture.RawData)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("parsing split event data: %w", err)
+	}
+
+	if method == "CANCEL" {
+		for _, component := range futureCal.Children {
+			if ical.IsObjectComponent(component.Name) && component.Props.Get("RECURRENCE-ID") == nil {
+				component.Props.Set(&goical.Prop{Name: "STATUS", Value: "CANCELLED"})
+			}
+		}
+		return p.storeCalendar(existingFuture, futureCal,
+			fmt.Sprintf("Cancelled split recurring event with UID %s", existingFuture.UID))
+	}
+
+	// Turn the update into a parent update of the future series
+	updatedMaster := goical.NewComponent(update.Name)
+	for name, props := range update.Props {
+		updatedMaster.Props[name] = props
+	}
+	updatedMaster.Children = update.Children
+	updatedMaster.Props.Del("RECURRENCE-ID")
+	updatedMaster.Props.Set(&goical.Prop{Name: "UID", Value: existingFuture.UID})
+
+	for _, component := range futureCal.Children {
+		if ical.IsObjectComponent(component.Name) && component.Props.Get("RECURRENCE-ID") == nil {
+			if updatedMaster.Props.Get("RRULE") == nil && component.Props.Get("RRULE") != nil {
+				updatedMaster.Props.Set(component.Props.Get("RRULE"))
+			}
+			break
+		}
+	}
+
+	parentCal := ical.NewCalendar()
+	for name, props := range newCal.Props {
+		parentCal.Props[name] = props
+	}
+	parentCal.Children = append(parentCal.Children, updatedMaster)
+	parentData, err := ical.EncodeCalendar(parentCal)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("encoding split event update: %w", err)
+	}
+	parentEvent, err := ical.ParseICalData(parentData)
+	if err != nil {
+		return "Error handling recurring instance", fmt.Errorf("parsing split event update: %w", err)
+	}
+
+	comparison, err := ical.CompareEvents(parentEvent, existingFuture)
+	if err != nil {
+		return "Error comparing events", fmt.Errorf("comparing events: %w", err)
+	}
+	if comparison == ical.SecondEventNewer {
+		p.countStats(func(s *Stats) { s.IgnoredOlder++ })
+		return fmt.Sprintf("Not processing older update of split recurring event with UID %s", existingFuture.UID), nil
+	}
+
+	updatedEvent, err := p.handleParentEventUpdate(existingFuture, parentEvent)
+	if err != nil {
+		return "Error handling parent event update", fmt.Errorf("handling parent event update: %w", err)
+	}
+	return p.storeUpdatedEvent(updatedEvent,
+		fmt.Sprintf("Updated split recurring event with UID %s", existingFuture.UID))
+}
+
+// nonObjectChildren returns the components of a calendar that are not
+// calendar objects, such as VTIMEZONE definitions
+func nonObjectChildren(cal *goical.Calendar) []*goical.Component {
+	var children []*goical.Component
+	for _, component := range cal.Children {
+		if !ical.IsObjectComponent(component.Name) {
+			children = append(children, component)
+		}
+	}
+	return children
+}
+
+// storeCalendar encodes a modified calendar for the given event and stores it
+func (p *Processor) storeCalendar(event *ical.Event, cal *goical.Calendar, msg string) (string, error) {
+	calBytes, err := ical.EncodeCalendar(cal)
+	if err != nil {
+		return "Error encoding calendar", fmt.Errorf("encoding updated calendar: %w", err)
+	}
+
+	updatedEvent := *event
+	updatedEvent.RawData = calBytes
+	return p.storeUpdatedEvent(&updatedEvent, msg)
+}
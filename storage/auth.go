@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-webdav"
+)
+
+// Authentication methods for CalDAV servers
+const (
+	// AuthBasic sends the user and password, this is the default
+	AuthBasic = "basic"
+	// AuthBearer sends the password as a static bearer token, like app
+	// tokens
+	AuthBearer = "bearer"
+	// AuthOAuth2 gets access tokens with an OAuth2 refresh token
+	AuthOAuth2 = "oauth2"
+)
+
+// OAuth2Config configures the OAuth2 refresh token flow (RFC 6749, section 6)
+type OAuth2Config struct {
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RefreshToken string   `yaml:"refresh_token"`
+	Scopes       []string `yaml:"scopes"`
+	// TokenCache is a file the current access and refresh token are kept
+	// in between runs. Servers that rotate refresh tokens need it.
+	TokenCache string `yaml:"token_cache"`
+}
+
+// TLSConfig configures client certificates and the trusted CAs
+type TLSConfig struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system ones
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the PEM client certificate and key for
+	// mutual TLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// newHTTPClient builds the HTTP client for a CalDAV server with the
+// configured TLS settings and authentication
+func newHTTPClient(config WebdavConfig) (webdav.HTTPClient, error) {
+	httpClient, err := newTLSClient(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Auth {
+	case "", AuthBasic:
+		pass, err := config.Password()
+		if err != nil {
+			return nil, err
+		}
+		return webdav.HTTPClientWithBasicAuth(httpClient, config.User, pass), nil
+	case AuthBearer:
+		token, err := config.Password()
+		if err != nil {
+			return nil, err
+		}
+		return &authClient{client: httpClient, authorization: func() (string, error) {
+			return "Bearer " + token, nil
+		}}, nil
+	case AuthOAuth2:
+		source, err := newOAuth2Source(config.OAuth2, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		return &authClient{client: httpClient, authorization: source.authorization}, nil
+	default:
+		return nil, fmt.Errorf("unknown CalDAV auth method: %s", config.Auth)
+	}
+}
+
+// newTLSClient returns an HTTP client trusting the CA bundle and
+// presenting the client certificate, if configured
+func newTLSClient(config TLSConfig) (*http.Client, error) {
+	if config.CAFile == "" && config.CertFile == "" && config.KeyFile == "" {
+		return &http.Client{}, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("client certificates need both cert_file and key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// authClient sets the Authorization header of every request
+type authClient struct {
+	client        *http.Client
+	authorization func() (string, error)
+}
+
+func (c *authClient) Do(req *http.Request) (*http.Response, error) {
+	value, err := c.authorization()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", value)
+	return c.client.Do(req)
+}
+
+// oauth2Token is an access token response, also used as the cache format
+type oauth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresIn    int       `json:"expires_in,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// oauth2Source keeps an access token and refreshes it before it expires
+type oauth2Source struct {
+	config OAuth2Config
+	client *http.Client
+
+	mu    sync.Mutex
+	token *oauth2Token
+}
+
+func newOAuth2Source(config OAuth2Config, client *http.Client) (*oauth2Source, error) {
+	if config.TokenURL == "" || config.ClientID == "" {
+		return nil, fmt.Errorf("oauth2 needs token_url and client_id")
+	}
+
+	s := &oauth2Source{config: config, client: client}
+	if config.TokenCache != "" {
+		data, err := os.ReadFile(config.TokenCache)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading token cache: %w", err)
+		}
+		if err == nil {
+			var token oauth2Token
+			if err := json.Unmarshal(data, &token); err != nil {
+				return nil, fmt.Errorf("parsing token cache: %w", err)
+			}
+			s.token = &token
+		}
+	}
+
+	if s.refreshToken() == "" {
+		return nil, fmt.Errorf("oauth2 needs a refresh_token")
+	}
+	return s, nil
+}
+
+// refreshToken returns the newest refresh token, servers may rotate them
+func (s *oauth2Source) refreshToken() string {
+	if s.token != nil && s.token.RefreshToken != "" {
+		return s.token.RefreshToken
+	}
+	return s.config.RefreshToken
+}
+
+// authorization returns the Authorization header value, refreshing the
+// access token if it expires within a minute
+func (s *oauth2Source) authorization() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil || s.token.AccessToken == "" || time.Until(s.token.Expiry) < time.Minute {
+		if err := s.refresh(); err != nil {
+			return "", err
+		}
+	}
+
+	tokenType := s.token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + s.token.AccessToken, nil
+}
+
+// refresh gets a new access token from the token endpoint
+func (s *oauth2Source) refresh() error {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken()},
+		"client_id":     {s.config.ClientID},
+	}
+	if s.config.ClientSecret != "" {
+		form.Set("client_secret", s.config.ClientSecret)
+	}
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+
+	resp, err := s.client.PostForm(s.config.TokenURL, form)
+	if err != nil {
+		return fmt.Errorf("refreshing OAuth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reading OAuth2 token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("refreshing OAuth2 token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token oauth2Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("parsing OAuth2 token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("OAuth2 token response has no access_token")
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.refreshToken()
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	} else {
+		// Without an expiry the token is used for this run only
+		token.Expiry = time.Now().Add(time.Hour)
+	}
+	s.token = &token
+
+	return s.saveCache()
+}
+
+// saveCache writes the token to the cache file, readable by the user only
+func (s *oauth2Source) saveCache() error {
+	if s.config.TokenCache == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.token)
+	if err != nil {
+		return fmt.Errorf("encoding token cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.config.TokenCache), 0700); err != nil {
+		return fmt.Errorf("creating token cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failed write keeps the old
+	// refresh token
+	tmp := s.config.TokenCache + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("writing token cache: %w", err)
+	}
+	if err := os.Rename(tmp, s.config.TokenCache); err != nil {
+		return fmt.Errorf("writing token cache: %w", err)
+	}
+	return nil
+}
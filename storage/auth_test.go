@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authRequest sends a GET through the client built for config and returns
+// the Authorization header the server saw
+func authRequest(t *testing.T, config WebdavConfig, url string) (string, error) {
+	t.Helper()
+
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("X-Seen-Authorization"), nil
+}
+
+// echoAuthServer reflects the Authorization header of requests
+func echoAuthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Authorization", r.Header.Get("Authorization"))
+	}))
+}
+
+func TestBasicAndBearerAuth(t *testing.T) {
+	server := echoAuthServer()
+	defer server.Close()
+
+	got, err := authRequest(t, WebdavConfig{User: "me", Pass: "secret"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Basic bWU6c2VjcmV0" {
+		t.Errorf("Expected basic auth, got %q", got)
+	}
+
+	got, err = authRequest(t, WebdavConfig{Auth: AuthBearer, PassCommand: "echo app-token"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Bearer app-token" {
+		t.Errorf("Expected bearer auth, got %q", got)
+	}
+
+	if _, err := newHTTPClient(WebdavConfig{Auth: "digest", Pass: "secret"}); err == nil {
+		t.Error("Expected an error for an unknown auth method")
+	}
+}
+
+// tokenEndpoint is a fake OAuth2 token endpoint that rotates refresh tokens
+type tokenEndpoint struct {
+	mu       sync.Mutex
+	requests int
+	lifetime int
+}
+
+func (e *tokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("client_id") != "calmailproc" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	if want := fmt.Sprintf("refresh-%d", e.requests); r.Form.Get("refresh_token") != want {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	e.requests++
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("access-%d", e.requests),
+		"token_type":    "bearer",
+		"refresh_token": fmt.Sprintf("refresh-%d", e.requests),
+		"expires_in":    e.lifetime,
+	})
+}
+
+func TestOAuth2Auth(t *testing.T) {
+	endpoint := &tokenEndpoint{lifetime: 3600}
+	tokenServer := httptest.NewServer(endpoint)
+	defer tokenServer.Close()
+	server := echoAuthServer()
+	defer server.Close()
+
+	cache := filepath.Join(t.TempDir(), "state", "token.json")
+	config := WebdavConfig{
+		Auth: AuthOAuth2,
+		OAuth2: OAuth2Config{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "calmailproc",
+			RefreshToken: "refresh-0",
+			TokenCache:   cache,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := authRequest(t, config, server.URL)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i+1, err)
+		}
+		if got != "Bearer access-1" {
+			t.Errorf("Request %d: expected the cached access token, got %q", i+1, got)
+		}
+	}
+	if endpoint.requests != 1 {
+		t.Errorf("Expected one token request, got %d", endpoint.requests)
+	}
+
+	info, err := os.Stat(cache)
+	if err != nil {
+		t.Fatalf("Expected a token cache: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token cache mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// An expired token is refreshed with the rotated refresh token from
+	// the cache, the one in the config is no longer valid
+	var token oauth2Token
+	data, _ := os.ReadFile(cache)
+	if err := json.Unmarshal(data, &token); err != nil {
+		t.Fatal(err)
+	}
+	token.Expiry = time.Now().Add(-time.Minute)
+	data, _ = json.Marshal(token)
+	if err := os.WriteFile(cache, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := authRequest(t, config, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Bearer access-2" {
+		t.Errorf("Expected a refreshed access token, got %q", got)
+	}
+
+	config.OAuth2.TokenCache = ""
+	config.OAuth2.RefreshToken = "revoked"
+	if _, err := authRequest(t, config, server.URL); err == nil {
+		t.Error("Expected an error for a rejected refresh token")
+	}
+}
+
+// testCertificate creates a certificate signed by parent, or a self-signed
+// CA if parent is nil
+func testCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes a certificate and optionally its key as PEM files
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, name+".pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := testCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCert := testCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "calmailproc"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	certFile, keyFile := writePEM(t, dir, "client", clientCert)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Authorization", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	config := WebdavConfig{
+		User: "me",
+		Pass: "secret",
+		TLS:  TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+	}
+	got, err := authRequest(t, config, server.URL)
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %v", err)
+	}
+	if got != "calmailproc" {
+		t.Errorf("Expected the server to see the client certificate, got %q", got)
+	}
+
+	config.TLS.CertFile, config.TLS.KeyFile = "", ""
+	if _, err := authRequest(t, config, server.URL); err == nil {
+		t.Error("Expected the server to reject a request without client certificate")
+	}
+
+	config.TLS.CertFile = certFile
+	if _, err := newHTTPClient(config); err == nil {
+		t.Error("Expected an error for a certificate without key")
+	}
+}
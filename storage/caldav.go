@@ -3,14 +3,19 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
+	"time"
 
 	goical "github.com/emersion/go-ical"
 	"github.com/emersion/go-webdav"
 	"github.com/emersion/go-webdav/caldav"
+	"github.com/mkbrechtel/calmailproc/logging"
 	icalParser "github.com/mkbrechtel/calmailproc/parser/ical"
 )
 
@@ -19,15 +24,42 @@ type WebdavConfig struct {
 	User     string `yaml:"user"`
 	Pass     string `yaml:"pass"`
 	Calendar string `yaml:"calendar"`
+	// TaskCalendar is the collection VTODOs are stored in. Many servers
+	// keep tasks in a separate collection that supports VTODO; if empty
+	// tasks go to Calendar.
+	TaskCalendar string `yaml:"task_calendar"`
+
+	// PassFile and PassCommand are read instead of Pass, see Password
+	PassFile    string `yaml:"pass_file"`
+	PassCommand string `yaml:"pass_command"`
+
+	// Auth is one of the Auth* methods, empty means basic
+	Auth   string       `yaml:"auth"`
+	OAuth2 OAuth2Config `yaml:"oauth2"`
+	TLS    TLSConfig    `yaml:"tls"`
 }
 
 type CalDAVStorage struct {
-	client       *caldav.Client
-	calendarPath string
+	client           *caldav.Client
+	calendarPath     string
+	taskCalendarPath string
+	logger           *slog.Logger
 }
 
 func NewCalDAVStorageFromConfig(config WebdavConfig) (*CalDAVStorage, error) {
-	return NewCalDAVStorage(config.URL, config.User, config.Pass, config.Calendar)
+	httpClient, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newCalDAVStorage(httpClient, config.URL, config.Calendar)
+	if err != nil {
+		return nil, err
+	}
+	if config.TaskCalendar != "" {
+		s.SetTaskCalendar(config.TaskCalendar)
+	}
+	return s, nil
 }
 
 func NewCalDAVStorageFromURL(fullURL string) (*CalDAVStorage, error) {
@@ -61,29 +93,69 @@ func NewCalDAVStorage(serverURL, username, password, calendarPath string) (*CalD
 	httpClient := &http.Client{}
 	authClient := webdav.HTTPClientWithBasicAuth(httpClient, username, password)
 
+	return newCalDAVStorage(authClient, serverURL, calendarPath)
+}
+
+// newCalDAVStorage creates a CalDAVStorage using an authenticating client
+func newCalDAVStorage(authClient webdav.HTTPClient, serverURL, calendarPath string) (*CalDAVStorage, error) {
 	// Create CalDAV client
 	client, err := caldav.NewClient(authClient, serverURL)
 	if err != nil {
 		return nil, fmt.Errorf("creating CalDAV client: %w", err)
 	}
 
+	// Use the calendar path directly (it already contains the full path)
+	fullCalendarPath := normalizeCollectionPath(calendarPath)
+
+	return &CalDAVStorage{
+		client:           client,
+		calendarPath:     fullCalendarPath,
+		taskCalendarPath: fullCalendarPath,
+		logger:           logging.Discard(),
+	}, nil
+}
+
+// SetLogger sets the logger requests to the server are logged to
+func (s *CalDAVStorage) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// SetTaskCalendar sets the collection tasks (VTODO) are stored in
+func (s *CalDAVStorage) SetTaskCalendar(taskCalendarPath string) {
+	s.taskCalendarPath = normalizeCollectionPath(taskCalendarPath)
+}
+
+// normalizeCollectionPath makes sure a collection path starts and ends with /
+func normalizeCollectionPath(path string) string {
 	// Ensure calendar path starts with /
-	if !strings.HasPrefix(calendarPath, "/") {
-		calendarPath = "/" + calendarPath
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
 	}
 
 	// Ensure calendar path ends with /
-	if !strings.HasSuffix(calendarPath, "/") {
-		calendarPath = calendarPath + "/"
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
 	}
 
-	// Use the calendar path directly (it already contains the full path)
-	fullCalendarPath := calendarPath
+	return path
+}
 
-	return &CalDAVStorage{
-		client:       client,
-		calendarPath: fullCalendarPath,
-	}, nil
+// collectionPaths returns the distinct collections objects are stored in
+func (s *CalDAVStorage) collectionPaths() []string {
+	if s.taskCalendarPath == s.calendarPath {
+		return []string{s.calendarPath}
+	}
+	return []string{s.calendarPath, s.taskCalendarPath}
+}
+
+// objectComponent returns the type of the first calendar object in cal
+func objectComponent(cal *goical.Calendar) string {
+	for _, comp := range cal.Children {
+		if icalParser.IsObjectComponent(comp.Name) {
+			return comp.Name
+		}
+	}
+	return ""
 }
 
 // StoreEvent stores a calendar event via CalDAV
@@ -96,9 +168,6 @@ func (s *CalDAVStorage) StoreEvent(event *icalParser.Event) error {
 		return fmt.Errorf("no raw calendar data to store")
 	}
 
-	// Create the event path
-	eventPath := s.calendarPath + event.UID + ".ics"
-
 	// Parse the raw data into an ical.Calendar
 	dec := goical.NewDecoder(bytes.NewReader(event.RawData))
 	cal, err := dec.Decode()
@@ -106,20 +175,52 @@ func (s *CalDAVStorage) StoreEvent(event *icalParser.Event) error {
 		return fmt.Errorf("parsing calendar data: %w", err)
 	}
 
+	// Create the event path, tasks go to the task collection
+	collectionPath := s.calendarPath
+	if objectComponent(cal) == icalParser.ComponentTodo {
+		collectionPath = s.taskCalendarPath
+	}
+	eventPath := collectionPath + event.UID + ".ics"
+
 	// Use PutCalendarObject with the parsed calendar
 	ctx := context.Background()
+	start := time.Now()
 	_, err = s.client.PutCalendarObject(ctx, eventPath, cal)
 	if err != nil {
-		return fmt.Errorf("storing event via CalDAV: %w", err)
+		s.logger.Warn("Storing calendar object failed", "uid", event.UID, "path", eventPath, "error", err)
+		return requestError(fmt.Errorf("storing event via CalDAV: %w", err))
 	}
+	s.logger.Debug("Stored calendar object", "uid", event.UID, "path", eventPath, "duration", time.Since(start))
 
 	return nil
 }
 
-// GetEvent retrieves a calendar event from CalDAV by its UID
+// GetEvent retrieves a calendar object from CalDAV by its UID, looking in
+// the task collection as well
 func (s *CalDAVStorage) GetEvent(uid string) (*icalParser.Event, error) {
+	var lastErr error
+	for _, collectionPath := range s.collectionPaths() {
+		event, err := s.getEventFrom(collectionPath, uid)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if event != nil {
+			return event, nil
+		}
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrNotFound
+}
+
+// getEventFrom retrieves a calendar object from one collection, it returns
+// nil without error if the object does not exist there
+func (s *CalDAVStorage) getEventFrom(collectionPath, uid string) (*icalParser.Event, error) {
 	// Create the event path
-	eventPath := s.calendarPath + uid + ".ics"
+	eventPath := collectionPath + uid + ".ics"
 
 	// Create a multiget request for the specific event
 	req := &caldav.CalendarMultiGet{
@@ -132,13 +233,18 @@ func (s *CalDAVStorage) GetEvent(uid string) (*icalParser.Event, error) {
 
 	// Execute the multiget request
 	ctx := context.Background()
-	objects, err := s.client.MultiGetCalendar(ctx, s.calendarPath, req)
+	objects, err := s.client.MultiGetCalendar(ctx, collectionPath, req)
+	if httpStatus(err) == http.StatusNotFound {
+		// Servers answer a missing href with a 404 response
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("getting event from CalDAV: %w", err)
+		s.logger.Debug("Getting calendar object failed", "uid", uid, "path", eventPath, "error", err)
+		return nil, requestError(fmt.Errorf("getting event from CalDAV: %w", err))
 	}
 
 	if len(objects) == 0 {
-		return nil, fmt.Errorf("event not found")
+		return nil, nil
 	}
 
 	// Get the raw calendar data by encoding it back
@@ -152,16 +258,55 @@ func (s *CalDAVStorage) GetEvent(uid string) (*icalParser.Event, error) {
 
 	// Parse the event to get structured data
 	parsedEvent := &icalParser.Event{
-		UID:     uid,
-		RawData: rawData,
+		UID:       uid,
+		RawData:   rawData,
+		Component: objectComponent(obj.Data),
 	}
 
 	return parsedEvent, nil
 }
 
-// ListEvents lists all events from the CalDAV calendar
+// ListEvents lists all events, tasks and journal entries from the CalDAV
+// calendar and the task collection
 func (s *CalDAVStorage) ListEvents() ([]*icalParser.Event, error) {
-	// Create a calendar query to get all events
+	return s.queryCollections(context.Background(), time.Time{}, time.Time{})
+}
+
+// QueryEvents lets the server select the calendar objects with occurrences
+// in the span using a time-range filter (RFC 4791, section 9.9), which
+// expands recurrences on the server
+func (s *CalDAVStorage) QueryEvents(ctx context.Context, start, end time.Time) ([]*icalParser.Event, error) {
+	return s.queryCollections(ctx, start, end)
+}
+
+// queryCollections queries all component types from their collections,
+// limited to a time range unless start and end are zero
+func (s *CalDAVStorage) queryCollections(ctx context.Context, start, end time.Time) ([]*icalParser.Event, error) {
+	queries := []struct {
+		collectionPath string
+		component      string
+	}{
+		{s.calendarPath, icalParser.ComponentEvent},
+		{s.calendarPath, icalParser.ComponentJournal},
+		{s.taskCalendarPath, icalParser.ComponentTodo},
+	}
+
+	var events []*icalParser.Event
+	for _, q := range queries {
+		collectionEvents, err := s.queryComponents(ctx, q.collectionPath, q.component, start, end)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, collectionEvents...)
+	}
+
+	return events, nil
+}
+
+// queryComponents lists the calendar objects of one component type from a
+// collection, limited to a time range unless start and end are zero
+func (s *CalDAVStorage) queryComponents(ctx context.Context, collectionPath, component string, start, end time.Time) ([]*icalParser.Event, error) {
+	// Create a calendar query to get all objects of the component type
 	query := &caldav.CalendarQuery{
 		CompRequest: caldav.CalendarCompRequest{
 			Name:     "VCALENDAR",
@@ -172,17 +317,18 @@ func (s *CalDAVStorage) ListEvents() ([]*icalParser.Event, error) {
 			Name: "VCALENDAR",
 			Comps: []caldav.CompFilter{
 				{
-					Name: "VEVENT",
+					Name:  component,
+					Start: start,
+					End:   end,
 				},
 			},
 		},
 	}
 
 	// Execute the query
-	ctx := context.Background()
-	objects, err := s.client.QueryCalendar(ctx, s.calendarPath, query)
+	objects, err := s.client.QueryCalendar(ctx, collectionPath, query)
 	if err != nil {
-		return nil, fmt.Errorf("querying CalDAV calendar: %w", err)
+		return nil, requestError(fmt.Errorf("querying CalDAV calendar: %w", err))
 	}
 
 	// Convert CalendarObjects to Events
@@ -194,26 +340,32 @@ func (s *CalDAVStorage) ListEvents() ([]*icalParser.Event, error) {
 		if err := enc.Encode(obj.Data); err != nil {
 			continue // Skip this event if we can't encode it
 		}
-		
+
 		// Extract UID from the calendar data
 		var uid string
 		for _, comp := range obj.Data.Children {
-			if comp.Name == goical.CompEvent {
+			if comp.Name == component {
 				if uidProp := comp.Props.Get(goical.PropUID); uidProp != nil {
 					uid = uidProp.Value
 					break
 				}
 			}
 		}
-		
+
 		if uid == "" {
 			// Fallback to extracting from path if UID not found in data
-			uid = strings.TrimSuffix(strings.TrimPrefix(obj.Path, s.calendarPath), ".ics")
+			uid = strings.TrimSuffix(strings.TrimPrefix(obj.Path, collectionPath), ".ics")
 		}
-		
-		event := &icalParser.Event{
-			UID:     uid,
-			RawData: buf.Bytes(),
+
+		// Parse the summary and times where possible, keeping the raw
+		// object otherwise
+		event, err := icalParser.ParseICalData(buf.Bytes())
+		if err != nil || event.UID != uid {
+			event = &icalParser.Event{
+				UID:       uid,
+				RawData:   buf.Bytes(),
+				Component: component,
+			}
 		}
 		events = append(events, event)
 	}
@@ -221,17 +373,78 @@ func (s *CalDAVStorage) ListEvents() ([]*icalParser.Event, error) {
 	return events, nil
 }
 
-// DeleteEvent deletes a calendar event from CalDAV by its UID
+// DeleteEvent deletes a calendar object from CalDAV by its UID
 func (s *CalDAVStorage) DeleteEvent(uid string) error {
-	// Create the event path
-	eventPath := s.calendarPath + uid + ".ics"
-
-	// Delete the event
 	ctx := context.Background()
-	err := s.client.RemoveAll(ctx, eventPath)
+
+	var err error
+	for _, collectionPath := range s.collectionPaths() {
+		// Create the event path
+		eventPath := collectionPath + uid + ".ics"
+
+		// Delete the event, it is only in one of the collections
+		if err = s.client.RemoveAll(ctx, eventPath); err == nil {
+			s.logger.Debug("Deleted calendar object", "uid", uid, "path", eventPath)
+			return nil
+		}
+	}
+
+	return requestError(fmt.Errorf("deleting event from CalDAV: %w", err))
+}
+
+// CheckCollection verifies that the collection a component type is stored
+// in exists on the server and accepts that component
+func (s *CalDAVStorage) CheckCollection(ctx context.Context, component string) error {
+	collectionPath := s.calendarPath
+	if component == icalParser.ComponentTodo {
+		collectionPath = s.taskCalendarPath
+	}
+
+	calendars, err := s.client.FindCalendars(ctx, collectionPath)
 	if err != nil {
-		return fmt.Errorf("deleting event from CalDAV: %w", err)
+		return requestError(fmt.Errorf("looking up collection %s: %w", collectionPath, err))
 	}
 
-	return nil
-}
\ No newline at end of file
+	for _, calendar := range calendars {
+		if normalizeCollectionPath(calendar.Path) != collectionPath {
+			continue
+		}
+		// Servers that don't announce a component set accept all types
+		if len(calendar.SupportedComponentSet) == 0 {
+			return nil
+		}
+		for _, supported := range calendar.SupportedComponentSet {
+			if strings.EqualFold(supported, component) {
+				return nil
+			}
+		}
+		return fmt.Errorf("collection %s only supports %s", collectionPath, strings.Join(calendar.SupportedComponentSet, ", "))
+	}
+	return fmt.Errorf("%s is not a calendar collection", collectionPath)
+}
+
+// requestError marks the error of a CalDAV request with ErrUnavailable if
+// the server could not be reached or failed with a server error. Client
+// errors like 403 or 404 won't go away by retrying.
+func requestError(err error) error {
+	if code := httpStatus(err); code == 0 || code >= 500 {
+		return unavailable(err)
+	}
+	return err
+}
+
+// httpStatus returns the HTTP status code of a go-webdav error, 0 for
+// other errors. go-webdav keeps its error type internal, so its Code field
+// is read by reflection.
+func httpStatus(err error) int {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct || v.Elem().Type().Name() != "HTTPError" {
+			continue
+		}
+		if code := v.Elem().FieldByName("Code"); code.IsValid() && code.Kind() == reflect.Int {
+			return int(code.Int())
+		}
+	}
+	return 0
+}
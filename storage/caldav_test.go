@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	icalParser "github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+func TestCalDAVUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s, err := NewCalDAVStorage(server.URL, "me", "secret", "/calendar/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := &icalParser.Event{
+		UID:     "unavailable-event",
+		RawData: []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:unavailable-event\r\nDTSTAMP:20250301T090000Z\r\nDTSTART:20250301T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"),
+	}
+	if err := s.StoreEvent(event); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected StoreEvent to fail with ErrUnavailable, got %v", err)
+	}
+	if _, err := s.ListEvents(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ListEvents to fail with ErrUnavailable, got %v", err)
+	}
+	if err := s.DeleteEvent("unavailable-event"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected DeleteEvent to fail with ErrUnavailable, got %v", err)
+	}
+
+	// Invalid data is no problem of the server
+	event.RawData = []byte("not a calendar")
+	if err := s.StoreEvent(event); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected a data error, got %v", err)
+	}
+}
+
+func TestCalDAVNotFound(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"missing href", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<multistatus xmlns="DAV:">
+  <response>
+    <href>/calendar/unknown-event.ics</href>
+    <status>HTTP/1.1 404 Not Found</status>
+  </response>
+</multistatus>`))
+		}},
+		{"not found response", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+
+			s, err := NewCalDAVStorage(server.URL, "me", "secret", "/calendar/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event, err := s.getEventFrom("/calendar/", "unknown-event")
+			if event != nil || err != nil {
+				t.Errorf("Expected no event and no error, got %v, %v", event, err)
+			}
+			if _, err := s.GetEvent("unknown-event"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected a not found error, got %v", err)
+			}
+			if err := s.DeleteEvent("unknown-event"); errors.Is(err, ErrUnavailable) {
+				t.Errorf("Expected no temporary error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCalDAVQueryEventsTimeRange(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "REPORT" {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		if !strings.Contains(string(body), `name="VEVENT"`) {
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><multistatus xmlns="DAV:"></multistatus>`))
+			return
+		}
+		data := fmt.Sprintf(queryEvent, "daily", "20250301T090000Z", "20250301T093000Z", "RRULE:FREQ=DAILY\r\n")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<multistatus xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <response>
+    <href>/calendar/daily.ics</href>
+    <propstat>
+      <prop><C:calendar-data>%s</C:calendar-data></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`, data)
+	}))
+	defer server.Close()
+
+	s, err := NewCalDAVStorage(server.URL, "me", "secret", "/calendar/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	events, err := s.QueryEvents(context.Background(), start, start.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if len(events) != 1 || events[0].UID != "daily" {
+		t.Errorf("Expected the event of the server, got %v", events)
+	}
+
+	// One REPORT per component type, each limited to the span
+	if len(bodies) != 3 {
+		t.Fatalf("Expected 3 queries, got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		if !strings.Contains(body, `start="20250310T000000Z"`) || !strings.Contains(body, `end="20250311T000000Z"`) {
+			t.Errorf("Expected a time-range filter for the span, got %s", body)
+		}
+	}
+
+	// Listing all objects sends no time range
+	bodies = nil
+	if _, err := s.ListEvents(); err != nil {
+		t.Fatalf("ListEvents() error = %v", err)
+	}
+	for _, body := range bodies {
+		if strings.Contains(body, "time-range") {
+			t.Errorf("Expected no time-range filter, got %s", body)
+		}
+	}
+}
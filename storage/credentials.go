@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PasswordEnv is the environment variable the CalDAV password is read from
+// if the configuration has no other source
+const PasswordEnv = "CALDAV_PASSWORD"
+
+// Password returns the CalDAV password from the first source configured:
+// Pass, PassFile, PassCommand or the CALDAV_PASSWORD environment variable
+func (c WebdavConfig) Password() (string, error) {
+	switch {
+	case c.Pass != "":
+		return c.Pass, nil
+	case c.PassFile != "":
+		return readPassFile(c.PassFile)
+	case c.PassCommand != "":
+		return runPassCommand(c.PassCommand)
+	}
+	if pass := os.Getenv(PasswordEnv); pass != "" {
+		return pass, nil
+	}
+	return "", fmt.Errorf("no CalDAV password: set pass, pass_file, pass_command or %s", PasswordEnv)
+}
+
+// readPassFile reads a password from the first line of a file that must
+// not be accessible by other users
+func readPassFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("reading pass_file: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return "", fmt.Errorf("pass_file %s is accessible by other users (mode %04o), restrict it with chmod 600", path, perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading pass_file: %w", err)
+	}
+	return firstLine(data, "pass_file "+path)
+}
+
+// runPassCommand runs a shell command like "pass show caldav" and returns
+// the first line of its output
+func runPassCommand(command string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running pass_command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return firstLine(out, "pass_command")
+}
+
+// firstLine returns the first line of a password source, as password
+// managers put further details on the following lines
+func firstLine(data []byte, source string) (string, error) {
+	line, _, _ := strings.Cut(string(data), "\n")
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" {
+		return "", fmt.Errorf("%s returned an empty password", source)
+	}
+	return line, nil
+}
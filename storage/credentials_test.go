@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "pass")
+	if err := os.WriteFile(passFile, []byte("from-file\nuser: me\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	openFile := filepath.Join(dir, "open")
+	if err := os.WriteFile(openFile, []byte("from-file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(openFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(PasswordEnv, "from-env")
+
+	for _, tc := range []struct {
+		name    string
+		config  WebdavConfig
+		want    string
+		wantErr bool
+	}{
+		{"plain", WebdavConfig{Pass: "plain", PassFile: passFile}, "plain", false},
+		{"file", WebdavConfig{PassFile: passFile, PassCommand: "echo from-command"}, "from-file", false},
+		{"file readable by others", WebdavConfig{PassFile: openFile}, "", true},
+		{"missing file", WebdavConfig{PassFile: filepath.Join(dir, "missing")}, "", true},
+		{"command", WebdavConfig{PassCommand: "printf 'from-command\\nurl: x\\n'"}, "from-command", false},
+		{"failing command", WebdavConfig{PassCommand: "exit 3"}, "", true},
+		{"empty command output", WebdavConfig{PassCommand: "true"}, "", true},
+		{"environment", WebdavConfig{}, "from-env", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.config.Password()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Password() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("Password() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Setenv(PasswordEnv, "")
+	if _, err := (WebdavConfig{}).Password(); err == nil {
+		t.Error("Expected an error without any password source")
+	}
+}
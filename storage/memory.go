@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/mkbrechtel/calmailproc/parser/ical"
 )
@@ -36,7 +38,7 @@ func (m *MemoryStorage) GetEvent(uid string) (*ical.Event, error) {
 
 	event, ok := m.events[uid]
 	if !ok {
-		return nil, fmt.Errorf("event not found")
+		return nil, ErrNotFound
 	}
 	return event, nil
 }
@@ -52,6 +54,24 @@ func (m *MemoryStorage) ListEvents() ([]*ical.Event, error) {
 	return events, nil
 }
 
+// QueryEvents expands the recurrences of all stored events locally
+func (m *MemoryStorage) QueryEvents(ctx context.Context, start, end time.Time) ([]*ical.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*ical.Event
+	for _, event := range m.events {
+		if occursBetween(event, start, end) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
 func (m *MemoryStorage) DeleteEvent(uid string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
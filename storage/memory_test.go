@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mkbrechtel/calmailproc/parser/ical"
+)
+
+// queryEvent is a calendar object with the UID, DTSTART, DTEND and extra
+// properties like an RRULE to be filled in
+const queryEvent = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:%s\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:%s\r\nDTEND:%s\r\n%sEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+func TestMemoryQueryEvents(t *testing.T) {
+	store := NewMemoryStorage()
+	for _, e := range []struct {
+		uid, start, end, extra string
+	}{
+		{"inside", "20250310T100000Z", "20250310T110000Z", ""},
+		{"before", "20250308T100000Z", "20250308T110000Z", ""},
+		{"after", "20250312T100000Z", "20250312T110000Z", ""},
+		{"overlapping-start", "20250309T230000Z", "20250310T010000Z", ""},
+		{"ending-at-start", "20250309T230000Z", "20250310T000000Z", ""},
+		{"starting-at-end", "20250311T000000Z", "20250311T010000Z", ""},
+		{"daily-since-march", "20250301T090000Z", "20250301T093000Z", "RRULE:FREQ=DAILY\r\n"},
+		{"weekly-ended", "20250201T090000Z", "20250201T093000Z", "RRULE:FREQ=WEEKLY;COUNT=3\r\n"},
+		{"weekly-other-day", "20250305T090000Z", "20250305T093000Z", "RRULE:FREQ=WEEKLY\r\n"},
+	} {
+		data := fmt.Sprintf(queryEvent, e.uid, e.start, e.end, e.extra)
+		if err := store.StoreEvent(&ical.Event{UID: e.uid, RawData: []byte(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	day := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name       string
+		start, end time.Time
+		want       []string
+	}{
+		{"one day", day, day.AddDate(0, 0, 1), []string{"daily-since-march", "inside", "overlapping-start"}},
+		{"one hour", day.Add(10 * time.Hour), day.Add(11 * time.Hour), []string{"inside"}},
+		{"following week", day.AddDate(0, 0, 2), day.AddDate(0, 0, 9), []string{"after", "daily-since-march", "weekly-other-day"}},
+		{"long ago", day.AddDate(-1, 0, 0), day.AddDate(-1, 0, 1), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := store.QueryEvents(context.Background(), tc.start, tc.end)
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			var got []string
+			for _, event := range events {
+				got = append(got, event.UID)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.QueryEvents(ctx, day, day.AddDate(0, 0, 1)); err == nil {
+		t.Error("Expected an error for a canceled context")
+	}
+}
+
+func TestMemoryGetEventNotFound(t *testing.T) {
+	s := NewMemoryStorage()
+	if _, err := s.GetEvent("unknown-event"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/mkbrechtel/calmailproc/parser/ical"
 )
 
@@ -9,12 +13,51 @@ type Storage interface {
 	// StoreEvent stores a calendar event in the storage
 	StoreEvent(event *ical.Event) error
 
-	// GetEvent retrieves a calendar event from the storage by its UID
+	// GetEvent retrieves a calendar event from the storage by its UID. It
+	// returns ErrNotFound if there is none.
 	GetEvent(id string) (*ical.Event, error)
 
 	// ListEvents lists all events in the storage
 	ListEvents() ([]*ical.Event, error)
 
+	// QueryEvents lists the events with at least one occurrence overlapping
+	// the span from start to end, taking recurrences into account
+	QueryEvents(ctx context.Context, start, end time.Time) ([]*ical.Event, error)
+
 	// DeleteEvent deletes a calendar event from the storage by its UID
 	DeleteEvent(id string) error
 }
+
+// ErrNotFound is returned by GetEvent if no event with the UID is stored.
+// Test for it with errors.Is.
+var ErrNotFound = errors.New("event not found")
+
+// ErrUnavailable marks errors of a storage that could not be reached or
+// failed, like network and server errors. Retrying later may succeed.
+// Test for it with errors.Is.
+var ErrUnavailable = errors.New("storage unavailable")
+
+// unavailableError marks an error with ErrUnavailable, keeping its message
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string        { return e.err.Error() }
+func (e *unavailableError) Unwrap() error        { return e.err }
+func (e *unavailableError) Is(target error) bool { return target == ErrUnavailable }
+
+// unavailable marks err with ErrUnavailable
+func unavailable(err error) error {
+	return &unavailableError{err: err}
+}
+
+// occursBetween reports whether any occurrence of an event overlaps the span
+// from start to end. Events that can't be parsed never match.
+func occursBetween(event *ical.Event, start, end time.Time) bool {
+	cal, err := ical.DecodeCalendar(event.RawData)
+	if err != nil {
+		return false
+	}
+	occurrences, err := ical.Occurrences(cal, start, end)
+	return err == nil && len(occurrences) > 0
+}